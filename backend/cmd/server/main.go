@@ -23,6 +23,9 @@ func main() {
 	// Load configuration
 	cfg := config.DefaultConfig()
 	cfg.LoadFromEnv()
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 
 	// Ensure data directories exist
 	if err := cfg.EnsureDirectories(); err != nil {
@@ -40,21 +43,13 @@ func main() {
 		log.Fatalf("Failed to initialize library: %v", err)
 	}
 	defer lib.Close()
-
-	// Initial library scan
-	log.Println("Scanning media library...")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-	if err := lib.Scan(ctx); err != nil {
-		log.Printf("Warning: Library scan error: %v", err)
-	}
-	cancel()
-	log.Printf("Found %d movies", len(lib.GetAllMovies()))
+	log.Printf("Loaded %d movies", len(lib.GetAllMovies()))
 
 	// Initialize SSDP server
 	ssdp := dlna.NewSSDPServer(cfg.DLNAUUID, cfg.DLNAFriendlyName, serverAddr)
 
 	// Start SSDP
-	ctx, cancel = context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	if err := ssdp.Start(ctx); err != nil {
@@ -93,6 +88,12 @@ func main() {
 		}
 	}()
 
+	// Initial library scan, in the background so the server answers meanwhile
+	log.Println("Scanning media library...")
+	if _, err := apiHandler.StartScan(); err != nil {
+		log.Printf("Warning: Library scan error: %v", err)
+	}
+
 	// Wait for interrupt signal
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -108,6 +109,7 @@ func main() {
 	if err := server.Shutdown(shutdownCtx); err != nil {
 		log.Printf("HTTP server shutdown error: %v", err)
 	}
+	apiHandler.Close()
 
 	log.Println("Server stopped")
 }
@@ -1,18 +1,21 @@
 package api
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/wysentanu/dlna-movie-cast/internal/config"
 	"github.com/wysentanu/dlna-movie-cast/internal/dlna"
 	"github.com/wysentanu/dlna-movie-cast/internal/library"
+	"github.com/wysentanu/dlna-movie-cast/internal/optimizer"
+	"github.com/wysentanu/dlna-movie-cast/internal/subtitles"
 	"github.com/wysentanu/dlna-movie-cast/internal/transcoder"
+	"github.com/wysentanu/dlna-movie-cast/internal/watcher"
 )
 
 // API holds all the API dependencies
@@ -24,7 +27,16 @@ type API struct {
 	contentDir    *dlna.ContentDirectoryService
 	avTransport   *dlna.AVTransportController
 	streamHandler *transcoder.StreamHandler
+	optimizer     *optimizer.Manager
+	subtitles     *subtitles.Service
+	subProvider   subtitles.Provider // nil when no provider is configured
+	watcher       *watcher.Watcher
 	serverAddr    string
+
+	// Library scan jobs, oldest first
+	scanMu      sync.Mutex
+	scanJobs    []*ScanJob
+	scanRunning *ScanJob // nil when no scan is running
 }
 
 // NewAPI creates a new API instance
@@ -34,25 +46,52 @@ func NewAPI(cfg *config.Config, lib *library.Library, ssdp *dlna.SSDPServer, ser
 		return nil, fmt.Errorf("failed to initialize stream handler: %w", err)
 	}
 
+	subtitleService, err := subtitles.NewService(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize subtitle service: %w", err)
+	}
+
+	var subProvider subtitles.Provider
+	if cfg.OpenSubtitlesAPIKey != "" {
+		provider, err := subtitles.NewOpenSubtitles(cfg)
+		if err != nil {
+			return nil, err
+		}
+		subProvider = provider
+	}
+
+	contentDir := dlna.NewContentDirectoryService(lib, serverAddr)
+
 	return &API{
 		config:        cfg,
 		library:       lib,
 		ssdp:          ssdp,
 		upnp:          dlna.NewUPnPHandler(ssdp.GetUUID(), cfg.DLNAFriendlyName, serverAddr),
-		contentDir:    dlna.NewContentDirectoryService(lib, serverAddr),
+		contentDir:    contentDir,
 		avTransport:   dlna.NewAVTransportController(),
 		streamHandler: streamHandler,
+		optimizer:     optimizer.NewManager(cfg, lib, streamHandler.Transcoder()),
+		subtitles:     subtitleService,
+		subProvider:   subProvider,
+		watcher:       watcher.New(cfg, lib, contentDir.IncrementUpdateID),
 		serverAddr:    serverAddr,
 	}, nil
 }
 
+// Close stops background work started by the API
+func (a *API) Close() {
+	a.stopScan()
+	a.watcher.Stop()
+	a.optimizer.Stop()
+}
+
 // SetupRoutes registers all HTTP routes
 func (a *API) SetupRoutes(mux *http.ServeMux) {
 	// Enable CORS middleware
 	corsHandler := func(h http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 			if r.Method == "OPTIONS" {
@@ -71,10 +110,23 @@ func (a *API) SetupRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/api/cast", corsHandler(a.handleCast))
 	mux.HandleFunc("/api/cast/control", corsHandler(a.handleCastControl))
 	mux.HandleFunc("/api/scan", corsHandler(a.handleScan))
+	mux.HandleFunc("/api/scan/jobs", corsHandler(a.handleScanJobs))
+	mux.HandleFunc("/api/scan/jobs/", corsHandler(a.handleScanJob))
+	mux.HandleFunc("/api/capabilities", corsHandler(a.handleCapabilities))
+	mux.HandleFunc("/api/transcodes", corsHandler(a.handleTranscodes))
+	mux.HandleFunc("/api/hls", corsHandler(a.handleHLSUsage))
+	mux.HandleFunc("/api/cache", corsHandler(a.handleCache))
+	mux.HandleFunc("/api/cache/", corsHandler(a.handleCacheEntry))
+	mux.HandleFunc("/api/profiles", corsHandler(a.handleProfiles))
+	mux.HandleFunc("/api/optimize/jobs", corsHandler(a.handleOptimizeJobs))
+	mux.HandleFunc("/api/optimize/jobs/", corsHandler(a.handleOptimizeJob))
 
 	// Streaming routes
 	mux.HandleFunc("/stream/", a.streamHandler.ServeHTTP)
 
+	// Converted subtitles (WebVTT for browsers and HLS, SRT for TVs)
+	mux.HandleFunc("/subtitles/", corsHandler(subtitles.NewHandler(a.library, a.subtitles).ServeHTTP))
+
 	// DLNA/UPnP routes
 	mux.HandleFunc("/dlna/device.xml", a.upnp.ServeDeviceDescription)
 	mux.HandleFunc("/dlna/ContentDirectory.xml", a.upnp.ServeContentDirectorySCPD)
@@ -83,14 +135,24 @@ func (a *API) SetupRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/dlna/ConnectionManager/control", a.handleConnectionManagerControl)
 }
 
-// handleMovies handles GET /api/movies
+// handleMovies handles GET /api/movies. ?status=offline lists movies whose
+// files are missing or whose media path is unavailable instead.
 func (a *API) handleMovies(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	movies := a.library.GetAllMovies()
+	var movies []*library.Movie
+	switch r.URL.Query().Get("status") {
+	case "", library.StatusOnline:
+		movies = a.library.GetAllMovies()
+	case "offline":
+		movies = a.library.GetOfflineMovies()
+	default:
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
 	summaries := make([]library.MovieSummary, 0, len(movies))
 	for _, movie := range movies {
 		summaries = append(summaries, movie.ToSummary())
@@ -101,11 +163,6 @@ func (a *API) handleMovies(w http.ResponseWriter, r *http.Request) {
 
 // handleMovie handles /api/movies/{id}
 func (a *API) handleMovie(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	// Extract movie ID from path
 	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
 	if len(parts) < 3 {
@@ -114,6 +171,23 @@ func (a *API) handleMovie(w http.ResponseWriter, r *http.Request) {
 	}
 	movieID := parts[2]
 
+	// Check if managing subtitles
+	if len(parts) >= 4 && parts[3] == "subtitles" {
+		a.handleMovieSubtitles(w, r, movieID, parts[4:])
+		return
+	}
+
+	// Check if removing an optimized version
+	if len(parts) == 5 && parts[3] == "versions" {
+		a.handleDeleteVersion(w, r, movieID, parts[4])
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
 	// Check if requesting thumbnail
 	if len(parts) >= 4 && parts[3] == "thumbnail" {
 		a.handleMovieThumbnail(w, r, movieID)
@@ -196,11 +270,40 @@ func (a *API) handleRefreshDevices(w http.ResponseWriter, r *http.Request) {
 
 // CastRequest represents a request to cast a movie
 type CastRequest struct {
-	MovieID       string `json:"movie_id"`
-	DeviceUUID    string `json:"device_uuid"`
-	SubtitlePath  string `json:"subtitle_path,omitempty"`
-	SubtitleIndex int    `json:"subtitle_index,omitempty"`
-	Transcode     bool   `json:"transcode,omitempty"`
+	MovieID    string `json:"movie_id"`
+	DeviceUUID string `json:"device_uuid"`
+	Transcode  bool   `json:"transcode,omitempty"`
+
+	// Subtitle to burn in, by ID or by position in the movie's subtitles
+	SubtitleID    string                    `json:"subtitle_id,omitempty"`
+	SubtitleIndex *int                      `json:"subtitle_index,omitempty"`
+	ForcedSubs    bool                      `json:"forced_subs,omitempty"`    // Forced captions only, for PGS/VobSub tracks
+	SubtitleStyle *transcoder.SubtitleStyle `json:"subtitle_style,omitempty"` // Overrides the server defaults
+
+	// Audio processing
+	AudioChannels int  `json:"audio_channels,omitempty"` // Downmix target, e.g. 2 for TV speakers
+	Loudnorm      bool `json:"loudnorm,omitempty"`
+	NightMode     bool `json:"night_mode,omitempty"`
+}
+
+// subtitle resolves the selected subtitle against the movie. It returns
+// nil when none is selected.
+func (req CastRequest) subtitle(movie *library.Movie) (*library.Subtitle, error) {
+	if req.SubtitleID != "" {
+		sub, ok := movie.FindSubtitle(req.SubtitleID)
+		if !ok {
+			return nil, fmt.Errorf("subtitle not found: %s", req.SubtitleID)
+		}
+		return &sub, nil
+	}
+	if req.SubtitleIndex != nil {
+		n := *req.SubtitleIndex
+		if n < 0 || n >= len(movie.Subtitles) {
+			return nil, fmt.Errorf("subtitle not found: %d", n)
+		}
+		return &movie.Subtitles[n], nil
+	}
+	return nil, nil
 }
 
 // handleCast handles POST /api/cast
@@ -223,6 +326,12 @@ func (a *API) handleCast(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	subtitle, err := req.subtitle(movie)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
 	// Get device
 	device, err := a.ssdp.GetDevice(req.DeviceUUID)
 	if err != nil {
@@ -231,29 +340,43 @@ func (a *API) handleCast(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Build stream URL
-	params := []string{}
+	params := url.Values{}
 
-	isTranscoding := req.Transcode || req.SubtitlePath != "" || req.SubtitleIndex > 0
+	audioProcessing := req.AudioChannels > 0 || req.Loudnorm || req.NightMode
+	isTranscoding := req.Transcode || subtitle != nil || audioProcessing
 
 	var streamURL string
 	if isTranscoding {
-		// Use HLS for transcoding
-		streamURL = a.serverAddr + "/stream/" + movie.ID + "/hls/playlist.m3u8"
-		params = append(params, "transcode=1")
+		// Use HLS for transcoding, through the master playlist so text
+		// subtitles come along as renditions
+		streamURL = a.serverAddr + "/stream/" + movie.ID + "/hls/master.m3u8"
+		params.Set("transcode", "1")
 	} else {
 		// Direct stream
 		streamURL = a.serverAddr + "/stream/" + movie.ID
 	}
 
-	if req.SubtitlePath != "" {
-		params = append(params, "subtitle="+url.QueryEscape(req.SubtitlePath))
+	if subtitle != nil {
+		params.Set("subtitle_id", subtitle.ID)
+		if req.ForcedSubs {
+			params.Set("forced_subs", "1")
+		}
+		if req.SubtitleStyle != nil {
+			req.SubtitleStyle.Encode(params)
+		}
 	}
-	if req.SubtitleIndex > 0 {
-		params = append(params, "subtitle_index="+string(rune(req.SubtitleIndex+'0')))
+	if req.AudioChannels > 0 {
+		params.Set("audio_channels", strconv.Itoa(req.AudioChannels))
+	}
+	if req.Loudnorm {
+		params.Set("loudnorm", "1")
+	}
+	if req.NightMode {
+		params.Set("night_mode", "1")
 	}
 
 	if len(params) > 0 {
-		streamURL += "?" + strings.Join(params, "&")
+		streamURL += "?" + params.Encode()
 	}
 
 	// Set URI on device
@@ -352,27 +475,86 @@ func (a *API) handleCastControl(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, map[string]string{"status": "ok"})
 }
 
-// handleScan handles POST /api/scan
-func (a *API) handleScan(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
+// handleCapabilities handles GET /api/capabilities
+func (a *API) handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	go func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-		defer cancel()
+	respondJSON(w, map[string]interface{}{
+		"features": a.streamHandler.Features(),
+		"ffmpeg":   a.streamHandler.Capabilities(),
+	})
+}
 
-		if err := a.library.Scan(ctx); err != nil {
-			// Log error - can't send to client since we're async
-			println("Scan error:", err.Error())
-		} else {
-			println("Scan completed successfully")
-		}
-		a.contentDir.IncrementUpdateID()
-	}()
+// handleTranscodes handles GET /api/transcodes
+func (a *API) handleTranscodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	transcodes := a.streamHandler.ActiveTranscodes()
+	if transcodes == nil {
+		transcodes = []transcoder.TranscodeInfo{}
+	}
+	respondJSON(w, map[string]interface{}{
+		"scheduler":  a.streamHandler.SchedulerStats(),
+		"transcodes": transcodes,
+	})
+}
+
+// handleHLSUsage handles GET /api/hls
+func (a *API) handleHLSUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-	respondJSON(w, map[string]string{"status": "scanning"})
+	respondJSON(w, a.streamHandler.HLSUsage())
+}
+
+// handleCache handles GET and DELETE /api/cache
+func (a *API) handleCache(w http.ResponseWriter, r *http.Request) {
+	cache := a.streamHandler.Cache()
+	if cache == nil {
+		http.Error(w, "Transcode cache is disabled", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		respondJSON(w, cache.List())
+	case http.MethodDelete:
+		respondJSON(w, map[string]interface{}{
+			"status":  "ok",
+			"removed": cache.PurgeAll(),
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleCacheEntry handles DELETE /api/cache/{key}
+func (a *API) handleCacheEntry(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cache := a.streamHandler.Cache()
+	if cache == nil {
+		http.Error(w, "Transcode cache is disabled", http.StatusNotFound)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/api/cache/")
+	if !cache.Purge(key) {
+		http.Error(w, "Cache entry not found", http.StatusNotFound)
+		return
+	}
+	respondJSON(w, map[string]string{"status": "ok"})
 }
 
 // handleConnectionManagerControl handles ConnectionManager SOAP requests
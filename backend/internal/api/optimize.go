@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/wysentanu/dlna-movie-cast/internal/optimizer"
+	"github.com/wysentanu/dlna-movie-cast/internal/transcoder"
+)
+
+// handleProfiles handles GET /api/profiles
+func (a *API) handleProfiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	respondJSON(w, transcoder.ClientProfiles())
+}
+
+// handleOptimizeJobs handles GET and POST /api/optimize/jobs
+func (a *API) handleOptimizeJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		respondJSON(w, a.optimizer.Jobs())
+	case http.MethodPost:
+		var req optimizer.Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		jobs, err := a.optimizer.Submit(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if jobs == nil {
+			jobs = []optimizer.Job{}
+		}
+		respondJSON(w, jobs)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleOptimizeJob handles /api/optimize/jobs/{id}, /cancel and /retry
+func (a *API) handleOptimizeJob(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/optimize/jobs/"), "/"), "/")
+	jobID := parts[0]
+
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		job, err := a.optimizer.GetJob(jobID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		respondJSON(w, job)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var job optimizer.Job
+	var err error
+	switch parts[1] {
+	case "cancel":
+		job, err = a.optimizer.Cancel(jobID)
+	case "retry":
+		job, err = a.optimizer.Retry(jobID)
+	default:
+		http.Error(w, "Invalid path", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	respondJSON(w, job)
+}
+
+// handleDeleteVersion handles DELETE /api/movies/{id}/versions/{versionID}
+func (a *API) handleDeleteVersion(w http.ResponseWriter, r *http.Request, movieID, versionID string) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := a.library.RemoveVersion(movieID, versionID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	respondJSON(w, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,264 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wysentanu/dlna-movie-cast/internal/library"
+)
+
+// scanHistory is how many scan jobs are kept, including the running one
+const scanHistory = 20
+
+// errScanRunning is returned when a scan is started while another runs
+var errScanRunning = errors.New("a scan is already running")
+
+// ScanJobStatus is the state of a scan job
+type ScanJobStatus string
+
+const (
+	ScanRunning   ScanJobStatus = "running"
+	ScanCompleted ScanJobStatus = "completed"
+	ScanFailed    ScanJobStatus = "failed"
+	ScanCancelled ScanJobStatus = "cancelled"
+)
+
+// ScanJob is one run of the library scan
+type ScanJob struct {
+	ID         string        `json:"id"`
+	Status     ScanJobStatus `json:"status"`
+	Error      string        `json:"error,omitempty"`
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt *time.Time    `json:"finished_at,omitempty"`
+	library.ScanProgress
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// StartScan scans the library in the background. Only one scan runs at a
+// time.
+func (a *API) StartScan() (ScanJob, error) {
+	a.scanMu.Lock()
+	defer a.scanMu.Unlock()
+
+	if a.scanRunning != nil {
+		return ScanJob{}, errScanRunning
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &ScanJob{
+		ID:        uuid.New().String(),
+		Status:    ScanRunning,
+		StartedAt: time.Now(),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	a.scanRunning = job
+	a.scanJobs = append(a.scanJobs, job)
+	if len(a.scanJobs) > scanHistory {
+		a.scanJobs = a.scanJobs[len(a.scanJobs)-scanHistory:]
+	}
+
+	go a.runScan(ctx, job)
+	return job.snapshot(), nil
+}
+
+// runScan runs one scan job and records how it ended
+func (a *API) runScan(ctx context.Context, job *ScanJob) {
+	defer close(job.done)
+
+	err := a.library.Scan(ctx, func(p library.ScanProgress) {
+		a.scanMu.Lock()
+		job.ScanProgress = p
+		a.scanMu.Unlock()
+	})
+
+	a.scanMu.Lock()
+	now := time.Now()
+	job.FinishedAt = &now
+	switch {
+	case ctx.Err() != nil:
+		job.Status = ScanCancelled
+	case err != nil:
+		job.Status = ScanFailed
+		job.Error = err.Error()
+	default:
+		job.Status = ScanCompleted
+	}
+	job.cancel()
+	a.scanRunning = nil
+	s := job.snapshot()
+	a.scanMu.Unlock()
+
+	log.Printf("Scan %s %s: %d files, %d added, %d updated, %d removed, %d failed",
+		s.ID, s.Status, s.Found, s.Added, s.Updated, s.Removed, s.Failed)
+	if err != nil && s.Status == ScanFailed {
+		log.Printf("Scan %s error: %v", s.ID, err)
+	}
+	a.contentDir.IncrementUpdateID()
+}
+
+// snapshot copies a job for callers outside scanMu; callers hold scanMu
+func (j *ScanJob) snapshot() ScanJob {
+	s := *j
+	s.cancel = nil
+	s.done = nil
+	return s
+}
+
+// ScanJobs returns the kept scan jobs, newest first
+func (a *API) ScanJobs() []ScanJob {
+	a.scanMu.Lock()
+	defer a.scanMu.Unlock()
+
+	jobs := make([]ScanJob, 0, len(a.scanJobs))
+	for i := len(a.scanJobs) - 1; i >= 0; i-- {
+		jobs = append(jobs, a.scanJobs[i].snapshot())
+	}
+	return jobs
+}
+
+// GetScanJob returns one scan job
+func (a *API) GetScanJob(id string) (ScanJob, error) {
+	a.scanMu.Lock()
+	defer a.scanMu.Unlock()
+
+	for _, job := range a.scanJobs {
+		if job.ID == id {
+			return job.snapshot(), nil
+		}
+	}
+	return ScanJob{}, fmt.Errorf("scan job not found: %s", id)
+}
+
+// CancelScan stops a running scan job. Movies committed so far are kept.
+func (a *API) CancelScan(id string) (ScanJob, error) {
+	a.scanMu.Lock()
+	defer a.scanMu.Unlock()
+
+	for _, job := range a.scanJobs {
+		if job.ID != id {
+			continue
+		}
+		if job.Status != ScanRunning {
+			return ScanJob{}, fmt.Errorf("scan job is already %s", job.Status)
+		}
+		// runScan records the outcome once the workers stop
+		job.cancel()
+		return job.snapshot(), nil
+	}
+	return ScanJob{}, fmt.Errorf("scan job not found: %s", id)
+}
+
+// stopScan cancels a running scan and waits for it to wind down
+func (a *API) stopScan() {
+	a.scanMu.Lock()
+	job := a.scanRunning
+	a.scanMu.Unlock()
+
+	if job != nil {
+		job.cancel()
+		<-job.done
+	}
+}
+
+// handleScan handles GET, POST and DELETE /api/scan: report the latest
+// scan job, start one or cancel the running one
+func (a *API) handleScan(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		jobs := a.ScanJobs()
+		if len(jobs) == 0 {
+			http.Error(w, "No scan has run", http.StatusNotFound)
+			return
+		}
+		respondJSON(w, jobs[0])
+	case http.MethodPost:
+		job, err := a.StartScan()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		respondJSON(w, job)
+	case http.MethodDelete:
+		a.scanMu.Lock()
+		running := a.scanRunning
+		a.scanMu.Unlock()
+		if running == nil {
+			http.Error(w, "No scan is running", http.StatusConflict)
+			return
+		}
+		job, err := a.CancelScan(running.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		respondJSON(w, job)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleScanJobs handles GET and POST /api/scan/jobs
+func (a *API) handleScanJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		respondJSON(w, a.ScanJobs())
+	case http.MethodPost:
+		job, err := a.StartScan()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		respondJSON(w, job)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleScanJob handles /api/scan/jobs/{id} and /cancel
+func (a *API) handleScanJob(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/scan/jobs/"), "/"), "/")
+	jobID := parts[0]
+
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		job, err := a.GetScanJob(jobID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		respondJSON(w, job)
+		return
+	}
+
+	if parts[1] != "cancel" {
+		http.Error(w, "Invalid path", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, err := a.GetScanJob(jobID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	job, err := a.CancelScan(jobID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	respondJSON(w, job)
+}
@@ -0,0 +1,237 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/wysentanu/dlna-movie-cast/internal/library"
+	"github.com/wysentanu/dlna-movie-cast/internal/subtitles"
+)
+
+// maxSubtitleUploadBytes caps a subtitle upload request, form fields included
+const maxSubtitleUploadBytes = 11 << 20
+
+// SubtitleCharsetRequest overrides the charset of an external subtitle
+type SubtitleCharsetRequest struct {
+	Charset string `json:"charset"` // e.g. "CP1251"; empty reverts to the detected charset
+}
+
+// handleMovieSubtitles routes /api/movies/{id}/subtitles[/...]
+func (a *API) handleMovieSubtitles(w http.ResponseWriter, r *http.Request, movieID string, rest []string) {
+	switch {
+	case len(rest) == 0:
+		a.handleUploadSubtitle(w, r, movieID)
+	case len(rest) == 1 && rest[0] == "search":
+		a.handleSubtitleSearch(w, r, movieID)
+	case len(rest) == 1 && rest[0] == "download":
+		a.handleSubtitleDownload(w, r, movieID)
+	case len(rest) == 1:
+		a.handleManagedSubtitle(w, r, movieID, rest[0])
+	case len(rest) == 2 && rest[1] == "charset":
+		a.handleSubtitleCharset(w, r, movieID, rest[0])
+	default:
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+	}
+}
+
+// handleUploadSubtitle handles POST /api/movies/{id}/subtitles, a multipart
+// form with a "file" and optional language, title, forced and default fields
+func (a *API) handleUploadSubtitle(w http.ResponseWriter, r *http.Request, movieID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxSubtitleUploadBytes)
+	if err := r.ParseMultipartForm(maxSubtitleUploadBytes); err != nil {
+		http.Error(w, "Invalid upload", http.StatusBadRequest)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Missing subtitle file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	meta := library.SubtitleMeta{
+		Language: r.FormValue("language"),
+		Title:    r.FormValue("title"),
+		Forced:   formBool(r.FormValue("forced")),
+		Default:  formBool(r.FormValue("default")),
+	}
+
+	sub, err := a.library.AddSubtitle(movieID, header.Filename, file, meta)
+	if err != nil {
+		writeLibraryError(w, err)
+		return
+	}
+	respondJSON(w, sub)
+}
+
+// handleManagedSubtitle handles PATCH and DELETE /api/movies/{id}/subtitles/{subtitleID}
+func (a *API) handleManagedSubtitle(w http.ResponseWriter, r *http.Request, movieID, subtitleID string) {
+	switch r.Method {
+	case http.MethodPatch:
+		var update library.SubtitleUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		sub, err := a.library.UpdateSubtitle(movieID, subtitleID, update)
+		if err != nil {
+			writeLibraryError(w, err)
+			return
+		}
+		respondJSON(w, sub)
+	case http.MethodDelete:
+		if err := a.library.RemoveSubtitle(movieID, subtitleID); err != nil {
+			writeLibraryError(w, err)
+			return
+		}
+		respondJSON(w, map[string]string{"status": "ok"})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSubtitleCharset handles PUT /api/movies/{id}/subtitles/{subtitleID}/charset
+func (a *API) handleSubtitleCharset(w http.ResponseWriter, r *http.Request, movieID, subtitleID string) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SubtitleCharsetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	sub, err := a.library.SetSubtitleCharset(movieID, subtitleID, req.Charset)
+	if err != nil {
+		writeLibraryError(w, err)
+		return
+	}
+	respondJSON(w, sub)
+}
+
+// SubtitleDownloadRequest picks a search result to add to a movie
+type SubtitleDownloadRequest struct {
+	FileID string `json:"file_id"`
+	library.SubtitleMeta
+}
+
+// handleSubtitleSearch handles GET /api/movies/{id}/subtitles/search?languages=en,fr
+func (a *API) handleSubtitleSearch(w http.ResponseWriter, r *http.Request, movieID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if a.subProvider == nil {
+		http.Error(w, "No subtitle provider configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	movie, err := a.library.GetMovie(movieID)
+	if err != nil {
+		http.Error(w, "Movie not found", http.StatusNotFound)
+		return
+	}
+
+	query := subtitles.SearchQuery{Title: movie.Title, Year: movie.Year}
+	if hash, err := subtitles.MovieHash(movie.FilePath); err == nil {
+		query.Hash = hash
+	}
+	if langs := r.URL.Query().Get("languages"); langs != "" {
+		query.Languages = strings.Split(langs, ",")
+	}
+
+	results, err := a.subProvider.Search(r.Context(), query)
+	if err != nil {
+		log.Printf("Subtitle search for %s failed: %v", movieID, err)
+		writeProviderError(w, err)
+		return
+	}
+	if results == nil {
+		results = []subtitles.SearchResult{}
+	}
+	respondJSON(w, results)
+}
+
+// handleSubtitleDownload handles POST /api/movies/{id}/subtitles/download,
+// storing the chosen search result as a managed subtitle
+func (a *API) handleSubtitleDownload(w http.ResponseWriter, r *http.Request, movieID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if a.subProvider == nil {
+		http.Error(w, "No subtitle provider configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req SubtitleDownloadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.FileID == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if _, err := a.library.GetMovie(movieID); err != nil {
+		http.Error(w, "Movie not found", http.StatusNotFound)
+		return
+	}
+
+	download, err := a.subProvider.Download(r.Context(), req.FileID)
+	if err != nil {
+		log.Printf("Subtitle download of %s failed: %v", req.FileID, err)
+		writeProviderError(w, err)
+		return
+	}
+	defer download.Body.Close()
+
+	// Providers serve SubRip unless told otherwise
+	name := download.FileName
+	if !library.IsSubtitleExtension(strings.ToLower(filepath.Ext(name))) {
+		name += ".srt"
+	}
+
+	sub, err := a.library.AddSubtitle(movieID, name, download.Body, req.SubtitleMeta)
+	if err != nil {
+		writeLibraryError(w, err)
+		return
+	}
+	respondJSON(w, sub)
+}
+
+// writeLibraryError maps library errors to 404 or 400
+func writeLibraryError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	if errors.Is(err, library.ErrMovieNotFound) || errors.Is(err, library.ErrSubtitleNotFound) {
+		status = http.StatusNotFound
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// writeProviderError maps subtitle provider errors to 400 or 502, keeping
+// upstream responses out of the reply
+func writeProviderError(w http.ResponseWriter, err error) {
+	if errors.Is(err, subtitles.ErrInvalidFileID) {
+		http.Error(w, "Invalid file ID", http.StatusBadRequest)
+		return
+	}
+	http.Error(w, "Subtitle provider request failed", http.StatusBadGateway)
+}
+
+// formBool parses a checkbox-style form value
+func formBool(v string) bool {
+	switch strings.ToLower(v) {
+	case "1", "true", "on", "yes":
+		return true
+	}
+	return false
+}
@@ -1,9 +1,12 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds application configuration
@@ -15,6 +18,16 @@ type Config struct {
 	// Media library settings
 	MediaPaths      []string
 	MediaExtensions []string
+	ScanWorkers     int // Files probed in parallel during a scan
+
+	// Filesystem watching for incremental library updates
+	WatchMode         string        // "auto", "inotify", "poll" or "off"
+	WatchPollInterval time.Duration // How often polled paths are walked
+	WatchDebounce     time.Duration // Quiet time after an event before a file is checked
+	WatchSettleTime   time.Duration // A file's size must hold this long before it is scanned
+
+	// How long a movie whose file disappeared is kept before it is purged (0 = forever)
+	MissingGracePeriod time.Duration
 
 	// Database
 	DBPath string
@@ -26,6 +39,57 @@ type Config struct {
 	AudioBitrate string
 	Preset       string
 
+	// Hardware acceleration: "auto", "none", "rkmpp", "vaapi", "qsv", "nvenc" or "v4l2m2m"
+	HWAccel     string
+	VAAPIDevice string // DRM render node for VAAPI and QSV
+	V4L2Device  string // Encoder device for V4L2 M2M
+
+	// HDR to SDR conversion: "hable", "mobius", "reinhard", "clip", "linear" or "gamma"
+	ToneMapAlgorithm string
+
+	// Burned-in subtitle style defaults (empty or 0 = keep the file's own style)
+	SubtitleFont    string
+	SubtitleSize    int    // Font size on libass's 288-line canvas
+	SubtitleOutline int    // Outline width
+	SubtitleColor   string // Text colour as #RRGGBB
+	SubtitleMarginV int    // Distance from the bottom edge
+
+	// Subtitles converted to WebVTT, SRT or ASS for serving
+	SubtitleCacheDir      string
+	SubtitleCacheMaxBytes int64 // 0 = unlimited
+
+	// Subtitle files uploaded through the API
+	ManagedSubtitleDir string
+
+	// OpenSubtitles-compatible subtitle search (disabled without an API key)
+	OpenSubtitlesURL    string
+	OpenSubtitlesAPIKey string
+
+	// Transcode scheduling (0 = unlimited)
+	MaxTranscodes         int           // Concurrent software encodes
+	MaxHWTranscodes       int           // Concurrent hardware encodes
+	TranscodeQueueTimeout time.Duration // How long a request waits for a slot (0 = fail immediately)
+	TranscodeSaturation   string        // "queue" or "degrade" when all slots are busy
+	DegradedHeight        int           // Output height for degraded encodes
+
+	// HLS throttling, in 10-second segments (0 = disabled)
+	HLSBufferSegments    int // Pause ffmpeg this far ahead of the viewer
+	HLSRetentionSegments int // Keep this many played segments behind the viewer
+
+	// HLS scratch storage
+	HLSDir             string // Segment directory ("" = RAM disk if available, else HLSDiskDir)
+	HLSDiskDir         string // Disk fallback when the RAM disk is missing or too small
+	HLSMaxBytes        int64  // Budget across all sessions (0 = unlimited)
+	HLSSessionMaxBytes int64  // Budget for one session (0 = unlimited)
+
+	// Completed transcodes kept for repeat viewing
+	TranscodeCacheDir      string
+	TranscodeCacheMaxBytes int64 // 0 = cache disabled
+
+	// Optimized versions written by background jobs
+	OptimizeDir         string // Managed directory for optimized files
+	OptimizeDestination string // "managed" or "alongside" (next to the original)
+
 	// DLNA settings
 	DLNAFriendlyName string
 	DLNAUUID         string
@@ -48,6 +112,14 @@ func DefaultConfig() *Config {
 			".mkv", ".mp4", ".avi", ".mov", ".wmv",
 			".m4v", ".webm", ".ts", ".m2ts",
 		},
+		ScanWorkers: 4,
+
+		WatchMode:         "auto",
+		WatchPollInterval: time.Minute,
+		WatchDebounce:     2 * time.Second,
+		WatchSettleTime:   10 * time.Second,
+
+		MissingGracePeriod: 7 * 24 * time.Hour,
 
 		DBPath: filepath.Join(dataDir, "library.db"),
 
@@ -57,6 +129,36 @@ func DefaultConfig() *Config {
 		AudioBitrate: "192k",
 		Preset:       "fast",
 
+		HWAccel:     "auto",
+		VAAPIDevice: "/dev/dri/renderD128",
+		V4L2Device:  "/dev/video11",
+
+		ToneMapAlgorithm: "hable",
+
+		MaxTranscodes:         2,
+		MaxHWTranscodes:       3,
+		TranscodeQueueTimeout: 30 * time.Second,
+		TranscodeSaturation:   "queue",
+		DegradedHeight:        480,
+
+		HLSBufferSegments:    30,
+		HLSRetentionSegments: 30,
+
+		HLSDiskDir:         filepath.Join(dataDir, "hls"),
+		HLSMaxBytes:        1 << 30,
+		HLSSessionMaxBytes: 512 << 20,
+
+		TranscodeCacheDir:     filepath.Join(dataDir, "cache"),
+		SubtitleCacheDir:      filepath.Join(dataDir, "subtitles"),
+		SubtitleCacheMaxBytes: 64 << 20,
+
+		ManagedSubtitleDir: filepath.Join(dataDir, "managed-subtitles"),
+
+		OpenSubtitlesURL: "https://api.opensubtitles.com/api/v1",
+
+		OptimizeDir:         filepath.Join(dataDir, "optimized"),
+		OptimizeDestination: "managed",
+
 		DLNAFriendlyName: "DLNA Movie Cast",
 		DLNAUUID:         "", // Will be auto-generated if empty
 
@@ -72,6 +174,34 @@ func (c *Config) LoadFromEnv() {
 	if val := os.Getenv("MEDIA_PATHS"); val != "" {
 		c.MediaPaths = filepath.SplitList(val)
 	}
+	if val := os.Getenv("SCAN_WORKERS"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil {
+			c.ScanWorkers = n
+		}
+	}
+	if val := os.Getenv("WATCH_MODE"); val != "" {
+		c.WatchMode = val
+	}
+	if val := os.Getenv("WATCH_POLL_INTERVAL"); val != "" {
+		if d, err := time.ParseDuration(val); err == nil {
+			c.WatchPollInterval = d
+		}
+	}
+	if val := os.Getenv("WATCH_DEBOUNCE"); val != "" {
+		if d, err := time.ParseDuration(val); err == nil {
+			c.WatchDebounce = d
+		}
+	}
+	if val := os.Getenv("WATCH_SETTLE_TIME"); val != "" {
+		if d, err := time.ParseDuration(val); err == nil {
+			c.WatchSettleTime = d
+		}
+	}
+	if val := os.Getenv("MISSING_GRACE_PERIOD"); val != "" {
+		if d, err := time.ParseDuration(val); err == nil {
+			c.MissingGracePeriod = d
+		}
+	}
 	if val := os.Getenv("SERVER_HOST"); val != "" {
 		c.ServerHost = val
 	}
@@ -95,6 +225,119 @@ func (c *Config) LoadFromEnv() {
 	if val := os.Getenv("AUDIO_BITRATE"); val != "" {
 		c.AudioBitrate = val
 	}
+	if val := os.Getenv("HWACCEL"); val != "" {
+		c.HWAccel = val
+	}
+	if val := os.Getenv("VAAPI_DEVICE"); val != "" {
+		c.VAAPIDevice = val
+	}
+	if val := os.Getenv("V4L2_DEVICE"); val != "" {
+		c.V4L2Device = val
+	}
+	if val := os.Getenv("TONEMAP_ALGORITHM"); val != "" {
+		c.ToneMapAlgorithm = val
+	}
+	if val := os.Getenv("SUBTITLE_FONT"); val != "" {
+		c.SubtitleFont = val
+	}
+	if val := os.Getenv("SUBTITLE_SIZE"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil {
+			c.SubtitleSize = n
+		}
+	}
+	if val := os.Getenv("SUBTITLE_OUTLINE"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil {
+			c.SubtitleOutline = n
+		}
+	}
+	if val := os.Getenv("SUBTITLE_COLOR"); val != "" {
+		c.SubtitleColor = val
+	}
+	if val := os.Getenv("SUBTITLE_MARGIN_V"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil {
+			c.SubtitleMarginV = n
+		}
+	}
+	if val := os.Getenv("SUBTITLE_CACHE_DIR"); val != "" {
+		c.SubtitleCacheDir = val
+	}
+	if val := os.Getenv("SUBTITLE_CACHE_MAX_BYTES"); val != "" {
+		if n, err := ParseByteSize(val); err == nil {
+			c.SubtitleCacheMaxBytes = n
+		}
+	}
+	if val := os.Getenv("MANAGED_SUBTITLE_DIR"); val != "" {
+		c.ManagedSubtitleDir = val
+	}
+	if val := os.Getenv("OPENSUBTITLES_URL"); val != "" {
+		c.OpenSubtitlesURL = val
+	}
+	if val := os.Getenv("OPENSUBTITLES_API_KEY"); val != "" {
+		c.OpenSubtitlesAPIKey = val
+	}
+	if val := os.Getenv("MAX_TRANSCODES"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil {
+			c.MaxTranscodes = n
+		}
+	}
+	if val := os.Getenv("MAX_HW_TRANSCODES"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil {
+			c.MaxHWTranscodes = n
+		}
+	}
+	if val := os.Getenv("TRANSCODE_QUEUE_TIMEOUT"); val != "" {
+		if d, err := time.ParseDuration(val); err == nil {
+			c.TranscodeQueueTimeout = d
+		}
+	}
+	if val := os.Getenv("TRANSCODE_SATURATION"); val != "" {
+		c.TranscodeSaturation = val
+	}
+	if val := os.Getenv("DEGRADED_HEIGHT"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil {
+			c.DegradedHeight = n
+		}
+	}
+	if val := os.Getenv("HLS_BUFFER_SEGMENTS"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil {
+			c.HLSBufferSegments = n
+		}
+	}
+	if val := os.Getenv("HLS_RETENTION_SEGMENTS"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil {
+			c.HLSRetentionSegments = n
+		}
+	}
+	if val := os.Getenv("HLS_DIR"); val != "" {
+		c.HLSDir = val
+	}
+	if val := os.Getenv("HLS_DISK_DIR"); val != "" {
+		c.HLSDiskDir = val
+	}
+	if val := os.Getenv("HLS_MAX_BYTES"); val != "" {
+		if n, err := ParseByteSize(val); err == nil {
+			c.HLSMaxBytes = n
+		}
+	}
+	if val := os.Getenv("HLS_SESSION_MAX_BYTES"); val != "" {
+		if n, err := ParseByteSize(val); err == nil {
+			c.HLSSessionMaxBytes = n
+		}
+	}
+	if val := os.Getenv("TRANSCODE_CACHE_DIR"); val != "" {
+		c.TranscodeCacheDir = val
+	}
+	if val := os.Getenv("TRANSCODE_CACHE_MAX_BYTES"); val != "" {
+		if n, err := ParseByteSize(val); err == nil {
+			c.TranscodeCacheMaxBytes = n
+		}
+	}
+	if val := os.Getenv("OPTIMIZE_DIR"); val != "" {
+		c.OptimizeDir = val
+	}
+	if val := os.Getenv("OPTIMIZE_DESTINATION"); val != "" {
+		c.OptimizeDestination = val
+	}
 	if val := os.Getenv("DLNA_FRIENDLY_NAME"); val != "" {
 		c.DLNAFriendlyName = val
 	}
@@ -106,6 +349,104 @@ func (c *Config) LoadFromEnv() {
 	}
 }
 
+// Validate checks the configuration for values the server can't run with
+func (c *Config) Validate() error {
+	if c.ServerPort <= 0 || c.ServerPort > 65535 {
+		return fmt.Errorf("invalid server port: %d", c.ServerPort)
+	}
+	if len(c.MediaPaths) == 0 {
+		return fmt.Errorf("no media paths configured")
+	}
+	if c.ScanWorkers < 1 {
+		return fmt.Errorf("invalid scan worker count: %d", c.ScanWorkers)
+	}
+	switch c.WatchMode {
+	case "auto", "inotify", "poll", "off":
+	default:
+		return fmt.Errorf("unknown watch mode: %q", c.WatchMode)
+	}
+	if c.WatchMode != "off" && c.WatchPollInterval <= 0 {
+		return fmt.Errorf("invalid watch poll interval: %s", c.WatchPollInterval)
+	}
+	switch strings.ToLower(c.HWAccel) {
+	case "", "auto", "none", "software", "rkmpp", "vaapi", "qsv", "nvenc", "v4l2m2m":
+	default:
+		return fmt.Errorf("unknown hardware acceleration backend: %q", c.HWAccel)
+	}
+	switch c.ToneMapAlgorithm {
+	case "hable", "mobius", "reinhard", "clip", "linear", "gamma":
+	default:
+		return fmt.Errorf("unknown tone mapping algorithm: %q", c.ToneMapAlgorithm)
+	}
+	if c.SubtitleColor != "" && !isHexColor(c.SubtitleColor) {
+		return fmt.Errorf("invalid subtitle colour: %q (want #RRGGBB)", c.SubtitleColor)
+	}
+	switch c.TranscodeSaturation {
+	case "queue", "degrade":
+	default:
+		return fmt.Errorf("unknown transcode saturation policy: %q", c.TranscodeSaturation)
+	}
+	switch c.OptimizeDestination {
+	case "managed", "alongside":
+	default:
+		return fmt.Errorf("unknown optimize destination: %q", c.OptimizeDestination)
+	}
+	return nil
+}
+
+// ParseByteSize parses sizes like "512M", "2G" or "1048576" into bytes
+func ParseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		multiplier = 1 << 30
+	case strings.HasSuffix(s, "T"):
+		multiplier = 1 << 40
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// ParseBitrate parses bitrates like "4M", "192k" or "800000" into bits per
+// second. Like ffmpeg's -b:v, the multipliers are decimal: "4M" is
+// 4,000,000.
+func ParseBitrate(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1000
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1000 * 1000
+	case strings.HasSuffix(s, "G"):
+		multiplier = 1000 * 1000 * 1000
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid bitrate: %q", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
 // EnsureDirectories creates necessary data directories
 func (c *Config) EnsureDirectories() error {
 	dirs := []string{
@@ -119,3 +460,13 @@ func (c *Config) EnsureDirectories() error {
 	}
 	return nil
 }
+
+// isHexColor reports whether s is an RGB colour in #RRGGBB form
+func isHexColor(s string) bool {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 {
+		return false
+	}
+	_, err := strconv.ParseUint(s, 16, 32)
+	return err == nil
+}
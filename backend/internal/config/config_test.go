@@ -0,0 +1,45 @@
+package config
+
+import "testing"
+
+func TestParseBitrate(t *testing.T) {
+	tests := map[string]int64{
+		"4M":      4000000,
+		"4m":      4000000,
+		"192k":    192000,
+		"1.5M":    1500000,
+		"800000":  800000,
+		" 2G ":    2000000000,
+		"0":       0,
+		"12.5k":   12500,
+		"1000000": 1000000,
+	}
+	for in, want := range tests {
+		got, err := ParseBitrate(in)
+		if err != nil || got != want {
+			t.Errorf("ParseBitrate(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+
+	for _, in := range []string{"", "fast", "-1M", "4MB"} {
+		if _, err := ParseBitrate(in); err == nil {
+			t.Errorf("ParseBitrate(%q) succeeded", in)
+		}
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := map[string]int64{
+		"512M":    512 << 20,
+		"1G":      1 << 30,
+		"2GiB":    2 << 30,
+		"64k":     64 << 10,
+		"1048576": 1048576,
+	}
+	for in, want := range tests {
+		got, err := ParseByteSize(in)
+		if err != nil || got != want {
+			t.Errorf("ParseByteSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+}
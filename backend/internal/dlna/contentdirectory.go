@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/wysentanu/dlna-movie-cast/internal/library"
 )
@@ -16,7 +17,7 @@ import (
 type ContentDirectoryService struct {
 	library    *library.Library
 	serverAddr string
-	updateID   uint32
+	updateID   uint32 // Accessed atomically; bumped by scans and the watcher
 }
 
 // NewContentDirectoryService creates a new ContentDirectory service
@@ -261,7 +262,7 @@ func (s *ContentDirectoryService) wrapBrowseResponse(didl string, numberReturned
 <UpdateID>%d</UpdateID>
 </u:BrowseResponse>
 </s:Body>
-</s:Envelope>`, escapedDIDL, numberReturned, totalMatches, s.updateID)
+</s:Envelope>`, escapedDIDL, numberReturned, totalMatches, atomic.LoadUint32(&s.updateID))
 }
 
 // handleGetSystemUpdateID handles the GetSystemUpdateID action
@@ -273,7 +274,7 @@ func (s *ContentDirectoryService) handleGetSystemUpdateID() string {
 <Id>%d</Id>
 </u:GetSystemUpdateIDResponse>
 </s:Body>
-</s:Envelope>`, s.updateID)
+</s:Envelope>`, atomic.LoadUint32(&s.updateID))
 }
 
 // handleGetSearchCapabilities handles the GetSearchCapabilities action
@@ -302,7 +303,7 @@ func (s *ContentDirectoryService) handleGetSortCapabilities() string {
 
 // IncrementUpdateID increments the system update ID (call after library changes)
 func (s *ContentDirectoryService) IncrementUpdateID() {
-	s.updateID++
+	atomic.AddUint32(&s.updateID, 1)
 }
 
 // Helper function to extract XML values
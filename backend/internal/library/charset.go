@@ -0,0 +1,252 @@
+package library
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// charsetSampleBytes is how much of a subtitle file detection looks at
+const charsetSampleBytes = 64 << 10
+
+// languageCharsets is the legacy Windows code page each language was
+// usually written in, used as a hint when the bytes alone are ambiguous
+var languageCharsets = map[string]string{
+	"ru": "CP1251", "rus": "CP1251", "uk": "CP1251", "ukr": "CP1251",
+	"bg": "CP1251", "bul": "CP1251", "be": "CP1251", "bel": "CP1251",
+	"mk": "CP1251", "mac": "CP1251", "sr": "CP1251", "srp": "CP1251",
+	"ar": "CP1256", "ara": "CP1256", "fa": "CP1256", "per": "CP1256",
+	"ur": "CP1256", "urd": "CP1256",
+	"zh": "GBK", "chi": "GBK", "zho": "GBK", "chs": "GBK",
+	"cs": "CP1250", "cze": "CP1250", "ces": "CP1250", "pl": "CP1250",
+	"pol": "CP1250", "hu": "CP1250", "hun": "CP1250", "sk": "CP1250",
+	"slo": "CP1250", "slk": "CP1250", "sl": "CP1250", "slv": "CP1250",
+	"hr": "CP1250", "hrv": "CP1250", "bs": "CP1250", "bos": "CP1250",
+	"ro": "CP1250", "rum": "CP1250", "ron": "CP1250",
+}
+
+// cp1250Markers are CP1250 letters (ł ą ľ ť ź ě ř ő ű ć đ ș ţ...) whose
+// CP1252 readings are symbols or rare letters
+var cp1250Markers = []byte{
+	0x8D, 0x8F, 0x9D, 0x9F, 0xA5, 0xAA, 0xB3, 0xB9, 0xBA, 0xBC, 0xBE,
+	0xE6, 0xEC, 0xF0, 0xF5, 0xF8, 0xFB, 0xFE,
+}
+
+// cp1252Markers are common Western letters (à ã è ñ ù) that are rare
+// letters in CP1250
+var cp1252Markers = []byte{0xE0, 0xE3, 0xE8, 0xF1, 0xF9}
+
+// charsetPattern limits charset names to what iconv accepts, since they end
+// up inside ffmpeg filter arguments
+var charsetPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:-]{0,31}$`)
+
+// ValidCharset reports whether name looks like an iconv charset name
+func ValidCharset(name string) bool {
+	return charsetPattern.MatchString(name)
+}
+
+// IsUTF8Charset reports whether a charset needs no conversion for ffmpeg,
+// which reads UTF-8 and byte-order-marked UTF-16 natively
+func IsUTF8Charset(name string) bool {
+	switch strings.ToUpper(name) {
+	case "", "UTF-8", "UTF8", "ASCII", "US-ASCII", "UTF-16LE", "UTF-16BE":
+		return true
+	}
+	return false
+}
+
+// detectFileCharset detects the charset of a subtitle file
+func detectFileCharset(path, language string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	buf := make([]byte, charsetSampleBytes)
+	n, err := f.Read(buf)
+	if err != nil && n == 0 {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return DetectCharset(buf[:n], language), nil
+}
+
+// DetectCharset guesses the charset of subtitle text from its byte order
+// mark, UTF-8 validity and byte statistics, with the subtitle language as a
+// hint for the legacy code pages
+func DetectCharset(data []byte, language string) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return "UTF-8"
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return "UTF-16LE"
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return "UTF-16BE"
+	}
+
+	// A sample cut mid-character is still UTF-8
+	if trimmed := trimPartialRune(data); utf8.Valid(trimmed) {
+		return "UTF-8"
+	}
+
+	hint := languageCharsets[strings.ToLower(language)]
+
+	var high, letters, upper, lower, marks1250, marks1252 int
+	for _, b := range data {
+		switch {
+		case b >= 0x80:
+			high++
+			switch {
+			case b >= 0xC0 && b <= 0xDF:
+				upper++
+			case b >= 0xE0:
+				lower++
+			}
+			if bytes.IndexByte(cp1250Markers, b) >= 0 {
+				marks1250++
+			}
+			if bytes.IndexByte(cp1252Markers, b) >= 0 {
+				marks1252++
+			}
+		case b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z':
+			letters++
+		}
+	}
+
+	// Mostly Latin text with a few accented letters
+	if high < letters {
+		if hint == "CP1250" || marks1250 > marks1252 {
+			return "CP1250"
+		}
+		return "CP1252"
+	}
+
+	// Chinese: every high byte is part of a valid double-byte sequence
+	if validGBK(data) && (hint == "GBK" || hint == "") {
+		return "GBK"
+	}
+
+	if hint == "CP1251" || hint == "CP1256" {
+		return hint
+	}
+
+	// Cyrillic CP1251 puts capitals at 0xC0-0xDF, so lowercase dominates.
+	// Arabic CP1256 has no case and spreads its letters over both halves.
+	if lower > upper*3 {
+		return "CP1251"
+	}
+	return "CP1256"
+}
+
+// validGBK reports whether the high bytes in data all form GBK pairs
+func validGBK(data []byte) bool {
+	pairs := 0
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+		if b < 0x80 {
+			continue
+		}
+		if b == 0x80 || b == 0xFF {
+			return false
+		}
+		if i+1 >= len(data) {
+			break // Sample may end mid-pair
+		}
+		trail := data[i+1]
+		if trail < 0x40 || trail == 0x7F || trail == 0xFF {
+			return false
+		}
+		pairs++
+		i++
+	}
+	return pairs > 0
+}
+
+// trimPartialRune drops an incomplete UTF-8 sequence at the end of data
+func trimPartialRune(data []byte) []byte {
+	for i := 1; i <= 3 && i <= len(data); i++ {
+		if utf8.RuneStart(data[len(data)-i]) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				return data[:len(data)-i]
+			}
+			break
+		}
+	}
+	return data
+}
+
+// keepManualCharsets copies hand-set charsets from the previous scan of a
+// movie onto the subtitles found now
+func keepManualCharsets(movie, previous *Movie) {
+	for i := range movie.Subtitles {
+		sub := &movie.Subtitles[i]
+		if !sub.IsExternal {
+			continue
+		}
+		if old, ok := previous.ExternalSubtitle(sub.FilePath); ok && old.CharsetManual {
+			sub.Charset = old.Charset
+			sub.CharsetManual = true
+		}
+	}
+}
+
+// SetSubtitleCharset overrides the charset of an external subtitle file. An
+// empty charset reverts to the detected one.
+func (l *Library) SetSubtitleCharset(movieID, subtitleID, charset string) (*Subtitle, error) {
+	if charset != "" && !ValidCharset(charset) {
+		return nil, fmt.Errorf("invalid charset: %q", charset)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	movie, ok := l.lookup(movieID)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrMovieNotFound, movieID)
+	}
+	movieID = movie.ID
+
+	// Movies are shared with readers, so swap in an updated copy
+	updated := *movie
+	updated.Subtitles = append([]Subtitle(nil), movie.Subtitles...)
+
+	var sub *Subtitle
+	for i := range updated.Subtitles {
+		if updated.Subtitles[i].IsExternal && updated.Subtitles[i].ID == subtitleID {
+			sub = &updated.Subtitles[i]
+			break
+		}
+	}
+	if sub == nil {
+		return nil, fmt.Errorf("%w: %s", ErrSubtitleNotFound, subtitleID)
+	}
+
+	if charset == "" {
+		detected, err := detectFileCharset(sub.FilePath, sub.Language)
+		if err != nil {
+			return nil, err
+		}
+		sub.Charset = detected
+		sub.CharsetManual = false
+	} else {
+		sub.Charset = strings.ToUpper(charset)
+		sub.CharsetManual = true
+	}
+
+	if sub.Managed {
+		_, err := l.db.Exec(`UPDATE movie_subtitles SET charset = ?, charset_manual = ? WHERE id = ?`,
+			sub.Charset, sub.CharsetManual, sub.ID)
+		if err != nil {
+			return nil, err
+		}
+	} else if err := l.saveMovie(&updated); err != nil {
+		return nil, err
+	}
+	l.movies[movieID] = &updated
+
+	result := *sub
+	return &result, nil
+}
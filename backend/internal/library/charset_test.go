@@ -0,0 +1,61 @@
+package library
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectCharset(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		language string
+		want     string
+	}{
+		{"UTF-8 BOM", "\xEF\xBB\xBF1\n00:00:01,000 --> 00:00:02,000\nHello\n", "", "UTF-8"},
+		{"UTF-16LE BOM", "\xFF\xFE1\x00\n\x00", "", "UTF-16LE"},
+		{"UTF-16BE BOM", "\xFE\xFF\x001\x00\n", "", "UTF-16BE"},
+		{"ASCII", "1\n00:00:01,000 --> 00:00:02,000\nHello\n", "", "UTF-8"},
+		{"UTF-8 Cyrillic", "Привет, как дела?", "ru", "UTF-8"},
+		{"UTF-8 cut mid-rune", "Привет"[:len("Привет")-1], "", "UTF-8"},
+		// Привет, как дела?
+		{"CP1251", "\xCF\xF0\xE8\xE2\xE5\xF2, \xEA\xE0\xEA \xE4\xE5\xEB\xE0?", "", "CP1251"},
+		{"CP1251 with hint", "\xCF\xF0\xE8\xE2\xE5\xF2, \xEA\xE0\xEA \xE4\xE5\xEB\xE0?", "ru", "CP1251"},
+		// Dzień dobry, proszę usiąść przy stole.
+		{"CP1250", "Dzie\xF1 dobry, prosz\xEA usi\xB9\x9C\xE6 przy stole.", "", "CP1250"},
+		{"CP1250 with hint", "Dzie\xF1 dobry, prosz\xEA usi\xB9\x9C\xE6 przy stole.", "pl", "CP1250"},
+		// Café à la crème
+		{"CP1252", "Caf\xE9 \xE0 la cr\xE8me", "", "CP1252"},
+		// 你好世界
+		{"GBK", "\xC4\xE3\xBA\xC3\xCA\xC0\xBD\xE7", "", "GBK"},
+		{"GBK with hint", "\xC4\xE3\xBA\xC3\xCA\xC0\xBD\xE7", "zh", "GBK"},
+	}
+	for _, tt := range tests {
+		if got := DetectCharset([]byte(tt.data), tt.language); got != tt.want {
+			t.Errorf("%s: DetectCharset = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestVobSubIsNotTakenAsText(t *testing.T) {
+	lib, media := newTestLibrary(t)
+	video := filepath.Join(media, "movie.mkv")
+	files := map[string]string{
+		"movie.mkv":    "video",
+		"movie.en.sub": "{1}{50}MicroDVD text",
+		"movie.fr.sub": "\x00\x00\x01\xBA\x44", // VobSub without its index
+		"movie.de.sub": "\x00\x00\x01\xBA\x44",
+		"movie.de.idx": "# VobSub index file, v7",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(media, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	subs := lib.findExternalSubtitles(video)
+	if len(subs) != 1 || subs[0].Language != "en" {
+		t.Errorf("found %+v, want only the MicroDVD subtitle", subs)
+	}
+}
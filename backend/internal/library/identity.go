@@ -0,0 +1,261 @@
+package library
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+)
+
+// schemaVersion is stored in PRAGMA user_version. Version 1 derives movie
+// IDs from content fingerprints instead of file paths. Version 2 marks the
+// movies still on path IDs, which migrate once a scan has read their files.
+const schemaVersion = 2
+
+// pathID is the ID older databases derived from a movie's file path
+func pathID(path string) string {
+	hash := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(hash[:8])
+}
+
+// fingerprintID derives a movie ID from a content fingerprint, salted with
+// extra strings when the plain ID is taken
+func fingerprintID(fingerprint string, salt ...string) string {
+	h := sha256.New()
+	h.Write([]byte(fingerprint))
+	for _, s := range salt {
+		h.Write([]byte{0})
+		h.Write([]byte(s))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// claimMovieID picks the ID for a file and reserves it until
+// releaseMovieID, so scan workers saving copies of the same content at once
+// don't take the same ID or the same moved movie. A movie keeps its ID when
+// its file changes in place or is moved, so bookmarks and TV listings keep
+// working. A new path may be a movie whose file was moved or renamed.
+func (l *Library) claimMovieID(existing *Movie, fingerprint, path string) (string, *Movie) {
+	l.idMu.Lock()
+	defer l.idMu.Unlock()
+
+	var id string
+	var moved *Movie
+	if existing != nil {
+		id = existing.ID
+	} else if moved = l.findMovedMovie(fingerprint); moved != nil {
+		id = moved.ID
+	} else {
+		id = l.newMovieID(fingerprint, path)
+	}
+	l.claimed[id] = true
+	return id, moved
+}
+
+// releaseMovieID ends the reservation made by claimMovieID
+func (l *Library) releaseMovieID(id string) {
+	l.idMu.Lock()
+	defer l.idMu.Unlock()
+
+	delete(l.claimed, id)
+}
+
+// newMovieID picks the ID for a file not yet in the library. Copies of the
+// same content at several paths share a fingerprint, so a taken ID is
+// salted with the path. Callers hold l.idMu.
+func (l *Library) newMovieID(fingerprint, path string) string {
+	id := fingerprintID(fingerprint)
+	if _, err := l.getMovieFromDB(id); err == nil || l.claimed[id] {
+		id = fingerprintID(fingerprint, path)
+	}
+	return id
+}
+
+// lookup finds an online movie by its ID, or by the path-based ID it had
+// in an older database; callers hold l.mu
+func (l *Library) lookup(id string) (*Movie, bool) {
+	if movie, ok := l.movies[id]; ok {
+		return movie, true
+	}
+	if current, ok := l.aliases[id]; ok {
+		movie, ok := l.movies[current]
+		return movie, ok
+	}
+	return nil, false
+}
+
+// loadAliases reads the map from old movie IDs to current ones
+func (l *Library) loadAliases() (map[string]string, error) {
+	rows, err := l.db.Query(`SELECT alias, movie_id FROM movie_aliases`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	aliases := make(map[string]string)
+	for rows.Next() {
+		var alias, movieID string
+		if err := rows.Scan(&alias, &movieID); err != nil {
+			continue
+		}
+		aliases[alias] = movieID
+	}
+	return aliases, rows.Err()
+}
+
+// initAliasesDB creates the table of IDs movies had in older databases and
+// the table of movies still waiting to leave their path-based ID
+func (l *Library) initAliasesDB() error {
+	_, err := l.db.Exec(`
+	CREATE TABLE IF NOT EXISTS movie_aliases (
+		alias TEXT PRIMARY KEY,
+		movie_id TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_movie_aliases_movie ON movie_aliases(movie_id);
+	CREATE TABLE IF NOT EXISTS legacy_movie_ids (
+		movie_id TEXT PRIMARY KEY
+	);
+	`)
+	return err
+}
+
+// migrate upgrades the database to schemaVersion, then re-keys the marked
+// movies whose fingerprint is already known
+func (l *Library) migrate() error {
+	var version int
+	if err := l.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+
+	if version < 2 {
+		if err := l.markLegacyIDs(); err != nil {
+			return fmt.Errorf("failed to mark path-based movie IDs: %w", err)
+		}
+	}
+	if err := l.migrateLegacyIDs(); err != nil {
+		return fmt.Errorf("failed to migrate movie IDs: %w", err)
+	}
+	return nil
+}
+
+// markLegacyIDs marks the movies that still have the path-based IDs of an
+// older database, including those a version 1 migration couldn't read.
+// No files are read here; the next scan fingerprints them.
+func (l *Library) markLegacyIDs() error {
+	movies, err := l.queryMovies(``)
+	if err != nil {
+		return err
+	}
+
+	tx, err := l.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, movie := range movies {
+		if movie.ID != pathID(movie.FilePath) {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO legacy_movie_ids (movie_id) VALUES (?)`, movie.ID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, schemaVersion)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrateLegacyIDs re-keys marked movies whose fingerprint is known to
+// fingerprint IDs. The old IDs are kept as aliases so bookmarks, cast URLs
+// and the TV's cached listing still resolve. Movies not fingerprinted yet,
+// e.g. because their drive was unplugged, stay marked for the next scan.
+func (l *Library) migrateLegacyIDs() error {
+	l.idMu.Lock()
+	defer l.idMu.Unlock()
+
+	type legacyMovie struct {
+		id, path, fingerprint string
+	}
+	rows, err := l.db.Query(`
+		SELECT m.id, m.file_path, COALESCE(m.fingerprint, '')
+		FROM legacy_movie_ids g JOIN movies m ON m.id = g.movie_id`)
+	if err != nil {
+		return err
+	}
+	var pending []legacyMovie
+	for rows.Next() {
+		var m legacyMovie
+		if err := rows.Scan(&m.id, &m.path, &m.fingerprint); err != nil {
+			continue
+		}
+		pending = append(pending, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := l.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Marks left by purged movies
+	if _, err := tx.Exec(`DELETE FROM legacy_movie_ids WHERE movie_id NOT IN (SELECT id FROM movies)`); err != nil {
+		return err
+	}
+
+	migrated := 0
+	for _, m := range pending {
+		if m.fingerprint == "" {
+			continue
+		}
+		id := fingerprintID(m.fingerprint)
+		var taken int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM movies WHERE id = ?`, id).Scan(&taken); err != nil {
+			return err
+		}
+		if taken > 0 || l.claimed[id] {
+			id = fingerprintID(m.fingerprint, m.path)
+		}
+		if err := rekeyMovie(tx, m.id, id, m.fingerprint); err != nil {
+			return err
+		}
+		migrated++
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if migrated > 0 {
+		log.Printf("Library: migrated %d of %d movies to content-based IDs", migrated, len(pending))
+	}
+	return nil
+}
+
+// rekeyMovie gives a movie a new ID, records the old one as an alias and
+// clears its legacy mark
+func rekeyMovie(tx *sql.Tx, oldID, newID, fingerprint string) error {
+	if _, err := tx.Exec(`UPDATE movies SET id = ?, fingerprint = ? WHERE id = ?`, newID, fingerprint, oldID); err != nil {
+		return err
+	}
+	for _, query := range []string{
+		`UPDATE movie_versions SET movie_id = ? WHERE movie_id = ?`,
+		`UPDATE movie_subtitles SET movie_id = ? WHERE movie_id = ?`,
+		`UPDATE movie_aliases SET movie_id = ? WHERE movie_id = ?`,
+	} {
+		if _, err := tx.Exec(query, newID, oldID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM legacy_movie_ids WHERE movie_id = ?`, oldID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`INSERT OR REPLACE INTO movie_aliases (alias, movie_id) VALUES (?, ?)`, oldID, newID)
+	return err
+}
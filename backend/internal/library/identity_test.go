@@ -0,0 +1,132 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// saveLegacyMovie stores a movie the way databases before fingerprint IDs
+// did: keyed by its path, without a fingerprint
+func saveLegacyMovie(t *testing.T, lib *Library, path string) string {
+	t.Helper()
+	movie := &Movie{
+		ID:              pathID(path),
+		Title:           filepath.Base(path),
+		FilePath:        path,
+		Status:          StatusOnline,
+		MetadataVersion: metadataVersion,
+	}
+	if info, err := os.Stat(path); err == nil {
+		movie.ModifiedAt = info.ModTime()
+	}
+	if err := lib.saveMovie(movie); err != nil {
+		t.Fatal(err)
+	}
+	return movie.ID
+}
+
+// legacyCount returns how many movies are still marked for migration
+func legacyCount(t *testing.T, lib *Library) int {
+	t.Helper()
+	var n int
+	if err := lib.db.QueryRow(`SELECT COUNT(*) FROM legacy_movie_ids`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestMigrationWaitsForScanAndKeepsAliases(t *testing.T) {
+	lib, media := newTestLibrary(t)
+	present := filepath.Join(media, "present.mkv")
+	if err := os.WriteFile(present, []byte("present content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	absent := filepath.Join(media, "absent.mkv") // On a drive that is unplugged
+
+	presentID := saveLegacyMovie(t, lib, present)
+	absentID := saveLegacyMovie(t, lib, absent)
+	if _, err := lib.db.Exec(`PRAGMA user_version = 0`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Startup only marks the movies; no file is read
+	if err := lib.migrate(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if n := legacyCount(t, lib); n != 2 {
+		t.Fatalf("%d movies marked after startup, want 2", n)
+	}
+	if _, err := lib.getMovieFromDB(presentID); err != nil {
+		t.Fatalf("movie re-keyed before a scan read it: %v", err)
+	}
+
+	if err := lib.Scan(context.Background(), nil); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	info, _ := os.Stat(present)
+	fingerprint, err := fingerprintFile(present, info.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	movie, err := lib.GetMovie(presentID)
+	if err != nil {
+		t.Fatalf("old ID no longer resolves: %v", err)
+	}
+	if movie.ID != fingerprintID(fingerprint) {
+		t.Errorf("migrated ID = %s, want %s", movie.ID, fingerprintID(fingerprint))
+	}
+	if n := legacyCount(t, lib); n != 1 {
+		t.Errorf("%d movies marked after the scan, want only the unreadable one", n)
+	}
+	if _, err := lib.getMovieFromDB(absentID); err != nil {
+		t.Errorf("unreadable movie lost its ID: %v", err)
+	}
+
+	// The drive comes back
+	if err := os.WriteFile(absent, []byte("absent content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := lib.Scan(context.Background(), nil); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	movie, err = lib.GetMovie(absentID)
+	if err != nil {
+		t.Fatalf("old ID of the returned movie doesn't resolve: %v", err)
+	}
+	if movie.ID == absentID || movie.FilePath != absent {
+		t.Errorf("returned movie = %s at %s, want a fingerprint ID", movie.ID, movie.FilePath)
+	}
+	if n := legacyCount(t, lib); n != 0 {
+		t.Errorf("%d movies still marked, want none", n)
+	}
+}
+
+func TestLookupResolvesAliases(t *testing.T) {
+	lib, _ := newTestLibrary(t)
+	lib.movies["new"] = &Movie{ID: "new"}
+	lib.aliases["old"] = "new"
+	lib.aliases["gone"] = "purged"
+
+	if movie, ok := lib.lookup("old"); !ok || movie.ID != "new" {
+		t.Errorf("lookup(old) = %v, %v, want the aliased movie", movie, ok)
+	}
+	if _, ok := lib.lookup("gone"); ok {
+		t.Error("lookup followed an alias to a movie that no longer exists")
+	}
+	if _, ok := lib.lookup("unknown"); ok {
+		t.Error("lookup found an unknown ID")
+	}
+}
+
+func TestUnknownIDsWrapNotFound(t *testing.T) {
+	lib, _ := newTestLibrary(t)
+	if _, err := lib.GetMovie("unknown"); !errors.Is(err, ErrMovieNotFound) {
+		t.Errorf("GetMovie(unknown) = %v, want ErrMovieNotFound", err)
+	}
+	if err := lib.RemoveSubtitle("unknown", "sub"); !errors.Is(err, ErrMovieNotFound) {
+		t.Errorf("RemoveSubtitle(unknown) = %v, want ErrMovieNotFound", err)
+	}
+}
@@ -0,0 +1,190 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// IsMediaFile reports whether a path has one of the configured video
+// extensions and isn't an optimized version of another movie
+func (l *Library) IsMediaFile(path string) bool {
+	return l.isVideoExtension(strings.ToLower(filepath.Ext(path))) && !isOptimizedFile(path)
+}
+
+// ScanFile adds or refreshes a single video file without rescanning the
+// library. It reports whether the library changed.
+func (l *Library) ScanFile(ctx context.Context, path string) (bool, error) {
+	l.scanMu.Lock()
+	defer l.scanMu.Unlock()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if info.IsDir() || !l.IsMediaFile(path) {
+		return false, nil
+	}
+
+	saved, err := l.processVideoFile(ctx, path, info)
+	if err != nil {
+		return false, err
+	}
+
+	movie, err := l.getMovieByPath(path)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil // Unreadable files are skipped and have no row
+		}
+		return false, err
+	}
+
+	l.mu.RLock()
+	_, known := l.movies[movie.ID]
+	l.mu.RUnlock()
+	if !saved && known {
+		return false, nil
+	}
+
+	return true, l.reloadMovie(movie.ID)
+}
+
+// reloadMovie reads one movie with its versions and managed subtitles from
+// the database and swaps it into memory
+func (l *Library) reloadMovie(id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	movie, err := l.getMovieFromDB(id)
+	if err != nil {
+		return err
+	}
+
+	if !movie.IsOnline() {
+		delete(l.movies, id)
+		l.offline[id] = movie
+		return nil
+	}
+
+	movies := map[string]*Movie{id: movie}
+	if err := l.loadVersions(movies); err != nil {
+		return err
+	}
+	if err := l.loadManagedSubtitles(movies); err != nil {
+		return err
+	}
+
+	delete(l.offline, id)
+	l.movies[id] = movie
+	return nil
+}
+
+// RemovePath takes the movies stored at path, or anywhere below it when
+// path was a directory, offline. They come back if the files reappear and
+// are purged once missing past the grace period. It reports whether the
+// library changed.
+func (l *Library) RemovePath(path string) (bool, error) {
+	l.scanMu.Lock()
+	defer l.scanMu.Unlock()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	prefix := strings.TrimSuffix(path, string(filepath.Separator)) + string(filepath.Separator)
+	roots := make(map[string]bool)
+	now := time.Now()
+	removed := false
+	for id, movie := range l.movies {
+		if movie.FilePath != path && !strings.HasPrefix(movie.FilePath, prefix) {
+			continue
+		}
+		status := l.fileStatus(movie.FilePath, roots)
+		if status == StatusOnline {
+			continue
+		}
+		if err := l.setStatus(id, status, &now); err != nil {
+			return removed, err
+		}
+		l.takeOffline(movie, status, now)
+		removed = true
+	}
+
+	return removed, nil
+}
+
+// RefreshSubtitles re-reads the external subtitles of the movies a subtitle
+// file at path can belong to: videos in the same folder, or in the parent
+// folders when path is inside Subs/ or Subs/<video name>/. It reports
+// whether the library changed.
+func (l *Library) RefreshSubtitles(path string) (bool, error) {
+	l.scanMu.Lock()
+	defer l.scanMu.Unlock()
+
+	dir := filepath.Dir(path)
+	dirs := map[string]bool{dir: true}
+	if isSubtitleDirName(filepath.Base(dir)) {
+		dirs[filepath.Dir(dir)] = true
+	} else if parent := filepath.Dir(dir); isSubtitleDirName(filepath.Base(parent)) {
+		dirs[filepath.Dir(parent)] = true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	changed := false
+	for id, movie := range l.movies {
+		if !dirs[filepath.Dir(movie.FilePath)] {
+			continue
+		}
+
+		// Embedded tracks first, then the files found now, as in a scan
+		updated := *movie
+		updated.Subtitles = nil
+		for _, sub := range movie.Subtitles {
+			if !sub.IsExternal {
+				updated.Subtitles = append(updated.Subtitles, sub)
+			}
+		}
+		updated.Subtitles = append(updated.Subtitles, l.findExternalSubtitles(movie.FilePath)...)
+		for i := range updated.Subtitles {
+			updated.Subtitles[i].ID = subtitleID(updated.Subtitles[i])
+		}
+		keepManualCharsets(&updated, movie)
+
+		if reflect.DeepEqual(scannedSubtitles(updated.Subtitles), scannedSubtitles(movie.Subtitles)) {
+			continue
+		}
+		if err := l.saveMovie(&updated); err != nil {
+			return changed, err
+		}
+
+		// Managed subtitles are kept after the scanned ones
+		for _, sub := range movie.Subtitles {
+			if sub.Managed {
+				sub.Index = len(updated.Subtitles)
+				updated.Subtitles = append(updated.Subtitles, sub)
+			}
+		}
+		l.movies[id] = &updated
+		changed = true
+	}
+
+	return changed, nil
+}
+
+// scannedSubtitles returns the subtitles found by scanning, leaving out
+// managed ones
+func scannedSubtitles(subs []Subtitle) []Subtitle {
+	var scanned []Subtitle
+	for _, sub := range subs {
+		if !sub.Managed {
+			scanned = append(scanned, sub)
+		}
+	}
+	return scanned
+}
@@ -0,0 +1,168 @@
+package library
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScanFileAddsAndRefreshesOneMovie(t *testing.T) {
+	lib, media := newTestLibrary(t)
+	path := filepath.Join(media, "Movie (2020).mkv")
+	if err := os.WriteFile(path, []byte("first cut"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if changed, err := lib.ScanFile(ctx, path); err != nil || !changed {
+		t.Fatalf("ScanFile of a new file = %v, %v, want a change", changed, err)
+	}
+	movies := lib.GetAllMovies()
+	if len(movies) != 1 || movies[0].FilePath != path || movies[0].Year != 2020 {
+		t.Fatalf("library after ScanFile = %+v, want the new movie", movies)
+	}
+	id := movies[0].ID
+
+	if changed, err := lib.ScanFile(ctx, path); err != nil || changed {
+		t.Errorf("ScanFile of an unchanged file = %v, %v, want no change", changed, err)
+	}
+
+	// A replaced file is probed again under the same ID
+	if err := os.WriteFile(path, []byte("director's cut, longer"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := lib.ScanFile(ctx, path); err != nil || !changed {
+		t.Errorf("ScanFile of a replaced file = %v, %v, want a change", changed, err)
+	}
+	if movie, err := lib.GetMovie(id); err != nil || !movie.ModifiedAt.Equal(later) {
+		t.Errorf("replaced movie = %+v, %v, want modified at %v", movie, err, later)
+	}
+
+	other := filepath.Join(media, "notes.txt")
+	if err := os.WriteFile(other, []byte("not a video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := lib.ScanFile(ctx, other); err != nil || changed {
+		t.Errorf("ScanFile of a text file = %v, %v, want it ignored", changed, err)
+	}
+	if _, err := lib.ScanFile(ctx, filepath.Join(media, "gone.mkv")); err == nil {
+		t.Error("ScanFile of a missing file succeeded")
+	}
+}
+
+func TestRemovePathTakesMoviesBelowItOffline(t *testing.T) {
+	lib, media := newTestLibrary(t)
+	season := filepath.Join(media, "Collection")
+	if err := os.MkdirAll(season, 0755); err != nil {
+		t.Fatal(err)
+	}
+	kept := filepath.Join(media, "kept.mkv")
+	for path, content := range map[string]string{
+		kept:                             "kept",
+		filepath.Join(season, "one.mkv"): "one",
+		filepath.Join(season, "two.mkv"): "two",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := lib.Scan(context.Background(), nil); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+
+	// A path that still exists changes nothing
+	if changed, err := lib.RemovePath(kept); err != nil || changed {
+		t.Errorf("RemovePath of a present file = %v, %v, want no change", changed, err)
+	}
+
+	if err := os.RemoveAll(season); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := lib.RemovePath(season); err != nil || !changed {
+		t.Fatalf("RemovePath of a deleted folder = %v, %v, want a change", changed, err)
+	}
+
+	movies := lib.GetAllMovies()
+	if len(movies) != 1 || movies[0].FilePath != kept {
+		t.Errorf("online movies = %d, want only %s", len(movies), kept)
+	}
+	offline := lib.GetOfflineMovies()
+	if len(offline) != 2 {
+		t.Fatalf("offline movies = %d, want 2", len(offline))
+	}
+	for _, movie := range offline {
+		if movie.Status != StatusMissing || movie.MissingSince == nil {
+			t.Errorf("%s is %s since %v, want missing with a time", movie.FilePath, movie.Status, movie.MissingSince)
+		}
+	}
+}
+
+func TestRefreshSubtitlesFollowsSubtitleFiles(t *testing.T) {
+	lib, media := newTestLibrary(t)
+	video := filepath.Join(media, "Movie.mkv")
+	if err := os.WriteFile(video, []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := lib.Scan(context.Background(), nil); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	id := lib.GetAllMovies()[0].ID
+
+	languages := func() []string {
+		movie, err := lib.GetMovie(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var langs []string
+		for _, sub := range movie.Subtitles {
+			langs = append(langs, sub.Language)
+		}
+		return langs
+	}
+
+	english := filepath.Join(media, "Movie.en.srt")
+	if err := os.WriteFile(english, []byte("1\n00:00:01,000 --> 00:00:02,000\nHello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := lib.RefreshSubtitles(english); err != nil || !changed {
+		t.Fatalf("RefreshSubtitles after adding a file = %v, %v, want a change", changed, err)
+	}
+	if langs := languages(); len(langs) != 1 || langs[0] != "en" {
+		t.Errorf("subtitles = %v, want [en]", langs)
+	}
+	if changed, err := lib.RefreshSubtitles(english); err != nil || changed {
+		t.Errorf("RefreshSubtitles with nothing new = %v, %v, want no change", changed, err)
+	}
+
+	// A Subs/ folder next to a lone video belongs to it
+	subs := filepath.Join(media, "Subs")
+	if err := os.MkdirAll(subs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	french := filepath.Join(subs, "French.srt")
+	if err := os.WriteFile(french, []byte("1\n00:00:01,000 --> 00:00:02,000\nBonjour\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := lib.RefreshSubtitles(french); err != nil || !changed {
+		t.Fatalf("RefreshSubtitles for Subs/ = %v, %v, want a change", changed, err)
+	}
+	if langs := languages(); len(langs) != 2 {
+		t.Errorf("subtitles = %v, want English and French", langs)
+	}
+
+	if err := os.Remove(english); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := lib.RefreshSubtitles(english); err != nil || !changed {
+		t.Fatalf("RefreshSubtitles after a deletion = %v, %v, want a change", changed, err)
+	}
+	if langs := languages(); len(langs) != 1 || langs[0] != "fr" {
+		t.Errorf("subtitles = %v, want [fr]", langs)
+	}
+}
@@ -1,12 +1,13 @@
 package library
 
 import (
+	"bytes"
 	"context"
-	"crypto/sha256"
 	"database/sql"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -20,31 +21,65 @@ import (
 	_ "modernc.org/sqlite"
 )
 
+var (
+	// ErrMovieNotFound is returned for an unknown movie ID
+	ErrMovieNotFound = errors.New("movie not found")
+
+	// ErrSubtitleNotFound is returned for an unknown managed subtitle ID
+	ErrSubtitleNotFound = errors.New("subtitle not found")
+
+	// ErrVersionNotFound is returned for an unknown version ID
+	ErrVersionNotFound = errors.New("version not found")
+)
+
 // Library manages the media library
 type Library struct {
-	config *config.Config
-	db     *sql.DB
-	mu     sync.RWMutex
-	movies map[string]*Movie
+	config  *config.Config
+	db      *sql.DB
+	mu      sync.RWMutex
+	movies  map[string]*Movie
+	offline map[string]*Movie // Missing or unavailable, kept out of listings
+	aliases map[string]string // Path-based IDs from older databases to current IDs
+
+	// scanMu serializes full scans with incremental updates from the watcher
+	scanMu sync.Mutex
+
+	// idMu guards claimed, the IDs of movies being saved by scan workers
+	idMu    sync.Mutex
+	claimed map[string]bool
 }
 
 // NewLibrary creates a new library instance
 func NewLibrary(cfg *config.Config) (*Library, error) {
-	db, err := sql.Open("sqlite", cfg.DBPath)
+	// Scan workers, the watcher and API edits write concurrently, so wait
+	// for locks instead of failing with SQLITE_BUSY
+	db, err := sql.Open("sqlite", cfg.DBPath+"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
+	if cfg.DBPath == ":memory:" {
+		// Every connection would get its own empty database
+		db.SetMaxOpenConns(1)
+	}
 
 	lib := &Library{
-		config: cfg,
-		db:     db,
-		movies: make(map[string]*Movie),
+		config:  cfg,
+		db:      db,
+		movies:  make(map[string]*Movie),
+		offline: make(map[string]*Movie),
+		aliases: make(map[string]string),
+		claimed: make(map[string]bool),
 	}
 
 	if err := lib.initDB(); err != nil {
 		return nil, fmt.Errorf("failed to initialize database: %w", err)
 	}
 
+	// Serve what earlier scans found while the next one runs
+	if err := lib.loadFromDB(); err != nil {
+		return nil, fmt.Errorf("failed to load library: %w", err)
+	}
+
 	return lib, nil
 }
 
@@ -72,48 +107,73 @@ func (l *Library) initDB() error {
 	CREATE INDEX IF NOT EXISTS idx_movies_title ON movies(title);
 	CREATE INDEX IF NOT EXISTS idx_movies_file_path ON movies(file_path);
 	`
-	_, err := l.db.Exec(schema)
-	return err
+	if _, err := l.db.Exec(schema); err != nil {
+		return err
+	}
+	if err := l.addMissingColumns(); err != nil {
+		return err
+	}
+	if _, err := l.db.Exec(`CREATE INDEX IF NOT EXISTS idx_movies_fingerprint ON movies(fingerprint)`); err != nil {
+		return err
+	}
+	if err := l.initVersionsDB(); err != nil {
+		return err
+	}
+	if err := l.initManagedSubtitlesDB(); err != nil {
+		return err
+	}
+	if err := l.initAliasesDB(); err != nil {
+		return err
+	}
+	return l.migrate()
 }
 
-// Scan scans the media directories for movies
-func (l *Library) Scan(ctx context.Context) error {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+// addedColumns are movie columns introduced after the original schema
+var addedColumns = []struct {
+	name string
+	def  string
+}{
+	{"pix_fmt", "TEXT"},
+	{"color_transfer", "TEXT"},
+	{"color_primaries", "TEXT"},
+	{"color_space", "TEXT"},
+	{"metadata_version", "INTEGER DEFAULT 0"},
+	{"audio_channel_layout", "TEXT"},
+	{"frame_rate", "REAL"},
+	{"field_order", "TEXT"},
+	{"fingerprint", "TEXT"},
+	{"status", "TEXT DEFAULT 'online'"},
+	{"missing_since", "DATETIME"},
+}
 
-	for _, mediaPath := range l.config.MediaPaths {
-		if err := l.scanDirectory(ctx, mediaPath); err != nil {
+// addMissingColumns upgrades databases created by older versions
+func (l *Library) addMissingColumns() error {
+	rows, err := l.db.Query(`PRAGMA table_info(movies)`)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			rows.Close()
 			return err
 		}
+		existing[name] = true
 	}
+	rows.Close()
 
-	return l.loadFromDB()
-}
-
-// scanDirectory recursively scans a directory for video files
-func (l *Library) scanDirectory(ctx context.Context, dir string) error {
-	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil // Skip files we can't access
-		}
-
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
-
-		if info.IsDir() {
-			return nil
+	for _, col := range addedColumns {
+		if existing[col.name] {
+			continue
 		}
-
-		ext := strings.ToLower(filepath.Ext(path))
-		if !l.isVideoExtension(ext) {
-			return nil
+		if _, err := l.db.Exec(fmt.Sprintf("ALTER TABLE movies ADD COLUMN %s %s", col.name, col.def)); err != nil {
+			return fmt.Errorf("failed to add column %s: %w", col.name, err)
 		}
-
-		return l.processVideoFile(ctx, path, info)
-	})
+	}
+	return nil
 }
 
 // isVideoExtension checks if the extension is a supported video format
@@ -126,28 +186,57 @@ func (l *Library) isVideoExtension(ext string) bool {
 	return false
 }
 
-// processVideoFile processes a single video file
-func (l *Library) processVideoFile(ctx context.Context, path string, info os.FileInfo) error {
-	id := l.generateID(path)
+// processVideoFile processes a single video file and reports whether it
+// saved new metadata. Errors are about this file alone.
+func (l *Library) processVideoFile(ctx context.Context, path string, info os.FileInfo) (bool, error) {
+	if l.isVersionFile(path) {
+		return false, nil // Optimized copy of another movie
+	}
 
 	// Check if already in database and up-to-date
-	existing, err := l.getMovieFromDB(id)
-	if err == nil && existing.ModifiedAt.Equal(info.ModTime()) {
-		return nil // No changes
+	existing, err := l.getMovieByPath(path)
+	if err == nil && existing.ModifiedAt.Equal(info.ModTime()) && existing.MetadataVersion >= metadataVersion {
+		return l.refreshUnchanged(existing, info.Size())
 	}
 
+	fingerprint, err := fingerprintFile(path, info.Size())
+	if err != nil {
+		return false, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	id, moved := l.claimMovieID(existing, fingerprint, path)
+	defer l.releaseMovieID(id)
+
 	// Extract metadata using ffprobe
 	movie, err := l.extractMetadata(ctx, path, info)
 	if err != nil {
-		// Skip this file but continue scanning
-		return nil
+		return false, err
 	}
+	movie.ID = id
+	movie.Fingerprint = fingerprint
 
 	// Find external subtitles
 	movie.Subtitles = append(movie.Subtitles, l.findExternalSubtitles(path)...)
 
+	// Re-probing an unchanged or moved file only refreshes metadata
+	previous := existing
+	if moved != nil {
+		previous = moved
+	}
+	sameContent := moved != nil || (existing != nil && existing.ModifiedAt.Equal(info.ModTime()))
+	if sameContent {
+		movie.AddedAt = previous.AddedAt
+	}
+
+	// Keep charsets the user corrected by hand
+	if previous != nil {
+		keepManualCharsets(movie, previous)
+	}
+
 	// Generate thumbnail
-	if l.config.ThumbnailDir != "" {
+	if sameContent && previous.ThumbnailPath != "" && fileExists(previous.ThumbnailPath) {
+		movie.ThumbnailPath = previous.ThumbnailPath
+	} else if l.config.ThumbnailDir != "" {
 		thumbPath := filepath.Join(l.config.ThumbnailDir, id+".jpg")
 		if err := l.generateThumbnail(ctx, path, thumbPath, movie.Duration); err == nil {
 			movie.ThumbnailPath = thumbPath
@@ -155,19 +244,22 @@ func (l *Library) processVideoFile(ctx context.Context, path string, info os.Fil
 	}
 
 	// Save to database
-	return l.saveMovie(movie)
+	if err := l.saveMovie(movie); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
-// generateID creates a unique ID for a file based on its path
-func (l *Library) generateID(path string) string {
-	hash := sha256.Sum256([]byte(path))
-	return hex.EncodeToString(hash[:8])
+// fileExists reports whether a path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
 }
 
 // extractMetadata uses ffprobe to extract video metadata
 func (l *Library) extractMetadata(ctx context.Context, path string, info os.FileInfo) (*Movie, error) {
 	cmd := exec.CommandContext(ctx, l.config.FFprobePath,
-		"-v", "quiet",
+		"-v", "error",
 		"-print_format", "json",
 		"-show_format",
 		"-show_streams",
@@ -176,6 +268,10 @@ func (l *Library) extractMetadata(ctx context.Context, path string, info os.File
 
 	output, err := cmd.Output()
 	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(bytes.TrimSpace(exitErr.Stderr)) > 0 {
+			return nil, fmt.Errorf("ffprobe failed: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
 		return nil, fmt.Errorf("ffprobe failed: %w", err)
 	}
 
@@ -186,14 +282,22 @@ func (l *Library) extractMetadata(ctx context.Context, path string, info os.File
 			BitRate  string `json:"bit_rate"`
 		} `json:"format"`
 		Streams []struct {
-			CodecType string `json:"codec_type"`
-			CodecName string `json:"codec_name"`
-			Width     int    `json:"width"`
-			Height    int    `json:"height"`
-			BitRate   string `json:"bit_rate"`
-			Channels  int    `json:"channels"`
-			Index     int    `json:"index"`
-			Tags      struct {
+			CodecType      string `json:"codec_type"`
+			CodecName      string `json:"codec_name"`
+			Width          int    `json:"width"`
+			Height         int    `json:"height"`
+			BitRate        string `json:"bit_rate"`
+			PixFmt         string `json:"pix_fmt"`
+			ColorTransfer  string `json:"color_transfer"`
+			ColorPrimaries string `json:"color_primaries"`
+			ColorSpace     string `json:"color_space"`
+			FieldOrder     string `json:"field_order"`
+			AvgFrameRate   string `json:"avg_frame_rate"`
+			RFrameRate     string `json:"r_frame_rate"`
+			Channels       int    `json:"channels"`
+			ChannelLayout  string `json:"channel_layout"`
+			Index          int    `json:"index"`
+			Tags           struct {
 				Language string `json:"language"`
 				Title    string `json:"title"`
 			} `json:"tags"`
@@ -205,11 +309,12 @@ func (l *Library) extractMetadata(ctx context.Context, path string, info os.File
 	}
 
 	movie := &Movie{
-		ID:         l.generateID(path),
-		FilePath:   path,
-		FileSize:   info.Size(),
-		AddedAt:    time.Now(),
-		ModifiedAt: info.ModTime(),
+		FilePath:        path,
+		FileSize:        info.Size(),
+		AddedAt:         time.Now(),
+		ModifiedAt:      info.ModTime(),
+		MetadataVersion: metadataVersion,
+		Status:          StatusOnline,
 	}
 
 	// Parse title from filename
@@ -228,6 +333,15 @@ func (l *Library) extractMetadata(ctx context.Context, path string, info os.File
 				movie.VideoCodec = stream.CodecName
 				movie.VideoWidth = stream.Width
 				movie.VideoHeight = stream.Height
+				movie.PixelFormat = stream.PixFmt
+				movie.ColorTransfer = stream.ColorTransfer
+				movie.ColorPrimaries = stream.ColorPrimaries
+				movie.ColorSpace = stream.ColorSpace
+				movie.FieldOrder = stream.FieldOrder
+				movie.FrameRate = parseFrameRate(stream.AvgFrameRate)
+				if movie.FrameRate == 0 {
+					movie.FrameRate = parseFrameRate(stream.RFrameRate)
+				}
 				if br, err := strconv.ParseInt(stream.BitRate, 10, 64); err == nil {
 					movie.VideoBitrate = br
 				}
@@ -236,6 +350,7 @@ func (l *Library) extractMetadata(ctx context.Context, path string, info os.File
 			if movie.AudioCodec == "" {
 				movie.AudioCodec = stream.CodecName
 				movie.AudioChannels = stream.Channels
+				movie.AudioChannelLayout = stream.ChannelLayout
 			}
 		case "subtitle":
 			movie.Subtitles = append(movie.Subtitles, Subtitle{
@@ -248,9 +363,72 @@ func (l *Library) extractMetadata(ctx context.Context, path string, info os.File
 		}
 	}
 
+	// Broadcast recordings often don't declare their field order
+	if movie.VideoCodec != "" && (movie.FieldOrder == "" || movie.FieldOrder == "unknown") {
+		ext := strings.ToLower(filepath.Ext(path))
+		if ext == ".ts" || ext == ".m2ts" {
+			movie.FieldOrder = l.detectFieldOrder(ctx, path)
+		}
+	}
+
 	return movie, nil
 }
 
+// idetPattern matches idet's "Multi frame detection" summary line
+var idetPattern = regexp.MustCompile(`Multi frame detection: TFF:\s*(\d+)\s*BFF:\s*(\d+)\s*Progressive:\s*(\d+)`)
+
+// detectFieldOrder runs ffmpeg's idet filter over the first frames and
+// returns "tt", "bb" or "progressive" ("" if it couldn't tell)
+func (l *Library) detectFieldOrder(ctx context.Context, path string) string {
+	cmd := exec.CommandContext(ctx, l.config.FFmpegPath,
+		"-hide_banner",
+		"-i", path,
+		"-map", "0:v:0",
+		"-vf", "idet",
+		"-frames:v", "300",
+		"-an", "-sn",
+		"-f", "null", "-",
+	)
+
+	// idet reports on stderr
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return ""
+	}
+
+	matches := idetPattern.FindStringSubmatch(string(output))
+	if matches == nil {
+		return ""
+	}
+	tff, _ := strconv.Atoi(matches[1])
+	bff, _ := strconv.Atoi(matches[2])
+	progressive, _ := strconv.Atoi(matches[3])
+
+	switch {
+	case tff+bff <= progressive:
+		return "progressive"
+	case tff >= bff:
+		return "tt"
+	default:
+		return "bb"
+	}
+}
+
+// parseFrameRate parses ffprobe's rational frame rates such as "24000/1001"
+func parseFrameRate(rate string) float64 {
+	num, den, ok := strings.Cut(rate, "/")
+	if !ok {
+		f, _ := strconv.ParseFloat(rate, 64)
+		return f
+	}
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
 // parseFilename extracts title and year from filename
 func (l *Library) parseFilename(filename string) (string, int) {
 	// Remove extension
@@ -275,15 +453,102 @@ func (l *Library) parseFilename(filename string) (string, int) {
 	return name, year
 }
 
-// findExternalSubtitles looks for external subtitle files
+// subtitleExtensions are the subtitle files picked up next to videos
+var subtitleExtensions = []string{".srt", ".ass", ".ssa", ".sub", ".vtt"}
+
+// subtitleDirNames are the subfolders release groups put subtitles in
+var subtitleDirNames = []string{"subs", "subtitles"}
+
+// languageNames maps language names used in subtitle file names to codes
+var languageNames = map[string]string{
+	"english": "en", "spanish": "es", "french": "fr", "german": "de",
+	"italian": "it", "portuguese": "pt", "brazilian": "pt", "russian": "ru",
+	"chinese": "zh", "japanese": "ja", "korean": "ko", "arabic": "ar",
+	"dutch": "nl", "polish": "pl", "czech": "cs", "turkish": "tr",
+	"swedish": "sv", "danish": "da", "norwegian": "no", "finnish": "fi",
+	"greek": "el", "hebrew": "he", "hungarian": "hu", "romanian": "ro",
+	"ukrainian": "uk", "indonesian": "id", "vietnamese": "vi", "thai": "th",
+}
+
+// subtitleNameTags are name tokens that look like language codes but aren't
+var subtitleNameTags = map[string]bool{"sdh": true, "cc": true, "hi": true, "the": true}
+
+// IsSubtitleExtension checks if the extension (with dot, lowercase) is a supported subtitle format
+func IsSubtitleExtension(ext string) bool {
+	for _, e := range subtitleExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// isVobSub reports whether a .sub file is a VobSub image track rather than
+// SubViewer or MicroDVD text: it comes with an .idx index, or holds MPEG
+// program stream packs. Image tracks can't be read as text.
+func isVobSub(path string) bool {
+	if _, err := os.Stat(strings.TrimSuffix(path, filepath.Ext(path)) + ".idx"); err == nil {
+		return true
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return false
+	}
+	return bytes.Equal(header, []byte{0x00, 0x00, 0x01, 0xBA})
+}
+
+// findExternalSubtitles looks for external subtitle files next to the video
+// and in Subs/ subfolders
 func (l *Library) findExternalSubtitles(videoPath string) []Subtitle {
 	var subtitles []Subtitle
 	dir := filepath.Dir(videoPath)
 	baseName := strings.TrimSuffix(filepath.Base(videoPath), filepath.Ext(videoPath))
 
-	// Look for .srt, .ass, .ssa, .sub files
-	subExts := []string{".srt", ".ass", ".ssa", ".sub", ".vtt"}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return subtitles
+	}
 
+	subtitles = l.appendSubtitlesInDir(subtitles, dir, baseName, true)
+
+	// A Subs/ folder next to a lone video belongs to it. With several
+	// videos, subtitles go in Subs/<video name>/ or carry the video name.
+	videos := 0
+	for _, entry := range entries {
+		if !entry.IsDir() && l.isVideoExtension(strings.ToLower(filepath.Ext(entry.Name()))) {
+			videos++
+		}
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !isSubtitleDirName(entry.Name()) {
+			continue
+		}
+		subDir := filepath.Join(dir, entry.Name())
+		subtitles = l.appendSubtitlesInDir(subtitles, subDir, baseName, videos > 1)
+		subtitles = l.appendSubtitlesInDir(subtitles, filepath.Join(subDir, baseName), baseName, false)
+	}
+
+	return subtitles
+}
+
+// isSubtitleDirName reports whether a folder name is a subtitle folder
+func isSubtitleDirName(name string) bool {
+	for _, n := range subtitleDirNames {
+		if strings.EqualFold(name, n) {
+			return true
+		}
+	}
+	return false
+}
+
+// appendSubtitlesInDir adds the subtitle files in dir. With matchName, only
+// files named after the video are taken.
+func (l *Library) appendSubtitlesInDir(subtitles []Subtitle, dir, baseName string, matchName bool) []Subtitle {
 	entries, err := os.ReadDir(dir)
 	if err != nil {
 		return subtitles
@@ -296,45 +561,72 @@ func (l *Library) findExternalSubtitles(videoPath string) []Subtitle {
 
 		name := entry.Name()
 		ext := strings.ToLower(filepath.Ext(name))
-
-		// Check if it's a subtitle file
-		isSubtitle := false
-		for _, subExt := range subExts {
-			if ext == subExt {
-				isSubtitle = true
-				break
-			}
-		}
-		if !isSubtitle {
+		if !IsSubtitleExtension(ext) {
 			continue
 		}
 
 		// Check if it matches the video file
 		subBase := strings.TrimSuffix(name, ext)
-		if !strings.HasPrefix(subBase, baseName) {
+		if matchName && !strings.HasPrefix(subBase, baseName) {
 			continue
 		}
-
-		// Extract language from filename (e.g., "movie.en.srt")
-		lang := ""
-		suffix := strings.TrimPrefix(subBase, baseName)
-		suffix = strings.Trim(suffix, "._-")
-		if len(suffix) == 2 || len(suffix) == 3 {
-			lang = suffix
+		if ext == ".sub" && isVobSub(filepath.Join(dir, name)) {
+			continue
 		}
 
-		subtitles = append(subtitles, Subtitle{
+		// Extract language from filename (e.g., "movie.en.srt" or "Subs/2_English.srt")
+		lang, forced := parseSubtitleName(strings.TrimPrefix(subBase, baseName))
+
+		sub := Subtitle{
 			Index:      len(subtitles),
 			Language:   lang,
 			FilePath:   filepath.Join(dir, name),
 			IsExternal: true,
 			Format:     strings.TrimPrefix(ext, "."),
-		})
+			Forced:     forced,
+		}
+
+		// Legacy code pages burn in as mojibake unless ffmpeg is told
+		if charset, err := detectFileCharset(sub.FilePath, lang); err == nil {
+			sub.Charset = charset
+		}
+
+		subtitles = append(subtitles, sub)
 	}
 
 	return subtitles
 }
 
+// parseSubtitleName extracts the language and forced flag from the part of
+// a subtitle file name after the video name, e.g. ".en.forced" or "2_English"
+func parseSubtitleName(name string) (lang string, forced bool) {
+	tokens := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return r == '.' || r == '_' || r == '-' || r == ' '
+	})
+
+	for _, token := range tokens {
+		switch {
+		case token == "forced":
+			forced = true
+		case languageNames[token] != "":
+			lang = languageNames[token]
+		case (len(token) == 2 || len(token) == 3) && !subtitleNameTags[token] && isLetters(token):
+			lang = token
+		}
+	}
+	return lang, forced
+}
+
+// isLetters reports whether s only contains ASCII letters
+func isLetters(s string) bool {
+	for _, r := range s {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
 // generateThumbnail generates a thumbnail for the video
 func (l *Library) generateThumbnail(ctx context.Context, videoPath, thumbPath string, duration int) error {
 	// Seek to 10% of the video or 30 seconds, whichever is less
@@ -360,51 +652,76 @@ func (l *Library) generateThumbnail(ctx context.Context, videoPath, thumbPath st
 
 // saveMovie saves a movie to the database
 func (l *Library) saveMovie(movie *Movie) error {
-	subtitlesJSON, _ := json.Marshal(movie.Subtitles)
+	// Managed subtitles live in their own table
+	subtitlesJSON, _ := json.Marshal(scannedSubtitles(movie.Subtitles))
 
 	_, err := l.db.Exec(`
-		INSERT OR REPLACE INTO movies (
-			id, title, year, duration, file_path, file_size,
-			video_codec, video_width, video_height, video_bitrate,
-			audio_codec, audio_channels, subtitles, thumbnail_path,
-			added_at, modified_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT OR REPLACE INTO movies (`+movieColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
 		movie.ID, movie.Title, movie.Year, movie.Duration, movie.FilePath, movie.FileSize,
 		movie.VideoCodec, movie.VideoWidth, movie.VideoHeight, movie.VideoBitrate,
 		movie.AudioCodec, movie.AudioChannels, string(subtitlesJSON), movie.ThumbnailPath,
 		movie.AddedAt, movie.ModifiedAt,
+		movie.PixelFormat, movie.ColorTransfer, movie.ColorPrimaries, movie.ColorSpace,
+		movie.MetadataVersion, movie.AudioChannelLayout,
+		movie.FrameRate, movie.FieldOrder,
+		movie.Fingerprint, movie.Status, movie.MissingSince,
 	)
 
 	return err
 }
 
+// movieColumns lists the movie columns in the order scanMovie reads them
+const movieColumns = `
+	id, title, year, duration, file_path, file_size,
+	video_codec, video_width, video_height, video_bitrate,
+	audio_codec, audio_channels, subtitles, thumbnail_path,
+	added_at, modified_at,
+	pix_fmt, color_transfer, color_primaries, color_space,
+	metadata_version, audio_channel_layout,
+	frame_rate, field_order,
+	fingerprint, status, missing_since`
+
 // getMovieFromDB retrieves a movie from the database
 func (l *Library) getMovieFromDB(id string) (*Movie, error) {
-	row := l.db.QueryRow(`
-		SELECT id, title, year, duration, file_path, file_size,
-			video_codec, video_width, video_height, video_bitrate,
-			audio_codec, audio_channels, subtitles, thumbnail_path,
-			added_at, modified_at
-		FROM movies WHERE id = ?
-	`, id)
+	row := l.db.QueryRow(`SELECT `+movieColumns+` FROM movies WHERE id = ?`, id)
+	return l.scanMovie(row)
+}
 
+// getMovieByPath retrieves the movie stored at a file path
+func (l *Library) getMovieByPath(path string) (*Movie, error) {
+	row := l.db.QueryRow(`SELECT `+movieColumns+` FROM movies WHERE file_path = ?`, path)
 	return l.scanMovie(row)
 }
 
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
 // scanMovie scans a database row into a Movie
-func (l *Library) scanMovie(row *sql.Row) (*Movie, error) {
+func (l *Library) scanMovie(row rowScanner) (*Movie, error) {
 	var movie Movie
 	var subtitlesJSON string
 	var year, duration, width, height, channels sql.NullInt64
-	var videoBitrate sql.NullInt64
+	var videoBitrate, metadataVersion sql.NullInt64
 	var videoCodec, audioCodec, thumbnailPath sql.NullString
+	var pixFmt, colorTransfer, colorPrimaries, colorSpace sql.NullString
+	var channelLayout, fieldOrder sql.NullString
+	var frameRate sql.NullFloat64
+	var fingerprint, status sql.NullString
+	var missingSince sql.NullTime
 
 	err := row.Scan(
 		&movie.ID, &movie.Title, &year, &duration, &movie.FilePath, &movie.FileSize,
 		&videoCodec, &width, &height, &videoBitrate,
 		&audioCodec, &channels, &subtitlesJSON, &thumbnailPath,
 		&movie.AddedAt, &movie.ModifiedAt,
+		&pixFmt, &colorTransfer, &colorPrimaries, &colorSpace,
+		&metadataVersion, &channelLayout,
+		&frameRate, &fieldOrder,
+		&fingerprint, &status, &missingSince,
 	)
 	if err != nil {
 		return nil, err
@@ -437,82 +754,73 @@ func (l *Library) scanMovie(row *sql.Row) (*Movie, error) {
 	if thumbnailPath.Valid {
 		movie.ThumbnailPath = thumbnailPath.String
 	}
+	movie.PixelFormat = pixFmt.String
+	movie.ColorTransfer = colorTransfer.String
+	movie.ColorPrimaries = colorPrimaries.String
+	movie.ColorSpace = colorSpace.String
+	movie.MetadataVersion = int(metadataVersion.Int64)
+	movie.AudioChannelLayout = channelLayout.String
+	movie.FrameRate = frameRate.Float64
+	movie.FieldOrder = fieldOrder.String
+	movie.Fingerprint = fingerprint.String
+	movie.Status = status.String
+	if movie.Status == "" {
+		movie.Status = StatusOnline
+	}
+	if missingSince.Valid {
+		movie.MissingSince = &missingSince.Time
+	}
 
 	if subtitlesJSON != "" {
 		json.Unmarshal([]byte(subtitlesJSON), &movie.Subtitles)
 	}
+	for i := range movie.Subtitles {
+		movie.Subtitles[i].ID = subtitleID(movie.Subtitles[i])
+	}
 
 	return &movie, nil
 }
 
 // loadFromDB loads all movies from the database into memory
 func (l *Library) loadFromDB() error {
-	rows, err := l.db.Query(`
-		SELECT id, title, year, duration, file_path, file_size,
-			video_codec, video_width, video_height, video_bitrate,
-			audio_codec, audio_channels, subtitles, thumbnail_path,
-			added_at, modified_at
-		FROM movies ORDER BY title
-	`)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rows, err := l.db.Query(`SELECT ` + movieColumns + ` FROM movies ORDER BY title`)
 	if err != nil {
 		return err
 	}
 	defer rows.Close()
 
-	l.movies = make(map[string]*Movie)
+	movies := make(map[string]*Movie)
+	offline := make(map[string]*Movie)
 
 	for rows.Next() {
-		var movie Movie
-		var subtitlesJSON string
-		var year, duration, width, height, channels sql.NullInt64
-		var videoBitrate sql.NullInt64
-		var videoCodec, audioCodec, thumbnailPath sql.NullString
-
-		err := rows.Scan(
-			&movie.ID, &movie.Title, &year, &duration, &movie.FilePath, &movie.FileSize,
-			&videoCodec, &width, &height, &videoBitrate,
-			&audioCodec, &channels, &subtitlesJSON, &thumbnailPath,
-			&movie.AddedAt, &movie.ModifiedAt,
-		)
+		movie, err := l.scanMovie(rows)
 		if err != nil {
 			continue
 		}
-
-		if year.Valid {
-			movie.Year = int(year.Int64)
-		}
-		if duration.Valid {
-			movie.Duration = int(duration.Int64)
-		}
-		if videoCodec.Valid {
-			movie.VideoCodec = videoCodec.String
-		}
-		if width.Valid {
-			movie.VideoWidth = int(width.Int64)
-		}
-		if height.Valid {
-			movie.VideoHeight = int(height.Int64)
-		}
-		if videoBitrate.Valid {
-			movie.VideoBitrate = videoBitrate.Int64
-		}
-		if audioCodec.Valid {
-			movie.AudioCodec = audioCodec.String
-		}
-		if channels.Valid {
-			movie.AudioChannels = int(channels.Int64)
-		}
-		if thumbnailPath.Valid {
-			movie.ThumbnailPath = thumbnailPath.String
-		}
-
-		if subtitlesJSON != "" {
-			json.Unmarshal([]byte(subtitlesJSON), &movie.Subtitles)
+		if movie.IsOnline() {
+			movies[movie.ID] = movie
+		} else {
+			offline[movie.ID] = movie
 		}
+	}
 
-		l.movies[movie.ID] = &movie
+	if err := l.loadVersions(movies); err != nil {
+		return err
+	}
+	if err := l.loadManagedSubtitles(movies); err != nil {
+		return err
+	}
+	aliases, err := l.loadAliases()
+	if err != nil {
+		return err
 	}
 
+	l.movies = movies
+	l.offline = offline
+	l.aliases = aliases
 	return nil
 }
 
@@ -533,9 +841,9 @@ func (l *Library) GetMovie(id string) (*Movie, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
-	movie, ok := l.movies[id]
+	movie, ok := l.lookup(id)
 	if !ok {
-		return nil, fmt.Errorf("movie not found: %s", id)
+		return nil, fmt.Errorf("%w: %s", ErrMovieNotFound, id)
 	}
 	return movie, nil
 }
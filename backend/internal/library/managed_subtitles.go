@@ -0,0 +1,306 @@
+package library
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxSubtitleBytes caps the size of an uploaded subtitle file
+const maxSubtitleBytes = 10 << 20
+
+// SubtitleMeta describes a subtitle being attached to a movie
+type SubtitleMeta struct {
+	Language string `json:"language"`
+	Title    string `json:"title"`
+	Forced   bool   `json:"forced"`
+	Default  bool   `json:"default"`
+}
+
+// SubtitleUpdate changes the metadata of a managed subtitle; nil fields are
+// left as they are
+type SubtitleUpdate struct {
+	Language *string `json:"language"`
+	Title    *string `json:"title"`
+	Forced   *bool   `json:"forced"`
+	Default  *bool   `json:"default"`
+}
+
+// initManagedSubtitlesDB creates the table of subtitles attached through the API
+func (l *Library) initManagedSubtitlesDB() error {
+	_, err := l.db.Exec(`
+	CREATE TABLE IF NOT EXISTS movie_subtitles (
+		id TEXT PRIMARY KEY,
+		movie_id TEXT NOT NULL,
+		file_path TEXT UNIQUE NOT NULL,
+		format TEXT,
+		language TEXT,
+		title TEXT,
+		forced INTEGER DEFAULT 0,
+		is_default INTEGER DEFAULT 0,
+		charset TEXT,
+		charset_manual INTEGER DEFAULT 0,
+		created_at DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS idx_movie_subtitles_movie ON movie_subtitles(movie_id);
+	`)
+	return err
+}
+
+// loadManagedSubtitles attaches managed subtitles to the given movies
+func (l *Library) loadManagedSubtitles(movies map[string]*Movie) error {
+	rows, err := l.db.Query(`
+		SELECT id, movie_id, file_path, format, language, title,
+			forced, is_default, charset, charset_manual
+		FROM movie_subtitles ORDER BY created_at
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movieID string
+		var format, language, title, charset sql.NullString
+		sub := Subtitle{IsExternal: true, Managed: true}
+		err := rows.Scan(
+			&sub.ID, &movieID, &sub.FilePath, &format, &language, &title,
+			&sub.Forced, &sub.Default, &charset, &sub.CharsetManual,
+		)
+		if err != nil {
+			continue
+		}
+		sub.Format = format.String
+		sub.Language = language.String
+		sub.Title = title.String
+		sub.Charset = charset.String
+
+		if movie, ok := movies[movieID]; ok {
+			sub.Index = len(movie.Subtitles)
+			movie.Subtitles = append(movie.Subtitles, sub)
+		}
+	}
+	return rows.Err()
+}
+
+// AddSubtitle stores a subtitle file in the managed directory and attaches
+// it to a movie. name is the original file name, used for its format.
+func (l *Library) AddSubtitle(movieID, name string, src io.Reader, meta SubtitleMeta) (*Subtitle, error) {
+	ext := strings.ToLower(filepath.Ext(name))
+	if !IsSubtitleExtension(ext) {
+		return nil, fmt.Errorf("unsupported subtitle format: %q", ext)
+	}
+
+	movie, err := l.GetMovie(movieID)
+	if err != nil {
+		return nil, err
+	}
+	movieID = movie.ID
+
+	sub := Subtitle{
+		ID:         uuid.New().String(),
+		Language:   strings.TrimSpace(meta.Language),
+		Title:      strings.TrimSpace(meta.Title),
+		IsExternal: true,
+		Format:     strings.TrimPrefix(ext, "."),
+		Forced:     meta.Forced,
+		Default:    meta.Default,
+		Managed:    true,
+	}
+
+	dir := filepath.Join(l.config.ManagedSubtitleDir, movieID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create subtitle dir: %w", err)
+	}
+	sub.FilePath = filepath.Join(dir, sub.ID+ext)
+	if err := writeSubtitleFile(sub.FilePath, src); err != nil {
+		return nil, err
+	}
+	if ext == ".sub" && isVobSub(sub.FilePath) {
+		os.Remove(sub.FilePath)
+		return nil, fmt.Errorf("unsupported subtitle format: VobSub is image-based")
+	}
+
+	if charset, err := detectFileCharset(sub.FilePath, sub.Language); err == nil {
+		sub.Charset = charset
+	}
+
+	if err := l.attachSubtitle(movieID, sub); err != nil {
+		os.Remove(sub.FilePath)
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// writeSubtitleFile copies src to path, refusing files over maxSubtitleBytes
+func writeSubtitleFile(path string, src io.Reader) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create subtitle file: %w", err)
+	}
+
+	n, err := io.Copy(f, io.LimitReader(src, maxSubtitleBytes+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n > maxSubtitleBytes {
+		err = fmt.Errorf("subtitle file exceeds %d MiB", maxSubtitleBytes>>20)
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// attachSubtitle records a managed subtitle and adds it to the movie
+func (l *Library) attachSubtitle(movieID string, sub Subtitle) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	movie, ok := l.lookup(movieID)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrMovieNotFound, movieID)
+	}
+	movieID = movie.ID
+
+	_, err := l.db.Exec(`
+		INSERT INTO movie_subtitles (
+			id, movie_id, file_path, format, language, title,
+			forced, is_default, charset, charset_manual, created_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		sub.ID, movieID, sub.FilePath, sub.Format, sub.Language, sub.Title,
+		sub.Forced, sub.Default, sub.Charset, sub.CharsetManual, time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	// Movies are shared with readers, so swap in an updated copy
+	updated := *movie
+	updated.Subtitles = append([]Subtitle(nil), movie.Subtitles...)
+	sub.Index = len(updated.Subtitles)
+	updated.Subtitles = append(updated.Subtitles, sub)
+	if sub.Default {
+		if err := l.clearDefaultSubtitle(&updated, sub.ID); err != nil {
+			return err
+		}
+	}
+	l.movies[movieID] = &updated
+
+	return nil
+}
+
+// UpdateSubtitle changes the language, title or flags of a managed subtitle
+func (l *Library) UpdateSubtitle(movieID, subtitleID string, update SubtitleUpdate) (*Subtitle, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	movie, ok := l.lookup(movieID)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrMovieNotFound, movieID)
+	}
+	movieID = movie.ID
+
+	updated := *movie
+	updated.Subtitles = append([]Subtitle(nil), movie.Subtitles...)
+	sub := findManagedSubtitle(updated.Subtitles, subtitleID)
+	if sub == nil {
+		return nil, fmt.Errorf("%w: %s", ErrSubtitleNotFound, subtitleID)
+	}
+
+	if update.Language != nil {
+		sub.Language = strings.TrimSpace(*update.Language)
+	}
+	if update.Title != nil {
+		sub.Title = strings.TrimSpace(*update.Title)
+	}
+	if update.Forced != nil {
+		sub.Forced = *update.Forced
+	}
+	if update.Default != nil {
+		sub.Default = *update.Default
+	}
+
+	_, err := l.db.Exec(`
+		UPDATE movie_subtitles SET language = ?, title = ?, forced = ?, is_default = ? WHERE id = ?
+	`, sub.Language, sub.Title, sub.Forced, sub.Default, sub.ID)
+	if err != nil {
+		return nil, err
+	}
+	if sub.Default {
+		if err := l.clearDefaultSubtitle(&updated, sub.ID); err != nil {
+			return nil, err
+		}
+	}
+	l.movies[movieID] = &updated
+
+	result := *sub
+	return &result, nil
+}
+
+// RemoveSubtitle detaches a managed subtitle and deletes its file
+func (l *Library) RemoveSubtitle(movieID, subtitleID string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	movie, ok := l.lookup(movieID)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrMovieNotFound, movieID)
+	}
+	movieID = movie.ID
+
+	updated := *movie
+	updated.Subtitles = nil
+	var removed *Subtitle
+	for i, sub := range movie.Subtitles {
+		if sub.Managed && sub.ID == subtitleID {
+			removed = &movie.Subtitles[i]
+			continue
+		}
+		updated.Subtitles = append(updated.Subtitles, sub)
+	}
+	if removed == nil {
+		return fmt.Errorf("%w: %s", ErrSubtitleNotFound, subtitleID)
+	}
+
+	if _, err := l.db.Exec(`DELETE FROM movie_subtitles WHERE id = ?`, subtitleID); err != nil {
+		return err
+	}
+	os.Remove(removed.FilePath)
+	l.movies[movieID] = &updated
+
+	return nil
+}
+
+// clearDefaultSubtitle unsets the default flag on a movie's other managed
+// subtitles, so at most one is the default; callers hold l.mu
+func (l *Library) clearDefaultSubtitle(movie *Movie, keepID string) error {
+	_, err := l.db.Exec(`UPDATE movie_subtitles SET is_default = 0 WHERE movie_id = ? AND id != ?`, movie.ID, keepID)
+	if err != nil {
+		return err
+	}
+	for i := range movie.Subtitles {
+		if movie.Subtitles[i].Managed && movie.Subtitles[i].ID != keepID {
+			movie.Subtitles[i].Default = false
+		}
+	}
+	return nil
+}
+
+// findManagedSubtitle returns a pointer to the managed subtitle with the given ID
+func findManagedSubtitle(subs []Subtitle, id string) *Subtitle {
+	for i := range subs {
+		if subs[i].Managed && subs[i].ID == id {
+			return &subs[i]
+		}
+	}
+	return nil
+}
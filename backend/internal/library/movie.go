@@ -1,45 +1,212 @@
 package library
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
 	"time"
 )
 
 // Movie represents a movie in the library
 type Movie struct {
-	ID          string    `json:"id"`
-	Title       string    `json:"title"`
-	Year        int       `json:"year,omitempty"`
-	Duration    int       `json:"duration"` // Duration in seconds
-	FilePath    string    `json:"file_path"`
-	FileSize    int64     `json:"file_size"`
-	
+	ID       string `json:"id"`
+	Title    string `json:"title"`
+	Year     int    `json:"year,omitempty"`
+	Duration int    `json:"duration"` // Duration in seconds
+	FilePath string `json:"file_path"`
+	FileSize int64  `json:"file_size"`
+
 	// Video info
-	VideoCodec  string    `json:"video_codec"`
-	VideoWidth  int       `json:"video_width"`
-	VideoHeight int       `json:"video_height"`
-	VideoBitrate int64    `json:"video_bitrate"`
-	
+	VideoCodec     string  `json:"video_codec"`
+	VideoWidth     int     `json:"video_width"`
+	VideoHeight    int     `json:"video_height"`
+	VideoBitrate   int64   `json:"video_bitrate"`
+	PixelFormat    string  `json:"pixel_format,omitempty"`
+	ColorTransfer  string  `json:"color_transfer,omitempty"` // smpte2084 = HDR10/PQ, arib-std-b67 = HLG
+	ColorPrimaries string  `json:"color_primaries,omitempty"`
+	ColorSpace     string  `json:"color_space,omitempty"`
+	FrameRate      float64 `json:"frame_rate,omitempty"`
+	FieldOrder     string  `json:"field_order,omitempty"` // progressive, tt, bb, tb or bt
+
 	// Audio info
-	AudioCodec  string    `json:"audio_codec"`
-	AudioChannels int     `json:"audio_channels"`
-	
+	AudioCodec         string `json:"audio_codec"`
+	AudioChannels      int    `json:"audio_channels"`
+	AudioChannelLayout string `json:"audio_channel_layout,omitempty"` // e.g. "5.1(side)", "7.1"
+
 	// Subtitles
-	Subtitles   []Subtitle `json:"subtitles"`
-	
+	Subtitles []Subtitle `json:"subtitles"`
+
+	// Optimized copies produced by background jobs
+	Versions []Version `json:"versions,omitempty"`
+
 	// Metadata
-	ThumbnailPath string   `json:"thumbnail_path,omitempty"`
+	ThumbnailPath string    `json:"thumbnail_path,omitempty"`
 	AddedAt       time.Time `json:"added_at"`
 	ModifiedAt    time.Time `json:"modified_at"`
+
+	// Probe schema the metadata was extracted with; older rows are re-probed
+	MetadataVersion int `json:"-"`
+
+	// Size plus sampled content hash, used to follow files that are moved
+	Fingerprint string `json:"-"`
+
+	// Availability of the file; missing movies are purged after a grace period
+	Status       string     `json:"status"`
+	MissingSince *time.Time `json:"missing_since,omitempty"`
+}
+
+// Movie availability
+const (
+	StatusOnline      = "online"
+	StatusMissing     = "missing"     // File is gone from a reachable media path
+	StatusUnavailable = "unavailable" // Media path is unmounted or unreadable
+)
+
+// IsOnline reports whether the movie's file was present at the last check
+func (m *Movie) IsOnline() bool {
+	return m.Status == "" || m.Status == StatusOnline
+}
+
+// metadataVersion is bumped whenever extractMetadata learns new fields
+const metadataVersion = 5
+
+// IsInterlaced reports whether the video is stored as interlaced fields
+func (m *Movie) IsInterlaced() bool {
+	switch m.FieldOrder {
+	case "tt", "bb", "tb", "bt":
+		return true
+	}
+	return false
+}
+
+// IsHDR reports whether the video uses an HDR transfer function (HDR10 or HLG)
+func (m *Movie) IsHDR() bool {
+	switch m.ColorTransfer {
+	case "smpte2084", "arib-std-b67":
+		return true
+	}
+	return false
+}
+
+// IsHighBitDepth reports whether the video has more than 8 bits per
+// component, e.g. yuv420p10le
+func (m *Movie) IsHighBitDepth() bool {
+	format := strings.TrimSuffix(strings.TrimSuffix(m.PixelFormat, "le"), "be")
+	return strings.HasSuffix(format, "10") || strings.HasSuffix(format, "12")
 }
 
 // Subtitle represents a subtitle track
 type Subtitle struct {
-	Index    int    `json:"index"`
-	Language string `json:"language"`
-	Title    string `json:"title,omitempty"`
-	FilePath string `json:"file_path,omitempty"` // For external SRT files
-	IsExternal bool `json:"is_external"`
-	Format   string `json:"format"` // srt, ass, subrip, etc.
+	ID            string `json:"id"` // Stable selector used by the stream and cast APIs
+	Index         int    `json:"index"`
+	Language      string `json:"language"`
+	Title         string `json:"title,omitempty"`
+	FilePath      string `json:"file_path,omitempty"` // For external SRT files
+	IsExternal    bool   `json:"is_external"`
+	Format        string `json:"format"`                   // srt, ass, subrip, etc.
+	Charset       string `json:"charset,omitempty"`        // Detected or overridden charset of external files
+	CharsetManual bool   `json:"charset_manual,omitempty"` // Charset was set by hand and survives rescans
+	Forced        bool   `json:"forced,omitempty"`         // Only translates foreign-language dialogue
+	Default       bool   `json:"default,omitempty"`        // Preferred track for the movie
+	Managed       bool   `json:"managed,omitempty"`        // Uploaded through the API rather than found by the scan
+}
+
+// Version is an optimized copy of a movie for a client profile
+type Version struct {
+	ID               string    `json:"id"`
+	MovieID          string    `json:"movie_id"`
+	Profile          string    `json:"profile"`
+	FilePath         string    `json:"file_path"`
+	FileSize         int64     `json:"file_size"`
+	Container        string    `json:"container"`
+	VideoCodec       string    `json:"video_codec"`
+	AudioCodec       string    `json:"audio_codec"`
+	SourceModifiedAt time.Time `json:"source_modified_at"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// CurrentVersions returns the versions made from the current source file
+func (m *Movie) CurrentVersions() []Version {
+	var current []Version
+	for _, v := range m.Versions {
+		if v.SourceModifiedAt.Equal(m.ModifiedAt) {
+			current = append(current, v)
+		}
+	}
+	return current
+}
+
+// IsBitmap reports whether the subtitle is image based (Blu-ray PGS, DVD
+// VobSub or DVB) rather than text
+func (s Subtitle) IsBitmap() bool {
+	switch s.Format {
+	case "hdmv_pgs_subtitle", "pgssub", "dvd_subtitle", "dvdsub", "dvb_subtitle", "dvbsub", "xsub":
+		return true
+	}
+	return false
+}
+
+// ExternalSubtitle returns the external subtitle with the given file path
+func (m *Movie) ExternalSubtitle(path string) (Subtitle, bool) {
+	for _, sub := range m.Subtitles {
+		if sub.IsExternal && sub.FilePath == path {
+			return sub, true
+		}
+	}
+	return Subtitle{}, false
+}
+
+// subtitleID derives the ID of a scanned subtitle: embedded tracks by
+// stream index, external files by path
+func subtitleID(sub Subtitle) string {
+	if !sub.IsExternal {
+		return fmt.Sprintf("stream-%d", sub.Index)
+	}
+	hash := sha256.Sum256([]byte(sub.FilePath))
+	return "file-" + hex.EncodeToString(hash[:6])
+}
+
+// FindSubtitle returns the subtitle with the given ID
+func (m *Movie) FindSubtitle(id string) (Subtitle, bool) {
+	for _, sub := range m.Subtitles {
+		if sub.ID == id {
+			return sub, true
+		}
+	}
+	return Subtitle{}, false
+}
+
+// SubtitleOrdinal returns the position of an embedded subtitle stream among
+// the file's subtitle streams, which is how ffmpeg's si= option counts
+func (m *Movie) SubtitleOrdinal(streamIndex int) int {
+	ordinal := 0
+	for _, sub := range m.Subtitles {
+		if !sub.IsExternal && sub.Index < streamIndex {
+			ordinal++
+		}
+	}
+	return ordinal
+}
+
+// ManagedSubtitle returns the managed subtitle with the given ID
+func (m *Movie) ManagedSubtitle(id string) (Subtitle, bool) {
+	for _, sub := range m.Subtitles {
+		if sub.Managed && sub.ID == id {
+			return sub, true
+		}
+	}
+	return Subtitle{}, false
+}
+
+// EmbeddedSubtitle returns the embedded subtitle with the given stream index
+func (m *Movie) EmbeddedSubtitle(index int) (Subtitle, bool) {
+	for _, sub := range m.Subtitles {
+		if !sub.IsExternal && sub.Index == index {
+			return sub, true
+		}
+	}
+	return Subtitle{}, false
 }
 
 // NeedsTranscode checks if the movie needs transcoding for a target device
@@ -65,12 +232,14 @@ func (m *Movie) GetExternalSubtitles() []Subtitle {
 
 // MovieSummary is a lightweight version for list views
 type MovieSummary struct {
-	ID            string `json:"id"`
-	Title         string `json:"title"`
-	Year          int    `json:"year,omitempty"`
-	Duration      int    `json:"duration"`
-	ThumbnailPath string `json:"thumbnail_path,omitempty"`
-	HasSubtitles  bool   `json:"has_subtitles"`
+	ID            string     `json:"id"`
+	Title         string     `json:"title"`
+	Year          int        `json:"year,omitempty"`
+	Duration      int        `json:"duration"`
+	ThumbnailPath string     `json:"thumbnail_path,omitempty"`
+	HasSubtitles  bool       `json:"has_subtitles"`
+	Status        string     `json:"status"`
+	MissingSince  *time.Time `json:"missing_since,omitempty"`
 }
 
 // ToSummary converts a Movie to MovieSummary
@@ -82,5 +251,7 @@ func (m *Movie) ToSummary() MovieSummary {
 		Duration:      m.Duration,
 		ThumbnailPath: m.ThumbnailPath,
 		HasSubtitles:  len(m.Subtitles) > 0,
+		Status:        m.Status,
+		MissingSince:  m.MissingSince,
 	}
 }
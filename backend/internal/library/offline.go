@@ -0,0 +1,286 @@
+package library
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// GetOfflineMovies returns the movies whose files are missing or whose
+// media path is unavailable
+func (l *Library) GetOfflineMovies() []*Movie {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	movies := make([]*Movie, 0, len(l.offline))
+	for _, movie := range l.offline {
+		movies = append(movies, movie)
+	}
+	return movies
+}
+
+// mediaRoot returns the configured media path containing path, or "" when
+// it lies outside all of them
+func (l *Library) mediaRoot(path string) string {
+	best := ""
+	for _, root := range l.config.MediaPaths {
+		root = filepath.Clean(root)
+		prefix := strings.TrimSuffix(root, string(filepath.Separator)) + string(filepath.Separator)
+		if (path == root || strings.HasPrefix(path, prefix)) && len(root) > len(best) {
+			best = root
+		}
+	}
+	return best
+}
+
+// rootAvailable reports whether a media path is mounted and readable. An
+// empty folder counts as unmounted, since that is what a mount point looks
+// like while its volume is away.
+func rootAvailable(root string) bool {
+	f, err := os.Open(root)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	names, _ := f.Readdirnames(1)
+	return len(names) > 0
+}
+
+// fileStatus decides whether a movie file is online, missing or
+// unavailable. roots caches rootAvailable across calls.
+func (l *Library) fileStatus(path string, roots map[string]bool) string {
+	root := l.mediaRoot(path)
+	if root == "" {
+		return StatusMissing // No longer part of the library
+	}
+
+	available, ok := roots[root]
+	if !ok {
+		available = rootAvailable(root)
+		roots[root] = available
+	}
+	if !available {
+		return StatusUnavailable
+	}
+	if fileExists(path) {
+		return StatusOnline
+	}
+	return StatusMissing
+}
+
+// setStatus records a movie's availability. since is when it went offline
+// and nil for online movies.
+func (l *Library) setStatus(id, status string, since *time.Time) error {
+	_, err := l.db.Exec(`UPDATE movies SET status = ?, missing_since = ? WHERE id = ?`, status, since, id)
+	return err
+}
+
+// updateAvailability checks every movie's file after a scan. Movies whose
+// files came back go online; the others go offline, as missing when their
+// media path is readable or unavailable when it looks unmounted. It
+// returns how many movies went offline.
+func (l *Library) updateAvailability() (int, error) {
+	rows, err := l.db.Query(`SELECT id, file_path, status FROM movies`)
+	if err != nil {
+		return 0, err
+	}
+	type entry struct{ id, path, status string }
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		var status sql.NullString
+		if err := rows.Scan(&e.id, &e.path, &status); err != nil {
+			continue
+		}
+		e.status = status.String
+		if e.status == "" {
+			e.status = StatusOnline
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+
+	roots := make(map[string]bool)
+	now := time.Now()
+	removed := 0
+	for _, e := range entries {
+		status := l.fileStatus(e.path, roots)
+		if status == e.status {
+			continue
+		}
+
+		var since *time.Time
+		if status != StatusOnline {
+			since = &now
+			log.Printf("Library: %s is %s", e.path, status)
+		}
+		if err := l.setStatus(e.id, status, since); err != nil {
+			return removed, err
+		}
+		if e.status == StatusOnline {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// takeOffline moves a movie out of the listings; callers hold l.mu
+func (l *Library) takeOffline(movie *Movie, status string, since time.Time) {
+	updated := *movie
+	updated.Status = status
+	updated.MissingSince = &since
+	delete(l.movies, movie.ID)
+	l.offline[movie.ID] = &updated
+}
+
+// PurgeMissing deletes movies that have been missing for longer than the
+// grace period. It returns how many were purged.
+func (l *Library) PurgeMissing() (int, error) {
+	l.scanMu.Lock()
+	defer l.scanMu.Unlock()
+
+	return l.purgeMissing()
+}
+
+// purgeMissing deletes expired missing movies with their thumbnails,
+// optimized versions and managed subtitles. Movies on unavailable media
+// paths are kept however long the path is away.
+func (l *Library) purgeMissing() (int, error) {
+	grace := l.config.MissingGracePeriod
+	if grace <= 0 {
+		return 0, nil
+	}
+
+	movies, err := l.queryMovies(`WHERE status = ?`, StatusMissing)
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-grace)
+	purged := 0
+	for _, movie := range movies {
+		if movie.MissingSince == nil || movie.MissingSince.After(cutoff) {
+			continue
+		}
+		if err := l.purgeMovie(movie); err != nil {
+			return purged, err
+		}
+		log.Printf("Library: purged %s, missing since %s", movie.FilePath, movie.MissingSince.Format(time.RFC3339))
+		purged++
+	}
+	return purged, nil
+}
+
+// queryMovies returns the movies matching a WHERE clause
+func (l *Library) queryMovies(where string, args ...interface{}) ([]*Movie, error) {
+	rows, err := l.db.Query(`SELECT `+movieColumns+` FROM movies `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var movies []*Movie
+	for rows.Next() {
+		movie, err := l.scanMovie(rows)
+		if err != nil {
+			continue
+		}
+		movies = append(movies, movie)
+	}
+	return movies, rows.Err()
+}
+
+// purgeMovie deletes a movie and the files that only exist for it
+func (l *Library) purgeMovie(movie *Movie) error {
+	var files []string
+	for _, query := range []string{
+		`SELECT file_path FROM movie_versions WHERE movie_id = ?`,
+		`SELECT file_path FROM movie_subtitles WHERE movie_id = ?`,
+	} {
+		rows, err := l.db.Query(query, movie.ID)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var path string
+			if rows.Scan(&path) == nil {
+				files = append(files, path)
+			}
+		}
+		rows.Close()
+	}
+
+	tx, err := l.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		`DELETE FROM movie_versions WHERE movie_id = ?`,
+		`DELETE FROM movie_subtitles WHERE movie_id = ?`,
+		`DELETE FROM movie_aliases WHERE movie_id = ?`,
+		`DELETE FROM movies WHERE id = ?`,
+	} {
+		if _, err := tx.Exec(query, movie.ID); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, path := range files {
+		os.Remove(path)
+	}
+	if movie.ThumbnailPath != "" {
+		os.Remove(movie.ThumbnailPath)
+	}
+
+	l.mu.Lock()
+	delete(l.movies, movie.ID)
+	delete(l.offline, movie.ID)
+	l.mu.Unlock()
+
+	return nil
+}
+
+// findMovedMovie returns a movie with the given fingerprint whose file is
+// missing, i.e. the same content now found at another path. Movies claimed
+// by another scan worker are skipped; callers hold l.idMu.
+func (l *Library) findMovedMovie(fingerprint string) *Movie {
+	movies, err := l.queryMovies(`WHERE fingerprint = ?`, fingerprint)
+	if err != nil {
+		return nil
+	}
+
+	roots := make(map[string]bool)
+	for _, movie := range movies {
+		if !l.claimed[movie.ID] && l.fileStatus(movie.FilePath, roots) == StatusMissing {
+			return movie
+		}
+	}
+	return nil
+}
+
+// refreshUnchanged handles a file whose metadata is current: it brings an
+// offline movie back online and fingerprints rows from older versions. It
+// reports whether the movie's availability changed.
+func (l *Library) refreshUnchanged(existing *Movie, size int64) (bool, error) {
+	if existing.Fingerprint == "" {
+		if fingerprint, err := fingerprintFile(existing.FilePath, size); err == nil {
+			if _, err := l.db.Exec(`UPDATE movies SET fingerprint = ? WHERE id = ?`, fingerprint, existing.ID); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	if existing.IsOnline() {
+		return false, nil
+	}
+	return true, l.setStatus(existing.ID, StatusOnline, nil)
+}
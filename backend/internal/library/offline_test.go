@@ -0,0 +1,183 @@
+package library
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRootAvailable(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty")
+	mounted := filepath.Join(dir, "mounted")
+	for _, d := range []string{empty, mounted} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(mounted, "movie.mkv"), []byte("movie"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		root string
+		want bool
+	}{
+		{filepath.Join(dir, "absent"), false},
+		{empty, false}, // A mount point without its volume
+		{mounted, true},
+	}
+	for _, tt := range tests {
+		if got := rootAvailable(tt.root); got != tt.want {
+			t.Errorf("rootAvailable(%s) = %v, want %v", filepath.Base(tt.root), got, tt.want)
+		}
+	}
+}
+
+func TestPurgeMissingWaitsForGracePeriod(t *testing.T) {
+	lib, media := newTestLibrary(t)
+	lib.config.MissingGracePeriod = 7 * 24 * time.Hour
+	paths := map[string]string{}
+	for _, name := range []string{"expired", "recent", "present"} {
+		paths[name] = filepath.Join(media, name+".mkv")
+		if err := os.WriteFile(paths[name], []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := lib.Scan(context.Background(), nil); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	ids := map[string]string{}
+	for name, path := range paths {
+		movie, err := lib.getMovieByPath(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[name] = movie.ID
+	}
+
+	thumbnail := filepath.Join(t.TempDir(), "expired.jpg")
+	if err := os.WriteFile(thumbnail, []byte("jpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lib.db.Exec(`UPDATE movies SET thumbnail_path = ? WHERE id = ?`, thumbnail, ids["expired"]); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Remove(paths["expired"])
+	os.Remove(paths["recent"])
+	if err := lib.Scan(context.Background(), nil); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if n := len(lib.GetOfflineMovies()); n != 2 {
+		t.Fatalf("%d movies offline after the scan, want 2", n)
+	}
+
+	expired := time.Now().Add(-8 * 24 * time.Hour)
+	recent := time.Now().Add(-24 * time.Hour)
+	for id, since := range map[string]time.Time{ids["expired"]: expired, ids["recent"]: recent} {
+		if err := lib.setStatus(id, StatusMissing, &since); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := lib.PurgeMissing()
+	if err != nil || n != 1 {
+		t.Fatalf("PurgeMissing = %d, %v, want 1 purged", n, err)
+	}
+	if _, err := lib.getMovieFromDB(ids["expired"]); err == nil {
+		t.Error("expired movie still stored")
+	}
+	if _, err := os.Stat(thumbnail); !os.IsNotExist(err) {
+		t.Error("thumbnail of the purged movie kept")
+	}
+	for _, name := range []string{"recent", "present"} {
+		if _, err := lib.getMovieFromDB(ids[name]); err != nil {
+			t.Errorf("%s movie purged: %v", name, err)
+		}
+	}
+
+	// Without a grace period nothing is ever purged
+	lib.config.MissingGracePeriod = 0
+	longAgo := time.Now().Add(-365 * 24 * time.Hour)
+	if err := lib.setStatus(ids["recent"], StatusMissing, &longAgo); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := lib.PurgeMissing(); err != nil || n != 0 {
+		t.Errorf("PurgeMissing with no grace period = %d, %v, want none", n, err)
+	}
+}
+
+func TestPurgeMissingKeepsUnavailableRoots(t *testing.T) {
+	lib, media := newTestLibrary(t)
+	lib.config.MissingGracePeriod = time.Hour
+	path := filepath.Join(media, "movie.mkv")
+	if err := os.WriteFile(path, []byte("movie"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := lib.Scan(context.Background(), nil); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+
+	// The drive is unplugged, leaving an empty mount point
+	os.Remove(path)
+	if err := lib.Scan(context.Background(), nil); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	offline := lib.GetOfflineMovies()
+	if len(offline) != 1 || offline[0].Status != StatusUnavailable {
+		t.Fatalf("offline movies = %+v, want one unavailable", offline)
+	}
+
+	longAgo := time.Now().Add(-365 * 24 * time.Hour)
+	if err := lib.setStatus(offline[0].ID, StatusUnavailable, &longAgo); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := lib.PurgeMissing(); err != nil || n != 0 {
+		t.Errorf("PurgeMissing = %d, %v, want movies on unavailable roots kept", n, err)
+	}
+}
+
+func TestFindMovedMovie(t *testing.T) {
+	lib, media := newTestLibrary(t)
+	paths := map[string]string{}
+	for _, name := range []string{"moved", "present"} {
+		paths[name] = filepath.Join(media, name+".mkv")
+		if err := os.WriteFile(paths[name], []byte("same content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := lib.Scan(context.Background(), nil); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	moved, err := lib.getMovieByPath(paths["moved"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lib.idMu.Lock()
+	defer lib.idMu.Unlock()
+
+	// Both copies are where they were
+	if movie := lib.findMovedMovie(moved.Fingerprint); movie != nil {
+		t.Errorf("findMovedMovie = %s, want none while every file exists", movie.FilePath)
+	}
+
+	if err := os.Remove(paths["moved"]); err != nil {
+		t.Fatal(err)
+	}
+	if movie := lib.findMovedMovie(moved.Fingerprint); movie == nil || movie.ID != moved.ID {
+		t.Errorf("findMovedMovie = %v, want the movie whose file is missing", movie)
+	}
+	if movie := lib.findMovedMovie("unknown"); movie != nil {
+		t.Errorf("findMovedMovie(unknown) = %s, want none", movie.FilePath)
+	}
+
+	// Another worker already took it
+	lib.claimed[moved.ID] = true
+	if movie := lib.findMovedMovie(moved.Fingerprint); movie != nil {
+		t.Errorf("findMovedMovie = %s, want claimed movies skipped", movie.FilePath)
+	}
+}
@@ -0,0 +1,220 @@
+package library
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ScanError is a file or folder a scan couldn't read, and why
+type ScanError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// ScanProgress is a snapshot of how far a scan has got
+type ScanProgress struct {
+	Found     int         `json:"found"`     // Video files discovered
+	Processed int         `json:"processed"` // Files probed or skipped as unchanged
+	Failed    int         `json:"failed"`    // Files that couldn't be probed
+	Added     int         `json:"added"`     // Movies new to the library
+	Updated   int         `json:"updated"`   // Movies re-probed, moved or back online
+	Removed   int         `json:"removed"`   // Movies that went offline
+	Purged    int         `json:"purged"`    // Movies deleted after the grace period
+	Current   []string    `json:"current"`   // Files being probed right now
+	Errors    []ScanError `json:"errors"`    // Why files failed or folders were skipped
+}
+
+// fileChange is what processing one file did to the library
+type fileChange int
+
+const (
+	fileUnchanged fileChange = iota
+	fileAdded
+	fileUpdated
+)
+
+// scanTracker collects progress from the scan workers and passes snapshots on
+type scanTracker struct {
+	mu       sync.Mutex
+	progress ScanProgress
+	report   func(ScanProgress)
+}
+
+// update changes the progress and reports a snapshot
+func (t *scanTracker) update(change func(p *ScanProgress)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	change(&t.progress)
+	if t.report != nil {
+		snapshot := t.progress
+		snapshot.Current = append([]string(nil), t.progress.Current...)
+		snapshot.Errors = t.progress.Errors[:len(t.progress.Errors):len(t.progress.Errors)] // Only ever appended to
+		t.report(snapshot)
+	}
+}
+
+// Scan scans the media directories for movies. The video files are found
+// first, then probed by a pool of ScanWorkers. Each movie is committed as
+// soon as it is done, so readers keep working and watch the library fill
+// in. Once every file is handled, movies whose files are gone go offline
+// and those missing past the grace period are purged.
+//
+// progress, if not nil, receives a snapshot after every change. Cancelling
+// ctx stops the scan; movies committed so far are kept.
+func (l *Library) Scan(ctx context.Context, progress func(ScanProgress)) error {
+	l.scanMu.Lock()
+	defer l.scanMu.Unlock()
+
+	tracker := &scanTracker{report: progress}
+
+	files, err := l.discoverFiles(ctx, tracker)
+	if err != nil {
+		return err
+	}
+
+	workers := l.config.ScanWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range queue {
+				l.scanFile(ctx, path, tracker)
+			}
+		}()
+	}
+
+feed:
+	for _, path := range files {
+		select {
+		case queue <- path:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	removed, err := l.updateAvailability()
+	if err != nil {
+		return err
+	}
+	tracker.update(func(p *ScanProgress) { p.Removed = removed })
+
+	purged, err := l.purgeMissing()
+	tracker.update(func(p *ScanProgress) { p.Purged = purged })
+	if err != nil {
+		return err
+	}
+
+	// The scan fingerprinted files that still had path-based IDs
+	if err := l.migrateLegacyIDs(); err != nil {
+		return err
+	}
+
+	return l.loadFromDB()
+}
+
+// discoverFiles walks the media directories for video files
+func (l *Library) discoverFiles(ctx context.Context, tracker *scanTracker) ([]string, error) {
+	var files []string
+	for _, mediaPath := range l.config.MediaPaths {
+		err := filepath.Walk(mediaPath, func(path string, info os.FileInfo, err error) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err != nil {
+				// Skip files we can't access, but say so
+				tracker.update(func(p *ScanProgress) {
+					p.Errors = append(p.Errors, ScanError{Path: path, Error: err.Error()})
+				})
+				return nil
+			}
+			if info.IsDir() || !l.IsMediaFile(path) {
+				return nil
+			}
+
+			files = append(files, path)
+			tracker.update(func(p *ScanProgress) { p.Found++ })
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// scanFile probes one file for Scan and commits the movie if it changed
+func (l *Library) scanFile(ctx context.Context, path string, tracker *scanTracker) {
+	tracker.update(func(p *ScanProgress) { p.Current = append(p.Current, path) })
+
+	change, err := l.probeAndCommit(ctx, path)
+
+	tracker.update(func(p *ScanProgress) {
+		for i, current := range p.Current {
+			if current == path {
+				p.Current = append(p.Current[:i], p.Current[i+1:]...)
+				break
+			}
+		}
+		switch {
+		case ctx.Err() != nil:
+			// Cancelled, not failed
+		case err != nil:
+			p.Failed++
+			p.Errors = append(p.Errors, ScanError{Path: path, Error: err.Error()})
+		default:
+			p.Processed++
+			switch change {
+			case fileAdded:
+				p.Added++
+			case fileUpdated:
+				p.Updated++
+			}
+		}
+	})
+}
+
+// probeAndCommit processes a file and swaps the movie into memory if it
+// was saved. A movie that kept the ID of one already known, because its
+// file changed, moved or came back, counts as updated rather than added.
+func (l *Library) probeAndCommit(ctx context.Context, path string) (fileChange, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileUnchanged, err
+	}
+
+	saved, err := l.processVideoFile(ctx, path, info)
+	if err != nil || !saved {
+		return fileUnchanged, err
+	}
+
+	movie, err := l.getMovieByPath(path)
+	if err != nil {
+		return fileUnchanged, err
+	}
+
+	l.mu.RLock()
+	_, online := l.movies[movie.ID]
+	_, offline := l.offline[movie.ID]
+	l.mu.RUnlock()
+
+	change := fileAdded
+	if online || offline {
+		change = fileUpdated
+	}
+	return change, l.reloadMovie(movie.ID)
+}
@@ -0,0 +1,123 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wysentanu/dlna-movie-cast/internal/config"
+)
+
+// fakeFFprobe is a shell script standing in for ffprobe
+const fakeFFprobe = `#!/bin/sh
+echo '{"format":{"duration":"60","size":"10"},"streams":[{"codec_type":"video","codec_name":"h264","width":640,"height":360,"index":0}]}'
+`
+
+// newTestLibrary opens a library over a temporary media folder
+func newTestLibrary(t *testing.T) (*Library, string) {
+	t.Helper()
+	dir := t.TempDir()
+	media := filepath.Join(dir, "media")
+	if err := os.MkdirAll(media, 0755); err != nil {
+		t.Fatal(err)
+	}
+	ffprobe := filepath.Join(dir, "ffprobe")
+	if err := os.WriteFile(ffprobe, []byte(fakeFFprobe), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.MediaPaths = []string{media}
+	cfg.DBPath = ":memory:"
+	cfg.ThumbnailDir = ""
+	cfg.FFprobePath = ffprobe
+	cfg.ScanWorkers = 8
+
+	lib, err := NewLibrary(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lib.Close() })
+	return lib, media
+}
+
+func TestScanGivesIdenticalCopiesDistinctIDs(t *testing.T) {
+	lib, media := newTestLibrary(t)
+	const copies = 8
+	for i := 0; i < copies; i++ {
+		name := filepath.Join(media, fmt.Sprintf("copy%d.mkv", i))
+		if err := os.WriteFile(name, []byte("same content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var progress ScanProgress
+	if err := lib.Scan(context.Background(), func(p ScanProgress) { progress = p }); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+
+	if progress.Added != copies || progress.Failed != 0 {
+		t.Errorf("progress = %+v, want %d added and none failed", progress, copies)
+	}
+	ids := make(map[string]string)
+	for _, movie := range lib.GetAllMovies() {
+		if other, ok := ids[movie.ID]; ok {
+			t.Errorf("%s and %s share ID %s", other, movie.FilePath, movie.ID)
+		}
+		ids[movie.ID] = movie.FilePath
+	}
+	if len(ids) != copies {
+		t.Errorf("library has %d movies, want %d", len(ids), copies)
+	}
+}
+
+func TestScanKeepsIDOfMovedMovie(t *testing.T) {
+	lib, media := newTestLibrary(t)
+	old := filepath.Join(media, "old.mkv")
+	if err := os.WriteFile(old, []byte("moved content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := lib.Scan(context.Background(), nil); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	before, err := lib.getMovieByPath(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Rename(old, filepath.Join(media, "new.mkv")); err != nil {
+		t.Fatal(err)
+	}
+	var progress ScanProgress
+	if err := lib.Scan(context.Background(), func(p ScanProgress) { progress = p }); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+
+	movies := lib.GetAllMovies()
+	if len(movies) != 1 || movies[0].ID != before.ID {
+		t.Fatalf("movies after move = %d, want one with ID %s", len(movies), before.ID)
+	}
+	if progress.Updated != 1 || progress.Added != 0 {
+		t.Errorf("progress = %+v, want 1 updated and none added", progress)
+	}
+}
+
+func TestScanSkipsOptimizedVersions(t *testing.T) {
+	lib, media := newTestLibrary(t)
+	original := filepath.Join(media, "Movie.mkv")
+	for _, path := range []string{original, OptimizedFileName(original, "samsung", ".mp4")} {
+		if err := os.WriteFile(path, []byte(path), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := lib.Scan(context.Background(), nil); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	movies := lib.GetAllMovies()
+	if len(movies) != 1 || movies[0].FilePath != original {
+		t.Errorf("library has %d movies, want only %s", len(movies), original)
+	}
+}
@@ -0,0 +1,178 @@
+package library
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OptimizedMarker sits between the original name and the profile in
+// optimized versions written next to the original, e.g.
+// "Movie.optimized-samsung.mp4"
+const OptimizedMarker = ".optimized-"
+
+// OptimizedFileName returns the name of an optimized version written next
+// to the original
+func OptimizedFileName(original, profile, ext string) string {
+	return strings.TrimSuffix(original, filepath.Ext(original)) + OptimizedMarker + profile + ext
+}
+
+// isOptimizedFile reports whether a file is named like an optimized
+// version. Unlike isVersionFile it also matches files still being encoded.
+func isOptimizedFile(path string) bool {
+	name := filepath.Base(path)
+	return strings.Contains(strings.TrimSuffix(name, filepath.Ext(name)), OptimizedMarker)
+}
+
+// initVersionsDB creates the table of optimized versions
+func (l *Library) initVersionsDB() error {
+	_, err := l.db.Exec(`
+	CREATE TABLE IF NOT EXISTS movie_versions (
+		id TEXT PRIMARY KEY,
+		movie_id TEXT NOT NULL,
+		profile TEXT NOT NULL,
+		file_path TEXT UNIQUE NOT NULL,
+		file_size INTEGER,
+		container TEXT,
+		video_codec TEXT,
+		audio_codec TEXT,
+		source_modified_at DATETIME,
+		created_at DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS idx_movie_versions_movie ON movie_versions(movie_id);
+	`)
+	return err
+}
+
+// loadVersions attaches versions to the given movies
+func (l *Library) loadVersions(movies map[string]*Movie) error {
+	rows, err := l.db.Query(`
+		SELECT id, movie_id, profile, file_path, file_size, container,
+			video_codec, audio_codec, source_modified_at, created_at
+		FROM movie_versions ORDER BY created_at
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v Version
+		err := rows.Scan(
+			&v.ID, &v.MovieID, &v.Profile, &v.FilePath, &v.FileSize, &v.Container,
+			&v.VideoCodec, &v.AudioCodec, &v.SourceModifiedAt, &v.CreatedAt,
+		)
+		if err != nil {
+			continue
+		}
+		if movie, ok := movies[v.MovieID]; ok {
+			movie.Versions = append(movie.Versions, v)
+		}
+	}
+	return rows.Err()
+}
+
+// isVersionFile reports whether a path is an optimized version, so scans
+// don't index it as a movie of its own
+func (l *Library) isVersionFile(path string) bool {
+	var n int
+	err := l.db.QueryRow(`SELECT COUNT(*) FROM movie_versions WHERE file_path = ?`, path).Scan(&n)
+	return err == nil && n > 0
+}
+
+// AddVersion links an optimized file to its movie. An existing version for
+// the same profile is replaced and its file removed.
+func (l *Library) AddVersion(v Version) (*Version, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	movie, ok := l.lookup(v.MovieID)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrMovieNotFound, v.MovieID)
+	}
+	v.MovieID = movie.ID
+
+	v.ID = uuid.New().String()
+	v.CreatedAt = time.Now()
+
+	tx, err := l.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM movie_versions WHERE movie_id = ? AND (profile = ? OR file_path = ?)`,
+		v.MovieID, v.Profile, v.FilePath); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO movie_versions (
+			id, movie_id, profile, file_path, file_size, container,
+			video_codec, audio_codec, source_modified_at, created_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		v.ID, v.MovieID, v.Profile, v.FilePath, v.FileSize, v.Container,
+		v.VideoCodec, v.AudioCodec, v.SourceModifiedAt, v.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	// Movies are shared with readers, so swap in an updated copy
+	updated := *movie
+	updated.Versions = nil
+	for _, old := range movie.Versions {
+		if old.Profile == v.Profile || old.FilePath == v.FilePath {
+			if old.FilePath != v.FilePath {
+				os.Remove(old.FilePath)
+			}
+			continue
+		}
+		updated.Versions = append(updated.Versions, old)
+	}
+	updated.Versions = append(updated.Versions, v)
+	l.movies[v.MovieID] = &updated
+
+	return &v, nil
+}
+
+// RemoveVersion unlinks an optimized version and deletes its file
+func (l *Library) RemoveVersion(movieID, versionID string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	movie, ok := l.lookup(movieID)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrMovieNotFound, movieID)
+	}
+	movieID = movie.ID
+
+	updated := *movie
+	updated.Versions = nil
+	var removed *Version
+	for i, v := range movie.Versions {
+		if v.ID == versionID {
+			removed = &movie.Versions[i]
+			continue
+		}
+		updated.Versions = append(updated.Versions, v)
+	}
+	if removed == nil {
+		return fmt.Errorf("%w: %s", ErrVersionNotFound, versionID)
+	}
+
+	if _, err := l.db.Exec(`DELETE FROM movie_versions WHERE id = ?`, versionID); err != nil {
+		return err
+	}
+	os.Remove(removed.FilePath)
+	l.movies[movieID] = &updated
+
+	return nil
+}
@@ -0,0 +1,498 @@
+package optimizer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wysentanu/dlna-movie-cast/internal/config"
+	"github.com/wysentanu/dlna-movie-cast/internal/library"
+	"github.com/wysentanu/dlna-movie-cast/internal/transcoder"
+)
+
+// requeueDelay is how long a job waits after losing its slot to playback
+const requeueDelay = time.Minute
+
+// JobStatus is the state of an optimize job
+type JobStatus string
+
+const (
+	StatusQueued    JobStatus = "queued"
+	StatusRunning   JobStatus = "running"
+	StatusCompleted JobStatus = "completed"
+	StatusFailed    JobStatus = "failed"
+	StatusCancelled JobStatus = "cancelled"
+)
+
+// Job converts one movie into an optimized version for a client profile
+type Job struct {
+	ID          string     `json:"id"`
+	MovieID     string     `json:"movie_id"`
+	Title       string     `json:"title"`
+	Profile     string     `json:"profile"`
+	Destination string     `json:"destination"` // "managed" or "alongside"
+	Status      JobStatus  `json:"status"`
+	Progress    float64    `json:"progress"` // Percent of the movie encoded
+	Speed       float64    `json:"speed"`
+	Attempts    int        `json:"attempts"`
+	Requeues    int        `json:"requeues"` // Times playback preempted the job
+	Error       string     `json:"error,omitempty"`
+	OutputPath  string     `json:"output_path,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+
+	duration  int
+	notBefore time.Time
+	progress  *transcoder.ProgressTracker
+	ctx       context.Context
+	cancel    context.CancelFunc
+	cancelled bool
+}
+
+// Filter selects movies for a batch of jobs. Empty fields match everything.
+type Filter struct {
+	VideoCodec   string `json:"video_codec,omitempty"`
+	AudioCodec   string `json:"audio_codec,omitempty"`
+	Incompatible bool   `json:"incompatible,omitempty"` // Only movies the profile can't play natively
+}
+
+// Request asks for movies to be optimized for a profile
+type Request struct {
+	MovieIDs    []string `json:"movie_ids,omitempty"`
+	Filter      *Filter  `json:"filter,omitempty"`
+	Profile     string   `json:"profile"`
+	Destination string   `json:"destination,omitempty"` // Defaults to the configured destination
+}
+
+// Manager runs optimize jobs one at a time at background priority, so
+// interactive playback always wins the transcode slot
+type Manager struct {
+	config     *config.Config
+	library    *library.Library
+	transcoder *transcoder.Transcoder
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+	wake chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewManager creates a manager and starts its worker
+func NewManager(cfg *config.Config, lib *library.Library, t *transcoder.Transcoder) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Manager{
+		config:     cfg,
+		library:    lib,
+		transcoder: t,
+		jobs:       make(map[string]*Job),
+		wake:       make(chan struct{}, 1),
+		ctx:        ctx,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+	go m.worker()
+	return m
+}
+
+// Stop cancels the running job and waits for the worker to exit
+func (m *Manager) Stop() {
+	m.cancel()
+	<-m.done
+}
+
+// Submit creates jobs for the movies selected by a request. Movies that
+// already have a queued or running job for the profile are skipped.
+func (m *Manager) Submit(req Request) ([]Job, error) {
+	profile := transcoder.GetClientProfile(req.Profile)
+	if req.Profile != "" && !strings.EqualFold(profile.Name, req.Profile) {
+		return nil, fmt.Errorf("unknown profile: %s", req.Profile)
+	}
+
+	destination := req.Destination
+	if destination == "" {
+		destination = m.config.OptimizeDestination
+	}
+	if destination != "managed" && destination != "alongside" {
+		return nil, fmt.Errorf("unknown destination: %s", destination)
+	}
+
+	movies, err := m.selectMovies(req, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var created []Job
+	for _, movie := range movies {
+		if m.hasActiveJob(movie.ID, profile.Name) {
+			continue
+		}
+		job := &Job{
+			ID:          uuid.New().String(),
+			MovieID:     movie.ID,
+			Title:       movie.Title,
+			Profile:     profile.Name,
+			Destination: destination,
+			Status:      StatusQueued,
+			CreatedAt:   time.Now(),
+			duration:    movie.Duration,
+		}
+		m.jobs[job.ID] = job
+		created = append(created, m.snapshot(job))
+	}
+
+	m.notify()
+	return created, nil
+}
+
+// selectMovies resolves explicit IDs or a filter into movies
+func (m *Manager) selectMovies(req Request, profile transcoder.ClientProfile) ([]*library.Movie, error) {
+	if len(req.MovieIDs) > 0 {
+		movies := make([]*library.Movie, 0, len(req.MovieIDs))
+		for _, id := range req.MovieIDs {
+			movie, err := m.library.GetMovie(id)
+			if err != nil {
+				return nil, err
+			}
+			movies = append(movies, movie)
+		}
+		return movies, nil
+	}
+
+	if req.Filter == nil {
+		return nil, fmt.Errorf("either movie_ids or filter is required")
+	}
+
+	var movies []*library.Movie
+	for _, movie := range m.library.GetAllMovies() {
+		f := req.Filter
+		if f.VideoCodec != "" && !strings.EqualFold(movie.VideoCodec, f.VideoCodec) {
+			continue
+		}
+		if f.AudioCodec != "" && !strings.EqualFold(movie.AudioCodec, f.AudioCodec) {
+			continue
+		}
+		if f.Incompatible && profile.CanPlay(movie.VideoCodec, movie.AudioCodec) {
+			continue
+		}
+		movies = append(movies, movie)
+	}
+	return movies, nil
+}
+
+// hasActiveJob reports whether a movie is already queued or running for a profile; callers hold m.mu
+func (m *Manager) hasActiveJob(movieID, profile string) bool {
+	for _, job := range m.jobs {
+		if job.MovieID == movieID && job.Profile == profile &&
+			(job.Status == StatusQueued || job.Status == StatusRunning) {
+			return true
+		}
+	}
+	return false
+}
+
+// Jobs returns all jobs, newest first
+func (m *Manager) Jobs() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := make([]Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, m.snapshot(job))
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	return jobs
+}
+
+// GetJob returns one job
+func (m *Manager) GetJob(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, fmt.Errorf("job not found: %s", id)
+	}
+	return m.snapshot(job), nil
+}
+
+// Cancel stops a queued or running job
+func (m *Manager) Cancel(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, fmt.Errorf("job not found: %s", id)
+	}
+
+	switch job.Status {
+	case StatusQueued:
+		job.Status = StatusCancelled
+		now := time.Now()
+		job.FinishedAt = &now
+	case StatusRunning:
+		// The worker records the outcome once ffmpeg exits
+		job.cancelled = true
+		job.cancel()
+	default:
+		return Job{}, fmt.Errorf("job is already %s", job.Status)
+	}
+	return m.snapshot(job), nil
+}
+
+// Retry queues a failed or cancelled job again
+func (m *Manager) Retry(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, fmt.Errorf("job not found: %s", id)
+	}
+	if job.Status != StatusFailed && job.Status != StatusCancelled {
+		return Job{}, fmt.Errorf("job is %s", job.Status)
+	}
+	if m.hasActiveJob(job.MovieID, job.Profile) {
+		return Job{}, fmt.Errorf("movie already has an active job for profile %s", job.Profile)
+	}
+
+	job.Status = StatusQueued
+	job.Error = ""
+	job.Progress = 0
+	job.cancelled = false
+	job.notBefore = time.Time{}
+	job.StartedAt = nil
+	job.FinishedAt = nil
+
+	m.notify()
+	return m.snapshot(job), nil
+}
+
+// snapshot copies a job and fills in live progress; callers hold m.mu
+func (m *Manager) snapshot(job *Job) Job {
+	s := *job
+	if job.Status == StatusRunning && job.progress != nil {
+		stats := job.progress.Stats()
+		s.Speed = stats.Speed
+		if job.duration > 0 {
+			s.Progress = stats.OutTime / float64(job.duration) * 100
+			if s.Progress > 100 {
+				s.Progress = 100
+			}
+		}
+	}
+	return s
+}
+
+// notify wakes the worker; callers hold m.mu
+func (m *Manager) notify() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// worker runs queued jobs in creation order
+func (m *Manager) worker() {
+	defer close(m.done)
+
+	for {
+		job, wait := m.nextJob()
+		if job != nil {
+			m.run(job)
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-m.ctx.Done():
+			timer.Stop()
+			return
+		case <-m.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// nextJob claims the oldest runnable job. If none is ready it returns how
+// long to sleep before checking again.
+func (m *Manager) nextJob() (*Job, time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.ctx.Err() != nil {
+		return nil, time.Hour
+	}
+
+	now := time.Now()
+	wait := time.Hour
+	var next *Job
+	for _, job := range m.jobs {
+		if job.Status != StatusQueued {
+			continue
+		}
+		if job.notBefore.After(now) {
+			if d := job.notBefore.Sub(now); d < wait {
+				wait = d
+			}
+			continue
+		}
+		if next == nil || job.CreatedAt.Before(next.CreatedAt) {
+			next = job
+		}
+	}
+	if next == nil {
+		return nil, wait
+	}
+
+	next.ctx, next.cancel = context.WithCancel(m.ctx)
+	next.Status = StatusRunning
+	next.Attempts++
+	next.progress = transcoder.NewProgressTracker()
+	started := time.Now()
+	next.StartedAt = &started
+	return next, 0
+}
+
+// run executes one job and records the outcome
+func (m *Manager) run(job *Job) {
+	m.mu.Lock()
+	ctx, cancel, progress := job.ctx, job.cancel, job.progress
+	m.mu.Unlock()
+	defer cancel()
+
+	version, err := m.optimize(ctx, job, progress)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	switch {
+	case err == nil:
+		job.Status = StatusCompleted
+		job.Progress = 100
+		job.OutputPath = version.FilePath
+		job.FinishedAt = &now
+		log.Printf("Optimize: %q is ready for %s", job.Title, job.Profile)
+	case job.cancelled:
+		job.Status = StatusCancelled
+		job.FinishedAt = &now
+	case m.ctx.Err() != nil:
+		// Shutting down; the job is lost with the rest of the queue
+		job.Status = StatusCancelled
+		job.FinishedAt = &now
+	case errors.Is(err, transcoder.ErrPreempted) || errors.Is(err, transcoder.ErrSchedulerSaturated):
+		// Playback needed the slot; try again later without counting a failure
+		job.Status = StatusQueued
+		job.Attempts--
+		job.Requeues++
+		job.StartedAt = nil
+		job.notBefore = now.Add(requeueDelay)
+		log.Printf("Optimize: %q yielded to playback, retrying in %v", job.Title, requeueDelay)
+	default:
+		job.Status = StatusFailed
+		job.Error = err.Error()
+		job.FinishedAt = &now
+		log.Printf("Optimize: %q failed: %v", job.Title, err)
+	}
+	job.progress = nil
+	job.ctx = nil
+	job.cancel = nil
+}
+
+// optimize transcodes a movie to a temporary file, then moves it into place
+// and links it to the movie
+func (m *Manager) optimize(ctx context.Context, job *Job, progress *transcoder.ProgressTracker) (*library.Version, error) {
+	movie, err := m.library.GetMovie(job.MovieID)
+	if err != nil {
+		return nil, err
+	}
+	profile := transcoder.GetClientProfile(job.Profile)
+
+	opts := transcoder.DefaultOptions(m.config)
+	opts.Priority = transcoder.PriorityBackground
+	opts.Format = profile.Container
+	opts.CopyVideo = profile.CanPlay(movie.VideoCodec, "")
+	m.transcoder.SelectAudio(movie, profile, &opts)
+
+	outputPath, err := m.outputPath(movie, job, profile)
+	if err != nil {
+		return nil, err
+	}
+	tmpPath := outputPath + ".part"
+	opts.OutputPath = tmpPath
+
+	if err := m.transcoder.TranscodeToFile(ctx, movie, opts, progress); err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+
+	if err := os.Rename(tmpPath, outputPath); err != nil {
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("failed to move output into place: %w", err)
+	}
+
+	info, err := os.Stat(outputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	videoCodec := opts.VideoCodec
+	if opts.CopyVideo {
+		videoCodec = movie.VideoCodec
+	}
+	audioCodec := opts.AudioCodec
+	if audioCodec == "copy" {
+		audioCodec = movie.AudioCodec
+	}
+	version, err := m.library.AddVersion(library.Version{
+		MovieID:          movie.ID,
+		Profile:          profile.Name,
+		FilePath:         outputPath,
+		FileSize:         info.Size(),
+		Container:        opts.Format,
+		VideoCodec:       videoCodec,
+		AudioCodec:       audioCodec,
+		SourceModifiedAt: movie.ModifiedAt,
+	})
+	if err != nil {
+		os.Remove(outputPath)
+		return nil, err
+	}
+	return version, nil
+}
+
+// outputPath decides where an optimized file goes
+func (m *Manager) outputPath(movie *library.Movie, job *Job, profile transcoder.ClientProfile) (string, error) {
+	ext := ".mp4"
+	if profile.Container == transcoder.ContainerMPEGTS {
+		ext = ".ts"
+	}
+
+	if job.Destination == "alongside" {
+		return library.OptimizedFileName(movie.FilePath, profile.Name, ext), nil
+	}
+
+	if err := os.MkdirAll(m.config.OptimizeDir, 0755); err != nil {
+		return "", err
+	}
+	return filepath.Join(m.config.OptimizeDir, movie.ID+"-"+profile.Name+ext), nil
+}
@@ -0,0 +1,112 @@
+package subtitles
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/wysentanu/dlna-movie-cast/internal/library"
+)
+
+// HLSTimestampOffset is the MPEG-TS timestamp, in 90 kHz ticks, that the
+// transcoder starts HLS segments at: 1.4 s, ffmpeg's usual default, pinned
+// so WebVTT cues stay aligned whatever the muxer defaults become
+const HLSTimestampOffset = 126000
+
+// hlsTimestampMap aligns WebVTT cue times with the HLS segments
+var hlsTimestampMap = fmt.Sprintf("X-TIMESTAMP-MAP=MPEGTS:%d,LOCAL:00:00:00.000", HLSTimestampOffset)
+
+// URL returns the path a subtitle track is served at, by its position in
+// Movie.Subtitles
+func URL(movieID string, position int, ext string) string {
+	return fmt.Sprintf("/subtitles/%s/%d.%s", movieID, position, ext)
+}
+
+// Handler serves converted subtitles at /subtitles/{movieID}/{n}.{vtt,srt,ass}
+type Handler struct {
+	library *library.Library
+	service *Service
+}
+
+// NewHandler creates a subtitle HTTP handler
+func NewHandler(lib *library.Library, service *Service) *Handler {
+	return &Handler{library: lib, service: service}
+}
+
+// ServeHTTP handles GET /subtitles/{movieID}/{n}.{ext}. With ?hls=1, WebVTT
+// gets the timestamp map HLS players need.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 {
+		http.Error(w, "Invalid subtitle path", http.StatusBadRequest)
+		return
+	}
+
+	movie, err := h.library.GetMovie(parts[1])
+	if err != nil {
+		http.Error(w, "Movie not found", http.StatusNotFound)
+		return
+	}
+
+	ext := filepath.Ext(parts[2])
+	position, err := strconv.Atoi(strings.TrimSuffix(parts[2], ext))
+	if err != nil || position < 0 || position >= len(movie.Subtitles) {
+		http.Error(w, "Subtitle not found", http.StatusNotFound)
+		return
+	}
+	format, ok := LookupFormat(ext)
+	if !ok {
+		http.Error(w, "Unsupported subtitle format", http.StatusBadRequest)
+		return
+	}
+
+	path, err := h.service.Convert(r.Context(), movie, movie.Subtitles[position], format.Ext)
+	if err != nil {
+		if errors.Is(err, ErrBitmapSubtitle) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		log.Printf("Subtitle conversion failed for %s track %d: %v", movie.ID, position, err)
+		http.Error(w, "Subtitle conversion failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType)
+
+	if format.Ext == "vtt" && r.URL.Query().Get("hls") == "1" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			http.Error(w, "Subtitle not found", http.StatusNotFound)
+			return
+		}
+		w.Write(addTimestampMap(data))
+		return
+	}
+
+	http.ServeFile(w, r, path)
+}
+
+// addTimestampMap inserts the HLS timestamp map after the WEBVTT header line
+func addTimestampMap(data []byte) []byte {
+	header, rest, found := bytes.Cut(data, []byte("\n"))
+	if !found {
+		return append(data, []byte("\n"+hlsTimestampMap+"\n")...)
+	}
+
+	var out bytes.Buffer
+	out.Write(header)
+	out.WriteString("\n" + hlsTimestampMap + "\n")
+	out.Write(rest)
+	return out.Bytes()
+}
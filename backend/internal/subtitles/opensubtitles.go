@@ -0,0 +1,213 @@
+package subtitles
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wysentanu/dlna-movie-cast/internal/config"
+)
+
+// openSubtitlesUserAgent identifies the app, as the OpenSubtitles API requires
+const openSubtitlesUserAgent = "dlna-movie-cast v1"
+
+// OpenSubtitles is a Provider for the OpenSubtitles REST API or a
+// compatible server
+type OpenSubtitles struct {
+	baseURL *url.URL
+	apiKey  string
+	client  *http.Client
+}
+
+// NewOpenSubtitles creates a provider for cfg.OpenSubtitlesURL
+func NewOpenSubtitles(cfg *config.Config) (*OpenSubtitles, error) {
+	baseURL, err := url.Parse(strings.TrimSuffix(cfg.OpenSubtitlesURL, "/") + "/")
+	if err != nil {
+		return nil, fmt.Errorf("invalid OpenSubtitles URL: %w", err)
+	}
+	return &OpenSubtitles{
+		baseURL: baseURL,
+		apiKey:  cfg.OpenSubtitlesAPIKey,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Name returns the provider name
+func (o *OpenSubtitles) Name() string {
+	return "opensubtitles"
+}
+
+// openSubtitlesSearch is the response of GET /subtitles
+type openSubtitlesSearch struct {
+	Data []struct {
+		Attributes struct {
+			Language         string `json:"language"`
+			Release          string `json:"release"`
+			DownloadCount    int    `json:"download_count"`
+			HearingImpaired  bool   `json:"hearing_impaired"`
+			ForeignPartsOnly bool   `json:"foreign_parts_only"`
+			MovieHashMatch   bool   `json:"moviehash_match"`
+			Files            []struct {
+				FileID   int    `json:"file_id"`
+				FileName string `json:"file_name"`
+			} `json:"files"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+// Search finds subtitles by file hash, title and year. Exact hash matches
+// come first, then the most downloaded.
+func (o *OpenSubtitles) Search(ctx context.Context, query SearchQuery) ([]SearchResult, error) {
+	// The API redirects unless parameters are sorted and lowercase
+	params := url.Values{}
+	if query.Hash != "" {
+		params.Set("moviehash", query.Hash)
+	}
+	if query.Title != "" {
+		params.Set("query", strings.ToLower(query.Title))
+	}
+	if query.Year > 0 {
+		params.Set("year", strconv.Itoa(query.Year))
+	}
+	if len(query.Languages) > 0 {
+		langs := make([]string, len(query.Languages))
+		for i, l := range query.Languages {
+			langs[i] = strings.ToLower(l)
+		}
+		sort.Strings(langs)
+		params.Set("languages", strings.Join(langs, ","))
+	}
+
+	// Language lists are expected with literal commas
+	rawQuery := strings.ReplaceAll(params.Encode(), "%2C", ",")
+	req, err := o.newRequest(ctx, http.MethodGet, "subtitles?"+rawQuery, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp openSubtitlesSearch
+	if err := o.do(req, &resp); err != nil {
+		return nil, err
+	}
+
+	var results []SearchResult
+	for _, item := range resp.Data {
+		attr := item.Attributes
+		for _, file := range attr.Files {
+			results = append(results, SearchResult{
+				Provider:        o.Name(),
+				FileID:          strconv.Itoa(file.FileID),
+				FileName:        file.FileName,
+				Language:        attr.Language,
+				Release:         attr.Release,
+				Downloads:       attr.DownloadCount,
+				HashMatch:       attr.MovieHashMatch,
+				HearingImpaired: attr.HearingImpaired,
+				Forced:          attr.ForeignPartsOnly,
+			})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].HashMatch != results[j].HashMatch {
+			return results[i].HashMatch
+		}
+		return results[i].Downloads > results[j].Downloads
+	})
+	return results, nil
+}
+
+// Download requests a download link for a file and fetches it
+func (o *OpenSubtitles) Download(ctx context.Context, fileID string) (*Download, error) {
+	id, err := strconv.Atoi(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidFileID, fileID)
+	}
+
+	body, _ := json.Marshal(map[string]int{"file_id": id})
+	req, err := o.newRequest(ctx, http.MethodPost, "download", body)
+	if err != nil {
+		return nil, err
+	}
+
+	var link struct {
+		Link     string `json:"link"`
+		FileName string `json:"file_name"`
+	}
+	if err := o.do(req, &link); err != nil {
+		return nil, err
+	}
+
+	// Links are absolute on the real service, but may be relative on others
+	target, err := o.baseURL.Parse(link.Link)
+	if err != nil || link.Link == "" {
+		return nil, fmt.Errorf("%w: opensubtitles: invalid download link %q", ErrProviderFailed, link.Link)
+	}
+
+	fileReq, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	fileReq.Header.Set("User-Agent", openSubtitlesUserAgent)
+
+	resp, err := o.client.Do(fileReq)
+	if err != nil {
+		return nil, fmt.Errorf("%w: opensubtitles: download failed: %w", ErrProviderFailed, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: opensubtitles: download failed: %s", ErrProviderFailed, resp.Status)
+	}
+
+	return &Download{FileName: link.FileName, Body: resp.Body}, nil
+}
+
+// newRequest builds an authenticated API request for a path under the base URL
+func (o *OpenSubtitles) newRequest(ctx context.Context, method, path string, body []byte) (*http.Request, error) {
+	target, err := o.baseURL.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Api-Key", o.apiKey)
+	req.Header.Set("User-Agent", openSubtitlesUserAgent)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// do sends a request and decodes a JSON response
+func (o *OpenSubtitles) do(req *http.Request, v interface{}) error {
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: opensubtitles: %w", ErrProviderFailed, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%w: opensubtitles: %s: %s", ErrProviderFailed, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("%w: opensubtitles: invalid response: %w", ErrProviderFailed, err)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package subtitles
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/wysentanu/dlna-movie-cast/internal/config"
+)
+
+// newTestOpenSubtitles starts a fake API under /api/v1 and returns a
+// provider pointed at it
+func newTestOpenSubtitles(t *testing.T, mux *http.ServeMux) *OpenSubtitles {
+	t.Helper()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	cfg := config.DefaultConfig()
+	cfg.OpenSubtitlesURL = server.URL + "/api/v1"
+	cfg.OpenSubtitlesAPIKey = "test-key"
+	o, err := NewOpenSubtitles(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestOpenSubtitlesSearch(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/subtitles", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Api-Key"); got != "test-key" {
+			t.Errorf("Api-Key = %q, want test-key", got)
+		}
+		// Sorted, lowercase parameters with literal commas
+		if got, want := r.URL.RawQuery, "languages=en,pt-br&moviehash=0123456789abcdef&query=the+matrix&year=1999"; got != want {
+			t.Errorf("query = %q, want %q", got, want)
+		}
+		io.WriteString(w, `{"data": [
+			{"attributes": {"language": "en", "release": "Popular", "download_count": 900,
+				"files": [{"file_id": 1, "file_name": "popular.srt"}]}},
+			{"attributes": {"language": "en", "release": "Exact", "download_count": 10, "moviehash_match": true,
+				"files": [{"file_id": 2, "file_name": "exact.srt"}]}},
+			{"attributes": {"language": "pt-BR", "release": "Middle", "download_count": 500, "hearing_impaired": true,
+				"foreign_parts_only": true, "files": [{"file_id": 3, "file_name": "middle.srt"}]}}
+		]}`)
+	})
+	o := newTestOpenSubtitles(t, mux)
+
+	results, err := o.Search(context.Background(), SearchQuery{
+		Hash:      "0123456789abcdef",
+		Title:     "The Matrix",
+		Year:      1999,
+		Languages: []string{"pt-BR", "en"},
+	})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+
+	var order []string
+	for _, r := range results {
+		order = append(order, r.FileID)
+	}
+	if len(order) != 3 || order[0] != "2" || order[1] != "1" || order[2] != "3" {
+		t.Fatalf("result order = %v, want hash match first, then by downloads: [2 1 3]", order)
+	}
+	if r := results[0]; !r.HashMatch || r.FileName != "exact.srt" || r.Provider != "opensubtitles" {
+		t.Errorf("first result = %+v", r)
+	}
+	if r := results[2]; !r.HearingImpaired || !r.Forced || r.Language != "pt-BR" {
+		t.Errorf("last result = %+v", r)
+	}
+}
+
+func TestOpenSubtitlesSearchError(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/subtitles", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid api key", http.StatusForbidden)
+	})
+	o := newTestOpenSubtitles(t, mux)
+
+	if _, err := o.Search(context.Background(), SearchQuery{Title: "x"}); !errors.Is(err, ErrProviderFailed) {
+		t.Errorf("Search on a 403 = %v, want ErrProviderFailed", err)
+	}
+}
+
+func TestOpenSubtitlesDownloadResolvesLinks(t *testing.T) {
+	tests := []struct {
+		name string
+		link string
+	}{
+		{"relative", "files/42.srt"},
+		{"absolute path", "/api/v1/files/42.srt"},
+		{"absolute URL", ""}, // Filled in with the server URL
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			var serverURL string
+			mux.HandleFunc("/api/v1/download", func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost {
+					t.Errorf("download method = %s, want POST", r.Method)
+				}
+				var body struct {
+					FileID int `json:"file_id"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.FileID != 42 {
+					t.Errorf("download body file_id = %d (%v), want 42", body.FileID, err)
+				}
+				link := tt.link
+				if link == "" {
+					link = serverURL + "/api/v1/files/42.srt"
+				}
+				json.NewEncoder(w).Encode(map[string]string{"link": link, "file_name": "movie.en.srt"})
+			})
+			mux.HandleFunc("/api/v1/files/42.srt", func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "1\n00:00:01,000 --> 00:00:02,000\nHello\n")
+			})
+			o := newTestOpenSubtitles(t, mux)
+			serverURL = o.baseURL.Scheme + "://" + o.baseURL.Host
+
+			d, err := o.Download(context.Background(), "42")
+			if err != nil {
+				t.Fatalf("Download: %v", err)
+			}
+			defer d.Body.Close()
+
+			data, _ := io.ReadAll(d.Body)
+			if d.FileName != "movie.en.srt" || len(data) == 0 {
+				t.Errorf("download = %q with %d bytes", d.FileName, len(data))
+			}
+		})
+	}
+}
+
+func TestOpenSubtitlesDownloadRejectsBadIDs(t *testing.T) {
+	o := newTestOpenSubtitles(t, http.NewServeMux())
+	if _, err := o.Download(context.Background(), "not-a-number"); !errors.Is(err, ErrInvalidFileID) {
+		t.Errorf("Download of a non-numeric file ID = %v, want ErrInvalidFileID", err)
+	}
+}
@@ -0,0 +1,90 @@
+package subtitles
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var (
+	// ErrInvalidFileID is returned for a file ID the provider can't have issued
+	ErrInvalidFileID = errors.New("invalid subtitle file ID")
+
+	// ErrProviderFailed wraps failed requests to a provider; the details
+	// are for the log, not for clients
+	ErrProviderFailed = errors.New("subtitle provider request failed")
+)
+
+// Provider searches an online subtitle database and downloads from it
+type Provider interface {
+	Name() string
+	Search(ctx context.Context, query SearchQuery) ([]SearchResult, error)
+	Download(ctx context.Context, fileID string) (*Download, error)
+}
+
+// SearchQuery describes the movie to find subtitles for
+type SearchQuery struct {
+	Hash      string // OSDb hash of the video file; the most precise match
+	Title     string
+	Year      int
+	Languages []string // ISO 639-1 codes; empty for any
+}
+
+// SearchResult is a subtitle file offered by a provider
+type SearchResult struct {
+	Provider        string `json:"provider"`
+	FileID          string `json:"file_id"`
+	FileName        string `json:"file_name"`
+	Language        string `json:"language"`
+	Release         string `json:"release"`
+	Downloads       int    `json:"downloads"`
+	HashMatch       bool   `json:"hash_match"` // Made for this exact file
+	HearingImpaired bool   `json:"hearing_impaired"`
+	Forced          bool   `json:"forced"`
+}
+
+// Download is a subtitle file being fetched from a provider
+type Download struct {
+	FileName string
+	Body     io.ReadCloser
+}
+
+// hashChunkSize is how much of each end of the file the OSDb hash covers
+const hashChunkSize = 64 << 10
+
+// MovieHash computes the OSDb hash of a video file: its size plus the sum
+// of the little-endian 64-bit words in its first and last 64 KiB
+func MovieHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	size := info.Size()
+
+	chunk := int64(hashChunkSize)
+	if size < chunk {
+		chunk = size
+	}
+	buf := make([]byte, chunk)
+
+	hash := uint64(size)
+	for _, offset := range []int64{0, size - chunk} {
+		if _, err := f.ReadAt(buf, offset); err != nil && err != io.EOF {
+			return "", fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		for i := 0; i+8 <= len(buf); i += 8 {
+			hash += binary.LittleEndian.Uint64(buf[i:])
+		}
+	}
+
+	return fmt.Sprintf("%016x", hash), nil
+}
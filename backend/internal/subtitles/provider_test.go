@@ -0,0 +1,73 @@
+package subtitles
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMovieHashSmallFile(t *testing.T) {
+	// Two words and three trailing bytes that don't make up a word
+	data := make([]byte, 19)
+	binary.LittleEndian.PutUint64(data[0:], 0x0102030405060708)
+	binary.LittleEndian.PutUint64(data[8:], 0x1000000000000001)
+	copy(data[16:], "abc")
+
+	path := filepath.Join(t.TempDir(), "small.mkv")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := MovieHash(path)
+	if err != nil {
+		t.Fatalf("MovieHash: %v", err)
+	}
+
+	// A file under 64 KiB is both the first and the last chunk
+	want := uint64(len(data)) + 2*(0x0102030405060708+0x1000000000000001)
+	if got != fmtHash(want) {
+		t.Errorf("MovieHash = %s, want %s", got, fmtHash(want))
+	}
+}
+
+func TestMovieHashEmptyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.mkv")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := MovieHash(path)
+	if err != nil {
+		t.Fatalf("MovieHash: %v", err)
+	}
+	if got != "0000000000000000" {
+		t.Errorf("MovieHash = %s, want 0000000000000000", got)
+	}
+}
+
+func TestMovieHashLargeFile(t *testing.T) {
+	// 128 KiB + 8: the middle word is outside both chunks
+	data := make([]byte, 2*hashChunkSize+8)
+	binary.LittleEndian.PutUint64(data[0:], 5)
+	binary.LittleEndian.PutUint64(data[hashChunkSize:], 1000) // Ignored
+	binary.LittleEndian.PutUint64(data[len(data)-8:], 7)
+
+	path := filepath.Join(t.TempDir(), "large.mkv")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := MovieHash(path)
+	if err != nil {
+		t.Fatalf("MovieHash: %v", err)
+	}
+	if want := fmtHash(uint64(len(data)) + 5 + 7); got != want {
+		t.Errorf("MovieHash = %s, want %s", got, want)
+	}
+}
+
+func fmtHash(h uint64) string {
+	return fmt.Sprintf("%016x", h)
+}
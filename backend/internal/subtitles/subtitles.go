@@ -0,0 +1,224 @@
+package subtitles
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wysentanu/dlna-movie-cast/internal/config"
+	"github.com/wysentanu/dlna-movie-cast/internal/library"
+)
+
+var (
+	// ErrUnsupportedFormat is returned for output formats we can't write
+	ErrUnsupportedFormat = errors.New("unsupported subtitle format")
+
+	// ErrBitmapSubtitle is returned when converting an image-based track,
+	// which can only be burned in
+	ErrBitmapSubtitle = errors.New("image-based subtitles can't be converted to text")
+)
+
+// Format is a text subtitle format the service can write
+type Format struct {
+	Ext         string // File extension and URL suffix
+	Codec       string // ffmpeg subtitle encoder
+	Muxer       string // ffmpeg output format
+	ContentType string
+}
+
+// formats lists the output formats by extension. SubViewer and MicroDVD
+// (.sub) are read but not written, ffmpeg has no muxer for them.
+var formats = map[string]Format{
+	"vtt": {Ext: "vtt", Codec: "webvtt", Muxer: "webvtt", ContentType: "text/vtt; charset=utf-8"},
+	"srt": {Ext: "srt", Codec: "subrip", Muxer: "srt", ContentType: "application/x-subrip; charset=utf-8"},
+	"ass": {Ext: "ass", Codec: "ass", Muxer: "ass", ContentType: "text/x-ssa; charset=utf-8"},
+}
+
+// LookupFormat returns the output format for an extension
+func LookupFormat(ext string) (Format, bool) {
+	f, ok := formats[strings.ToLower(strings.TrimPrefix(ext, "."))]
+	return f, ok
+}
+
+// Service extracts embedded text subtitles and converts subtitle files,
+// caching the results on disk
+type Service struct {
+	config   *config.Config
+	cacheDir string
+
+	mu       sync.Mutex
+	inflight map[string]chan struct{} // Conversions in progress, by cache key
+}
+
+// NewService creates a subtitle service caching in cfg.SubtitleCacheDir
+func NewService(cfg *config.Config) (*Service, error) {
+	if err := os.MkdirAll(cfg.SubtitleCacheDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create subtitle cache dir: %w", err)
+	}
+	return &Service{
+		config:   cfg,
+		cacheDir: cfg.SubtitleCacheDir,
+		inflight: make(map[string]chan struct{}),
+	}, nil
+}
+
+// Convert returns the path of a subtitle track converted to the given
+// format, converting it on first use
+func (s *Service) Convert(ctx context.Context, movie *library.Movie, sub library.Subtitle, ext string) (string, error) {
+	format, ok := LookupFormat(ext)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedFormat, ext)
+	}
+	if sub.IsBitmap() {
+		return "", ErrBitmapSubtitle
+	}
+
+	key, err := cacheKey(movie, sub, format)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(s.cacheDir, key+"."+format.Ext)
+
+	for {
+		if _, err := os.Stat(path); err == nil {
+			// The modification time tracks use, for trimming
+			now := time.Now()
+			os.Chtimes(path, now, now)
+			return path, nil
+		}
+
+		// Only one request converts a given track; the others wait for it
+		s.mu.Lock()
+		done, busy := s.inflight[key]
+		if !busy {
+			done = make(chan struct{})
+			s.inflight[key] = done
+		}
+		s.mu.Unlock()
+
+		if busy {
+			select {
+			case <-done:
+				continue
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+
+		err := s.convert(ctx, movie, sub, format, path)
+
+		s.mu.Lock()
+		delete(s.inflight, key)
+		s.mu.Unlock()
+		close(done)
+
+		if err != nil {
+			return "", err
+		}
+		s.trim(path)
+		return path, nil
+	}
+}
+
+// trim removes the least recently used conversions until the cache fits in
+// SubtitleCacheMaxBytes, sparing the one just written
+func (s *Service) trim(keep string) {
+	limit := s.config.SubtitleCacheMaxBytes
+	if limit <= 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(s.cacheDir)
+	if err != nil {
+		return
+	}
+	type cached struct {
+		path string
+		size int64
+		used time.Time
+	}
+	var files []cached
+	var total int64
+	for _, e := range entries {
+		if e.IsDir() || strings.HasSuffix(e.Name(), ".part") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cached{filepath.Join(s.cacheDir, e.Name()), info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].used.Before(files[j].used) })
+	for _, f := range files {
+		if total <= limit {
+			break
+		}
+		if f.path == keep {
+			continue
+		}
+		if err := os.Remove(f.path); err == nil || os.IsNotExist(err) {
+			total -= f.size
+		}
+	}
+}
+
+// convert runs ffmpeg, writing to a temporary file that is renamed into
+// place so readers never see a partial conversion
+func (s *Service) convert(ctx context.Context, movie *library.Movie, sub library.Subtitle, format Format, path string) error {
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin"}
+
+	if sub.IsExternal {
+		if !library.IsUTF8Charset(sub.Charset) {
+			args = append(args, "-sub_charenc", sub.Charset)
+		}
+		args = append(args, "-i", sub.FilePath, "-map", "0:s:0")
+	} else {
+		args = append(args, "-i", movie.FilePath, "-map", fmt.Sprintf("0:%d", sub.Index))
+	}
+
+	tmp := path + ".part"
+	args = append(args, "-c:s", format.Codec, "-f", format.Muxer, "-y", tmp)
+
+	cmd := exec.CommandContext(ctx, s.config.FFmpegPath, args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("ffmpeg subtitle conversion failed: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// cacheKey identifies a conversion by its source and output format. The
+// source file's modification time and charset are included, so edited or
+// re-encoded subtitles are converted again.
+func cacheKey(movie *library.Movie, sub library.Subtitle, format Format) (string, error) {
+	var source string
+	if sub.IsExternal {
+		info, err := os.Stat(sub.FilePath)
+		if err != nil {
+			return "", fmt.Errorf("subtitle file unavailable: %w", err)
+		}
+		source = fmt.Sprintf("%s|%d|%s", sub.FilePath, info.ModTime().UnixNano(), sub.Charset)
+	} else {
+		source = fmt.Sprintf("%s|%d|%d", movie.FilePath, movie.ModifiedAt.UnixNano(), sub.Index)
+	}
+
+	hash := sha256.Sum256([]byte(movie.ID + "|" + source + "|" + format.Ext))
+	return hex.EncodeToString(hash[:12]), nil
+}
@@ -0,0 +1,46 @@
+package subtitles
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wysentanu/dlna-movie-cast/internal/config"
+)
+
+func TestTrimEvictsLeastRecentlyUsed(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.SubtitleCacheDir = t.TempDir()
+	cfg.SubtitleCacheMaxBytes = 20
+	s, err := NewService(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Oldest first; "new" is the conversion just written
+	base := time.Now().Add(-time.Hour)
+	for i, name := range []string{"old.vtt", "used.vtt", "new.vtt"} {
+		path := filepath.Join(cfg.SubtitleCacheDir, name)
+		if err := os.WriteFile(path, make([]byte, 10), 0644); err != nil {
+			t.Fatal(err)
+		}
+		used := base.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(path, used, used); err != nil {
+			t.Fatal(err)
+		}
+	}
+	partial := filepath.Join(cfg.SubtitleCacheDir, "running.vtt.part")
+	if err := os.WriteFile(partial, make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s.trim(filepath.Join(cfg.SubtitleCacheDir, "new.vtt"))
+
+	for name, want := range map[string]bool{"old.vtt": false, "used.vtt": true, "new.vtt": true, "running.vtt.part": true} {
+		_, err := os.Stat(filepath.Join(cfg.SubtitleCacheDir, name))
+		if got := err == nil; got != want {
+			t.Errorf("%s kept = %v, want %v", name, got, want)
+		}
+	}
+}
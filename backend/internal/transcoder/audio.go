@@ -0,0 +1,185 @@
+package transcoder
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/wysentanu/dlna-movie-cast/internal/library"
+)
+
+// layoutChannels lists the channels of the layouts ffprobe reports, in order
+var layoutChannels = map[string][]string{
+	"mono":      {"FC"},
+	"stereo":    {"FL", "FR"},
+	"2.1":       {"FL", "FR", "LFE"},
+	"3.0":       {"FL", "FR", "FC"},
+	"4.0":       {"FL", "FR", "FC", "BC"},
+	"quad":      {"FL", "FR", "BL", "BR"},
+	"5.0":       {"FL", "FR", "FC", "BL", "BR"},
+	"5.0(side)": {"FL", "FR", "FC", "SL", "SR"},
+	"5.1":       {"FL", "FR", "FC", "LFE", "BL", "BR"},
+	"5.1(side)": {"FL", "FR", "FC", "LFE", "SL", "SR"},
+	"6.1":       {"FL", "FR", "FC", "LFE", "BC", "SL", "SR"},
+	"7.1":       {"FL", "FR", "FC", "LFE", "BL", "BR", "SL", "SR"},
+}
+
+// stereoWeights is each source channel's weight in the left and right mix.
+// The centre's 1.0 is 3 dB above the ITU-R BS.775 coefficient of 0.707, so
+// dialogue stays audible on TV speakers. LFE is dropped.
+var stereoWeights = map[string][2]float64{
+	"FL": {1, 0},
+	"FR": {0, 1},
+	"FC": {1, 1},
+	"BC": {0.5, 0.5},
+	"SL": {0.707, 0},
+	"SR": {0, 0.707},
+	"BL": {0.707, 0},
+	"BR": {0, 0.707},
+}
+
+// surroundWeights folds 6.1 and 7.1 into 5.1(side): back channels join the sides
+var surroundWeights = map[string]map[string]float64{
+	"FL":  {"FL": 1},
+	"FR":  {"FR": 1},
+	"FC":  {"FC": 1},
+	"LFE": {"LFE": 1},
+	"SL":  {"SL": 1, "BL": 1, "BC": 0.707},
+	"SR":  {"SR": 1, "BR": 1, "BC": 0.707},
+}
+
+// downmixLimiter catches the peaks the downmix can push past full scale. It
+// holds the output under -1 dBFS and leaves the level alone otherwise.
+const downmixLimiter = "alimiter=limit=0.891:level=0"
+
+// downmixFilter builds a pan filter folding a source layout into the target
+// channel count, followed by downmixLimiter. The gains are applied exactly
+// as given by stereoWeights and surroundWeights ("=" rather than pan's
+// renormalizing "<", which would cost 7.1 sources about 10 dB). It returns
+// "" when the layout is unknown or needs no downmix.
+func downmixFilter(layout string, channels int) string {
+	src, ok := layoutChannels[strings.ToLower(layout)]
+	if !ok || len(src) <= channels {
+		return ""
+	}
+
+	switch channels {
+	case 2:
+		var left, right []string
+		for _, ch := range src {
+			w, ok := stereoWeights[ch]
+			if !ok {
+				continue
+			}
+			if w[0] > 0 {
+				left = append(left, fmt.Sprintf("%g*%s", w[0], ch))
+			}
+			if w[1] > 0 {
+				right = append(right, fmt.Sprintf("%g*%s", w[1], ch))
+			}
+		}
+		return fmt.Sprintf("pan=stereo|FL=%s|FR=%s,%s", strings.Join(left, "+"), strings.Join(right, "+"), downmixLimiter)
+	case 6:
+		parts := []string{"pan=5.1(side)"}
+		for _, out := range []string{"FL", "FR", "FC", "LFE", "SL", "SR"} {
+			var terms []string
+			for _, in := range src {
+				if w, ok := surroundWeights[out][in]; ok {
+					terms = append(terms, fmt.Sprintf("%g*%s", w, in))
+				}
+			}
+			if len(terms) == 0 {
+				parts = append(parts, out+"=0*FL") // Silent channel
+				continue
+			}
+			parts = append(parts, out+"="+strings.Join(terms, "+"))
+		}
+		return strings.Join(parts, "|") + "," + downmixLimiter
+	}
+	return ""
+}
+
+// surroundBitrate is used when re-encoding lossless or DTS surround to AC3
+const surroundBitrate = "640k"
+
+// SelectAudio picks the audio codec for a renderer. Renderers that decode
+// AC3 or E-AC3 (typically through a soundbar or receiver) keep surround
+// sound: a compatible track is copied as is, and other surround tracks such
+// as DTS or TrueHD are re-encoded to 5.1 AC3. Explicit audio processing
+// (downmix, loudness, night mode) always re-encodes with the default codec.
+func (t *Transcoder) SelectAudio(movie *library.Movie, profile ClientProfile, opts *TranscodeOptions) {
+	if opts.AudioChannels > 0 || opts.Loudnorm || opts.NightMode {
+		return
+	}
+
+	codec := strings.ToLower(movie.AudioCodec)
+	if (codec == "ac3" || codec == "eac3") && profile.supportsAudio(codec) {
+		opts.AudioCodec = "copy"
+		return
+	}
+
+	if movie.AudioChannels > 2 && !profile.supportsAudio(codec) && profile.supportsAudio("ac3") && t.caps.HasEncoder("ac3") {
+		opts.AudioCodec = "ac3"
+		opts.AudioBitrate = surroundBitrate
+		if movie.AudioChannels > 6 {
+			opts.AudioChannels = 6 // AC3 carries at most 5.1
+		}
+	}
+}
+
+// audioArgs builds the audio encoder options and filter chain
+func audioArgs(movie *library.Movie, opts TranscodeOptions) []string {
+	if opts.AudioCodec == "copy" {
+		return []string{"-c:a", "copy"}
+	}
+
+	var args []string
+	var filters []string
+
+	// Downmix with our own matrix where the layout is known, otherwise let ffmpeg do it
+	if opts.AudioChannels > 0 && movie.AudioChannels > opts.AudioChannels {
+		if pan := downmixFilter(movie.AudioChannelLayout, opts.AudioChannels); pan != "" {
+			filters = append(filters, pan)
+		} else {
+			args = append(args, "-ac", strconv.Itoa(opts.AudioChannels))
+		}
+	}
+
+	if opts.NightMode {
+		// Lift quiet passages and tame explosions
+		filters = append(filters, "acompressor=threshold=0.125:ratio=4:attack=20:release=250:makeup=2")
+	}
+
+	if opts.Loudnorm {
+		// EBU R128 single-pass normalization; loudnorm resamples to 192 kHz internally
+		filters = append(filters, "loudnorm=I=-16:TP=-1.5:LRA=11", "aresample=48000")
+	}
+
+	if len(filters) > 0 {
+		args = append(args, "-af", strings.Join(filters, ","))
+	}
+
+	return append(args, "-c:a", opts.AudioCodec, "-b:a", opts.AudioBitrate)
+}
+
+// parseAudioParams applies the audio_channels, loudnorm and night_mode query
+// params. It reports whether any were set.
+func parseAudioParams(r *http.Request, opts *TranscodeOptions) bool {
+	q := r.URL.Query()
+	set := false
+
+	if n, err := strconv.Atoi(q.Get("audio_channels")); err == nil && n > 0 {
+		opts.AudioChannels = n
+		set = true
+	}
+	if q.Get("loudnorm") == "1" {
+		opts.Loudnorm = true
+		set = true
+	}
+	if q.Get("night_mode") == "1" {
+		opts.NightMode = true
+		set = true
+	}
+	return set
+}
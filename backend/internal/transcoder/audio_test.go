@@ -0,0 +1,24 @@
+package transcoder
+
+import "testing"
+
+func TestDownmixFilter(t *testing.T) {
+	tests := []struct {
+		layout   string
+		channels int
+		want     string
+	}{
+		{"5.1(side)", 2, "pan=stereo|FL=1*FL+1*FC+0.707*SL|FR=1*FR+1*FC+0.707*SR,alimiter=limit=0.891:level=0"},
+		{"7.1", 2, "pan=stereo|FL=1*FL+1*FC+0.707*BL+0.707*SL|FR=1*FR+1*FC+0.707*BR+0.707*SR,alimiter=limit=0.891:level=0"},
+		{"7.1", 6, "pan=5.1(side)|FL=1*FL|FR=1*FR|FC=1*FC|LFE=1*LFE|SL=1*BL+1*SL|SR=1*BR+1*SR,alimiter=limit=0.891:level=0"},
+		{"6.1", 6, "pan=5.1(side)|FL=1*FL|FR=1*FR|FC=1*FC|LFE=1*LFE|SL=0.707*BC+1*SL|SR=0.707*BC+1*SR,alimiter=limit=0.891:level=0"},
+		{"5.1", 6, ""},    // Nothing to fold
+		{"stereo", 2, ""}, // Already stereo
+		{"hexagonal", 2, ""},
+	}
+	for _, tt := range tests {
+		if got := downmixFilter(tt.layout, tt.channels); got != tt.want {
+			t.Errorf("downmixFilter(%s, %d) =\n  %s\nwant\n  %s", tt.layout, tt.channels, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,559 @@
+package transcoder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wysentanu/dlna-movie-cast/internal/library"
+)
+
+// cacheEntryFile holds an entry's metadata inside its directory
+const cacheEntryFile = "entry.json"
+
+// CacheEntry is one completed transcode kept on disk
+type CacheEntry struct {
+	Key       string    `json:"key"`
+	MovieID   string    `json:"movie_id"`
+	Format    string    `json:"format"` // "hls", "mp4" or "mpegts"
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+	LastUsed  time.Time `json:"last_used"`
+	dir       string
+
+	pins   int  // Sessions and streams serving the entry, guarded by TranscodeCache.mu
+	purged bool // Purged while pinned; deleted on the last Release
+}
+
+// Dir returns the directory holding the entry's files
+func (e *CacheEntry) Dir() string {
+	return e.dir
+}
+
+// File returns the path of the single output file of a progressive entry
+func (e *CacheEntry) File() string {
+	return filepath.Join(e.dir, "output."+e.Format)
+}
+
+// TranscodeCache keeps completed transcodes so repeat viewings with the same
+// options skip the encode. Entries are evicted least recently used first,
+// except those pinned by a viewer.
+type TranscodeCache struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	entries  map[string]*CacheEntry
+	pending  int64 // Bytes written by unfinished writers
+}
+
+// NewTranscodeCache opens (or creates) a cache directory and indexes its entries
+func NewTranscodeCache(dir string, maxBytes int64) (*TranscodeCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	c := &TranscodeCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*CacheEntry),
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range dirEntries {
+		if !d.IsDir() {
+			continue
+		}
+		entryDir := filepath.Join(dir, d.Name())
+		data, err := os.ReadFile(filepath.Join(entryDir, cacheEntryFile))
+		if err != nil {
+			// Incomplete write from a previous run
+			os.RemoveAll(entryDir)
+			continue
+		}
+		var entry CacheEntry
+		if err := json.Unmarshal(data, &entry); err != nil || entry.Key != d.Name() {
+			os.RemoveAll(entryDir)
+			continue
+		}
+		entry.dir = entryDir
+		c.entries[entry.Key] = &entry
+	}
+
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+
+	return c, nil
+}
+
+// CacheKey identifies a transcode by movie, file modification time and the
+// options that affect the output. A burned-in subtitle file contributes its
+// modification time, size and charset, so editing it invalidates the entry.
+func CacheKey(movie *library.Movie, opts TranscodeOptions) string {
+	// Options that don't change the output
+	opts.StartTime = 0
+	opts.OutputPath = ""
+	opts.Priority = 0
+	opts.UseHardwareAccel = false
+
+	var subtitle string
+	if opts.SubtitlePath != "" {
+		if info, err := os.Stat(opts.SubtitlePath); err == nil {
+			subtitle = fmt.Sprintf("%d|%d", info.ModTime().UnixNano(), info.Size())
+		}
+		if sub, ok := movie.ExternalSubtitle(opts.SubtitlePath); ok {
+			subtitle += "|" + sub.Charset
+		}
+	}
+
+	optsJSON, _ := json.Marshal(opts)
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%s|%s", movie.ID, movie.ModifiedAt.UnixNano(), optsJSON, subtitle)))
+	return hex.EncodeToString(hash[:12])
+}
+
+// Lookup returns a cached entry and marks it as recently used
+func (c *TranscodeCache) Lookup(key string) (*CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || entry.purged {
+		return nil, false
+	}
+	entry.LastUsed = time.Now()
+	c.writeEntry(entry)
+	return entry, true
+}
+
+// Acquire looks up an entry like Lookup and pins it, so eviction and purges
+// leave its files alone until Release
+func (c *TranscodeCache) Acquire(key string) (*CacheEntry, bool) {
+	entry, ok := c.Lookup(key)
+	if !ok {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry.purged {
+		return nil, false // Purged between the two locks
+	}
+	entry.pins++
+	return entry, true
+}
+
+// Release unpins an entry from Acquire. An entry purged while pinned is
+// deleted now, and the cache is trimmed if pins kept it over its size.
+func (c *TranscodeCache) Release(entry *CacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry.pins--
+	if entry.pins > 0 {
+		return
+	}
+	if entry.purged {
+		os.RemoveAll(entry.dir)
+		if c.entries[entry.Key] == entry {
+			delete(c.entries, entry.Key)
+		}
+		return
+	}
+	c.evict()
+}
+
+// reserve counts n bytes written by an unfinished writer toward the cache
+// size, evicting entries to make room
+func (c *TranscodeCache) reserve(n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pending += n
+	c.evict()
+}
+
+// unreserve releases bytes counted by reserve
+func (c *TranscodeCache) unreserve(n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending -= n
+}
+
+// HLSCacheWriter collects an HLS session into the cache. Segments that the
+// session's retention limits delete while it plays are kept first, so a
+// full movie can be cached however long it ran.
+type HLSCacheWriter struct {
+	cache   *TranscodeCache
+	key     string
+	movieID string
+	tmpDir  string
+
+	mu       sync.Mutex
+	failed   bool
+	closed   bool
+	reserved int64 // Bytes of kept segments counted toward the cache size
+}
+
+// NewHLSWriter starts collecting an HLS session for key
+func (c *TranscodeCache) NewHLSWriter(key, movieID string) (*HLSCacheWriter, error) {
+	tmpDir := filepath.Join(c.dir, ".tmp-"+uuid.New().String())
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return nil, err
+	}
+	return &HLSCacheWriter{cache: c, key: key, movieID: movieID, tmpDir: tmpDir}, nil
+}
+
+// Keep links or copies a segment into the entry before the session
+// deletes it. A failure only disables caching.
+func (w *HLSCacheWriter) Keep(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed || w.failed {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		w.failed = true
+		return
+	}
+	if err := linkOrCopy(path, filepath.Join(w.tmpDir, filepath.Base(path))); err != nil {
+		w.failed = true
+		return
+	}
+	w.reserved += info.Size()
+	w.cache.reserve(info.Size())
+}
+
+// Commit collects the rest of the finished session from srcDir and stores
+// the entry if every segment was kept
+func (w *HLSCacheWriter) Commit(srcDir string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.closed = true
+	if w.failed {
+		w.discard()
+		return fmt.Errorf("cache write failed")
+	}
+
+	files, err := os.ReadDir(srcDir)
+	if err != nil {
+		w.discard()
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		dst := filepath.Join(w.tmpDir, f.Name())
+		os.Remove(dst) // The playlist may have been kept at an earlier state
+		if err := linkOrCopy(filepath.Join(srcDir, f.Name()), dst); err != nil {
+			w.discard()
+			return err
+		}
+	}
+
+	if !isCacheableHLS(w.tmpDir) {
+		w.discard()
+		return fmt.Errorf("session is missing segments")
+	}
+	return w.cache.commit(w.key, w.movieID, "hls", w.tmpDir, w.reserved)
+}
+
+// Abort discards the collected segments
+func (w *HLSCacheWriter) Abort() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.closed = true
+	w.discard()
+}
+
+// discard deletes the collected segments and releases their reservation;
+// callers hold w.mu
+func (w *HLSCacheWriter) discard() {
+	os.RemoveAll(w.tmpDir)
+	w.cache.unreserve(w.reserved)
+	w.reserved = 0
+}
+
+// CacheWriter receives a progressive transcode as it streams
+type CacheWriter struct {
+	cache   *TranscodeCache
+	key     string
+	movieID string
+	format  string
+	tmpDir  string
+	file    *os.File
+	written int64 // Counted toward the cache size while the write runs
+	failed  bool
+}
+
+// NewWriter starts writing a progressive output for key
+func (c *TranscodeCache) NewWriter(key, movieID, format string) (*CacheWriter, error) {
+	tmpDir := filepath.Join(c.dir, ".tmp-"+uuid.New().String())
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(filepath.Join(tmpDir, "output."+format))
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, err
+	}
+	return &CacheWriter{cache: c, key: key, movieID: movieID, format: format, tmpDir: tmpDir, file: f}, nil
+}
+
+// Write implements io.Writer. A failed write, or an output outgrowing the
+// cache, only disables caching; it never interrupts the stream being teed
+// into the cache.
+func (w *CacheWriter) Write(p []byte) (int, error) {
+	if w.failed {
+		return len(p), nil
+	}
+	n := int64(len(p))
+	if w.cache.maxBytes > 0 && w.written+n > w.cache.maxBytes {
+		w.failed = true
+		return len(p), nil
+	}
+	if _, err := w.file.Write(p); err != nil {
+		w.failed = true
+		return len(p), nil
+	}
+	w.written += n
+	w.cache.reserve(n)
+	return len(p), nil
+}
+
+// Commit moves the finished output into the cache
+func (w *CacheWriter) Commit() error {
+	if err := w.file.Close(); err != nil || w.failed {
+		os.RemoveAll(w.tmpDir)
+		w.cache.unreserve(w.written)
+		return fmt.Errorf("cache write failed")
+	}
+	return w.cache.commit(w.key, w.movieID, w.format, w.tmpDir, w.written)
+}
+
+// Abort discards a partial output
+func (w *CacheWriter) Abort() {
+	w.file.Close()
+	os.RemoveAll(w.tmpDir)
+	w.cache.unreserve(w.written)
+}
+
+// commit turns a fully written temp directory into an entry, releasing the
+// bytes its writer reserved
+func (c *TranscodeCache) commit(key, movieID, format, tmpDir string, reserved int64) error {
+	size := dirSize(tmpDir)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pending -= reserved
+	if c.maxBytes > 0 && size > c.maxBytes {
+		os.RemoveAll(tmpDir)
+		return fmt.Errorf("output of %d bytes exceeds the cache size", size)
+	}
+
+	// A purged entry still pinned by a viewer keeps its directory, so the
+	// new output is dropped until the viewer is done
+	if _, exists := c.entries[key]; exists {
+		os.RemoveAll(tmpDir)
+		return nil
+	}
+
+	entryDir := filepath.Join(c.dir, key)
+	if err := os.Rename(tmpDir, entryDir); err != nil {
+		os.RemoveAll(tmpDir)
+		return err
+	}
+
+	now := time.Now()
+	entry := &CacheEntry{
+		Key:       key,
+		MovieID:   movieID,
+		Format:    format,
+		Size:      size,
+		CreatedAt: now,
+		LastUsed:  now,
+		dir:       entryDir,
+	}
+	c.entries[key] = entry
+	c.writeEntry(entry)
+	c.evict()
+
+	log.Printf("Cache: Stored %s transcode of movie %s (%d MiB)", format, movieID, size>>20)
+	return nil
+}
+
+// writeEntry persists an entry's metadata; callers hold c.mu
+func (c *TranscodeCache) writeEntry(entry *CacheEntry) {
+	data, _ := json.Marshal(entry)
+	os.WriteFile(filepath.Join(entry.dir, cacheEntryFile), data, 0644)
+}
+
+// evict removes least recently used entries until the cache, including
+// unfinished writes, fits. Pinned entries are skipped. Callers hold c.mu.
+func (c *TranscodeCache) evict() {
+	if c.maxBytes <= 0 {
+		return
+	}
+
+	entries := c.sortedEntries()
+	total := c.totalSize() + c.pending
+	for i := len(entries) - 1; i >= 0 && total > c.maxBytes; i-- {
+		if entries[i].pins > 0 {
+			continue
+		}
+		total -= entries[i].Size
+		c.remove(entries[i].Key)
+	}
+}
+
+// sortedEntries returns entries that haven't been purged, most recently used
+// first; callers hold c.mu
+func (c *TranscodeCache) sortedEntries() []*CacheEntry {
+	entries := make([]*CacheEntry, 0, len(c.entries))
+	for _, e := range c.entries {
+		if !e.purged {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastUsed.After(entries[j].LastUsed)
+	})
+	return entries
+}
+
+// totalSize sums all entries on disk, including purged ones still pinned;
+// callers hold c.mu
+func (c *TranscodeCache) totalSize() int64 {
+	var total int64
+	for _, e := range c.entries {
+		total += e.Size
+	}
+	return total
+}
+
+// remove deletes an entry, or marks it for deletion on its last Release if
+// it is pinned; callers hold c.mu
+func (c *TranscodeCache) remove(key string) bool {
+	entry, ok := c.entries[key]
+	if !ok || entry.purged {
+		return false
+	}
+	if entry.pins > 0 {
+		entry.purged = true
+		return true
+	}
+	os.RemoveAll(entry.dir)
+	delete(c.entries, key)
+	return true
+}
+
+// CacheListing reports the cache contents
+type CacheListing struct {
+	Dir        string        `json:"dir"`
+	TotalBytes int64         `json:"total_bytes"`
+	MaxBytes   int64         `json:"max_bytes"`
+	Entries    []*CacheEntry `json:"entries"`
+}
+
+// List returns all entries, most recently used first
+func (c *TranscodeCache) List() CacheListing {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheListing{
+		Dir:        c.dir,
+		TotalBytes: c.totalSize(),
+		MaxBytes:   c.maxBytes,
+		Entries:    c.sortedEntries(),
+	}
+}
+
+// Purge deletes one entry. It returns false if the key is unknown.
+func (c *TranscodeCache) Purge(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.remove(key)
+}
+
+// PurgeAll deletes every entry and returns how many were removed
+func (c *TranscodeCache) PurgeAll() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for key := range c.entries {
+		if c.remove(key) {
+			n++
+		}
+	}
+	return n
+}
+
+// dirSize sums the sizes of the files in a directory
+func dirSize(dir string) int64 {
+	var total int64
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && !e.IsDir() {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+// linkOrCopy hard-links a file, or copies it when the link fails, e.g.
+// because the HLS directory is on a RAM disk
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	return copyFile(src, dst)
+}
+
+// copyFile copies one regular file
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// isCacheableHLS reports whether a directory holds a finished playlist and
+// every one of its segments
+func isCacheableHLS(dir string) bool {
+	segments := segmentFiles(dir)
+	if len(segments) == 0 || segments[0].index != 0 || segments[len(segments)-1].index != len(segments)-1 {
+		return false
+	}
+	playlist, err := os.ReadFile(filepath.Join(dir, "playlist.m3u8"))
+	return err == nil && strings.Contains(string(playlist), "#EXT-X-ENDLIST")
+}
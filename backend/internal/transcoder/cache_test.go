@@ -0,0 +1,229 @@
+package transcoder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wysentanu/dlna-movie-cast/internal/config"
+	"github.com/wysentanu/dlna-movie-cast/internal/library"
+)
+
+// writeHLSSession writes a finished session with n segments into dir
+func writeHLSSession(t *testing.T, dir string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := os.WriteFile(filepath.Join(dir, segmentName(i)), []byte("segment"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "playlist.m3u8"), []byte("#EXTM3U\n#EXT-X-ENDLIST\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func segmentName(i int) string {
+	return fmt.Sprintf("segment_%d.ts", i)
+}
+
+func TestHLSCacheWriterKeepsEvictedSegments(t *testing.T) {
+	cache, err := NewTranscodeCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := t.TempDir()
+	writeHLSSession(t, session, 5)
+
+	m := &HLSManager{}
+	s := &HLSSession{Dir: session}
+	w, err := cache.NewHLSWriter("key", "movie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.keep = w.Keep
+
+	// Retention deletes the first three segments while the movie plays
+	var evicted []evictedSegment
+	m.evictPlayedSegments(s, 3, -1, &evicted)
+	for _, seg := range evicted {
+		seg.dispose()
+	}
+	if len(segmentFiles(session)) != 2 {
+		t.Fatalf("session has %d segments after eviction, want 2", len(segmentFiles(session)))
+	}
+
+	if err := w.Commit(session); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	entry, ok := cache.Lookup("key")
+	if !ok {
+		t.Fatal("entry not cached")
+	}
+	if n := len(segmentFiles(entry.Dir())); n != 5 {
+		t.Errorf("entry has %d segments, want 5", n)
+	}
+}
+
+func TestHLSCacheWriterRejectsIncompleteSession(t *testing.T) {
+	cache, err := NewTranscodeCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := t.TempDir()
+	writeHLSSession(t, session, 3)
+	os.Remove(filepath.Join(session, segmentName(0)))
+
+	w, err := cache.NewHLSWriter("key", "movie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Commit(session); err == nil {
+		t.Error("Commit succeeded without segment 0")
+	}
+	if _, ok := cache.Lookup("key"); ok {
+		t.Error("incomplete session was cached")
+	}
+}
+
+func TestTranscodeCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache, err := NewTranscodeCache(t.TempDir(), 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"old", "new"} {
+		w, err := cache.NewWriter(key, "movie", "mp4")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte("0123456789"))
+		if err := w.Commit(); err != nil {
+			t.Fatalf("Commit %s: %v", key, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cache.Lookup("old")
+
+	w, err := cache.NewWriter("newest", "movie", "mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("0123456789"))
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit newest: %v", err)
+	}
+
+	if _, ok := cache.Lookup("new"); ok {
+		t.Error("least recently used entry was kept")
+	}
+	if _, ok := cache.Lookup("old"); !ok {
+		t.Error("recently used entry was evicted")
+	}
+}
+
+// storeEntry commits a progressive entry of size bytes under key
+func storeEntry(t *testing.T, cache *TranscodeCache, key string, size int) {
+	t.Helper()
+	w, err := cache.NewWriter(key, "movie", "mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(make([]byte, size))
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit %s: %v", key, err)
+	}
+}
+
+func TestTranscodeCacheKeepsPinnedEntries(t *testing.T) {
+	cache, err := NewTranscodeCache(t.TempDir(), 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storeEntry(t, cache, "playing", 10)
+	entry, ok := cache.Acquire("playing")
+	if !ok {
+		t.Fatal("entry not cached")
+	}
+
+	// Two newer entries would normally push the older one out
+	time.Sleep(10 * time.Millisecond)
+	storeEntry(t, cache, "other", 10)
+	time.Sleep(10 * time.Millisecond)
+	storeEntry(t, cache, "newest", 10)
+	if _, err := os.Stat(entry.File()); err != nil {
+		t.Fatalf("pinned entry was evicted: %v", err)
+	}
+
+	if !cache.Purge("playing") {
+		t.Fatal("Purge(playing) = false")
+	}
+	if _, ok := cache.Lookup("playing"); ok {
+		t.Error("purged entry is still served")
+	}
+	if _, err := os.Stat(entry.File()); err != nil {
+		t.Fatalf("pinned entry was deleted by Purge: %v", err)
+	}
+
+	cache.Release(entry)
+	if _, err := os.Stat(entry.Dir()); !os.IsNotExist(err) {
+		t.Errorf("purged entry survived its release: %v", err)
+	}
+	if listing := cache.List(); listing.TotalBytes > 20 {
+		t.Errorf("cache holds %d bytes after release, want at most 20", listing.TotalBytes)
+	}
+}
+
+func TestTranscodeCacheCountsUnfinishedWrites(t *testing.T) {
+	cache, err := NewTranscodeCache(t.TempDir(), 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storeEntry(t, cache, "old", 10)
+
+	w, err := cache.NewWriter("new", "movie", "mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(make([]byte, 15))
+	if _, ok := cache.Lookup("old"); ok {
+		t.Error("entry kept although an unfinished write needs its space")
+	}
+
+	// An output larger than the whole cache gives up instead of growing
+	w.Write(make([]byte, 10))
+	if err := w.Commit(); err == nil {
+		t.Error("Commit succeeded for an output larger than the cache")
+	}
+	if cache.pending != 0 {
+		t.Errorf("pending = %d after Commit, want 0", cache.pending)
+	}
+}
+
+func TestCacheKeyFollowsSubtitleFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "movie.srt")
+	if err := os.WriteFile(path, []byte("1\n00:00:01,000 --> 00:00:02,000\nHello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	movie := &library.Movie{
+		ID:        "movie",
+		Subtitles: []library.Subtitle{{FilePath: path, IsExternal: true, Charset: "UTF-8"}},
+	}
+	opts := DefaultOptions(config.DefaultConfig())
+	opts.SubtitlePath = path
+	key := CacheKey(movie, opts)
+
+	movie.Subtitles[0].Charset = "windows-1251"
+	if CacheKey(movie, opts) == key {
+		t.Error("key unchanged after the charset changed")
+	}
+	movie.Subtitles[0].Charset = "UTF-8"
+
+	if err := os.WriteFile(path, []byte("1\n00:00:01,000 --> 00:00:02,000\nHello there\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if CacheKey(movie, opts) == key {
+		t.Error("key unchanged after the subtitle file was edited")
+	}
+}
@@ -0,0 +1,212 @@
+package transcoder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Errors returned when the installed ffmpeg can't honour a request.
+// ErrNoEncoder is wrapped with the codecs that were tried.
+var (
+	ErrNoEncoder               = errors.New("ffmpeg has no usable video encoder")
+	ErrSubtitleBurnUnsupported = errors.New("ffmpeg was built without the subtitles filter (libass)")
+)
+
+// Capabilities describes what the installed ffmpeg build supports
+type Capabilities struct {
+	Probed   bool            `json:"probed"`
+	Error    string          `json:"error,omitempty"`
+	Version  string          `json:"version"`
+	Encoders map[string]bool `json:"encoders"`
+	Decoders map[string]bool `json:"decoders"`
+	Filters  map[string]bool `json:"filters"`
+	HWAccels map[string]bool `json:"hwaccels"`
+}
+
+// Features summarises the capabilities that matter to clients
+type Features struct {
+	H264           bool   `json:"h264"`
+	HEVC           bool   `json:"hevc"`
+	SubtitleBurnIn bool   `json:"subtitle_burn_in"`
+	HDRToneMapping bool   `json:"hdr_tone_mapping"`
+	EncoderBackend string `json:"encoder_backend"`
+}
+
+// ProbeCapabilities runs ffmpeg's listing commands and parses their output
+func ProbeCapabilities(ctx context.Context, ffmpegPath string) (*Capabilities, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	caps := &Capabilities{
+		Encoders: make(map[string]bool),
+		Decoders: make(map[string]bool),
+		Filters:  make(map[string]bool),
+		HWAccels: make(map[string]bool),
+	}
+
+	out, err := runFFmpegListing(ctx, ffmpegPath, "-version")
+	if err != nil {
+		caps.Error = err.Error()
+		return caps, err
+	}
+	caps.Version = parseFFmpegVersion(out)
+
+	listings := []struct {
+		flag  string
+		parse func([]byte) map[string]bool
+		dest  *map[string]bool
+	}{
+		{"-encoders", parseCodecListing, &caps.Encoders},
+		{"-decoders", parseCodecListing, &caps.Decoders},
+		{"-filters", parseFilterListing, &caps.Filters},
+		{"-hwaccels", parseHWAccelListing, &caps.HWAccels},
+	}
+
+	for _, l := range listings {
+		out, err := runFFmpegListing(ctx, ffmpegPath, l.flag)
+		if err != nil {
+			caps.Error = err.Error()
+			return caps, err
+		}
+		*l.dest = l.parse(out)
+	}
+
+	caps.Probed = true
+	return caps, nil
+}
+
+// runFFmpegListing runs ffmpeg with a single informational flag
+func runFFmpegListing(ctx context.Context, ffmpegPath, flag string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, ffmpegPath, "-hide_banner", flag)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg %s failed: %w", flag, err)
+	}
+	return out, nil
+}
+
+// parseFFmpegVersion extracts the version from "ffmpeg version X Copyright ..."
+func parseFFmpegVersion(out []byte) string {
+	line, _, _ := strings.Cut(string(out), "\n")
+	fields := strings.Fields(line)
+	if len(fields) >= 3 && fields[1] == "version" {
+		return fields[2]
+	}
+	return strings.TrimSpace(line)
+}
+
+// parseCodecListing parses -encoders/-decoders output, where entries follow a
+// "------" separator and look like " V....D libx264  description"
+func parseCodecListing(out []byte) map[string]bool {
+	names := make(map[string]bool)
+	inList := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "---") {
+			inList = true
+			continue
+		}
+		if !inList {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) >= 2 {
+			names[fields[1]] = true
+		}
+	}
+	return names
+}
+
+// parseFilterListing parses -filters output, where entries look like
+// " T.C subtitles  V->V  description"
+func parseFilterListing(out []byte) map[string]bool {
+	names := make(map[string]bool)
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 3 && strings.Contains(fields[2], "->") {
+			names[fields[1]] = true
+		}
+	}
+	return names
+}
+
+// parseHWAccelListing parses -hwaccels output: a header line, then one method per line
+func parseHWAccelListing(out []byte) map[string]bool {
+	names := make(map[string]bool)
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasSuffix(line, ":") {
+			continue
+		}
+		names[line] = true
+	}
+	return names
+}
+
+// HasEncoder reports whether an encoder is available. An unprobed build is
+// assumed to support everything so a failed probe never blocks streaming.
+func (c *Capabilities) HasEncoder(name string) bool {
+	return c == nil || !c.Probed || c.Encoders[name]
+}
+
+// HasDecoder reports whether a decoder is available
+func (c *Capabilities) HasDecoder(name string) bool {
+	return c == nil || !c.Probed || c.Decoders[name]
+}
+
+// HasFilter reports whether a filter is available
+func (c *Capabilities) HasFilter(name string) bool {
+	return c == nil || !c.Probed || c.Filters[name]
+}
+
+// HasHWAccel reports whether a hardware decoding method is available
+func (c *Capabilities) HasHWAccel(name string) bool {
+	return c == nil || !c.Probed || c.HWAccels[name]
+}
+
+// SupportsBackend reports whether ffmpeg has the decoder and encoder a backend needs
+func (c *Capabilities) SupportsBackend(b EncoderBackend) bool {
+	if !c.HasEncoder(b.Encoder("h264")) {
+		return false
+	}
+	if hwaccel := argValue(b.DecodeArgs(), "-hwaccel"); hwaccel != "" {
+		return c.HasHWAccel(hwaccel)
+	}
+	return true
+}
+
+// argValue returns the value following flag in an argument list
+func argValue(args []string, flag string) string {
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == flag {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// Features returns the client-facing feature set for a given encoder backend
+func (c *Capabilities) Features(b EncoderBackend) Features {
+	sw := softwareBackend{}
+	hwToneMap := b.ToneMapFilters("")
+	return Features{
+		H264:           c.HasEncoder(b.Encoder("h264")) || c.HasEncoder(sw.Encoder("h264")),
+		HEVC:           c.HasEncoder(b.Encoder("hevc")) || c.HasEncoder(sw.Encoder("hevc")),
+		SubtitleBurnIn: c.HasFilter("subtitles"),
+		HDRToneMapping: (c.HasFilter("zscale") && c.HasFilter("tonemap")) ||
+			(hwToneMap != nil && c.HasFilter(filterName(hwToneMap[0]))),
+		EncoderBackend: b.Name(),
+	}
+}
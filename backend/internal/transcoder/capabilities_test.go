@@ -0,0 +1,185 @@
+package transcoder
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wysentanu/dlna-movie-cast/internal/config"
+	"github.com/wysentanu/dlna-movie-cast/internal/library"
+)
+
+// fakeFFmpeg prints canned listings for a build with libx264 and VAAPI but
+// without libx265 or libass
+const fakeFFmpeg = `#!/bin/sh
+case "$2" in
+-version)
+	echo "ffmpeg version 6.1.1-test Copyright (c) 2000-2023 the FFmpeg developers"
+	;;
+-encoders)
+	cat <<EOF
+Encoders:
+ V..... = Video
+ A..... = Audio
+ ------
+ V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (codec h264)
+ V....D h264_vaapi           H.264/AVC (VAAPI) (codec h264)
+ A....D aac                  AAC (Advanced Audio Coding)
+EOF
+	;;
+-decoders)
+	cat <<EOF
+Decoders:
+ V..... = Video
+ ------
+ VFS..D h264                 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10
+ VFS..D hevc                 HEVC (High Efficiency Video Coding)
+EOF
+	;;
+-filters)
+	cat <<EOF
+Filters:
+  T.. = Timeline support
+  .S. = Slice threading
+ ... scale             V->V       Scale the input video size and/or convert the image format.
+ TS. overlay           VV->V      Overlay a video source on top of the input.
+ ... hwupload          V->V       Upload a normal frame to a hardware frame
+EOF
+	;;
+-hwaccels)
+	printf 'Hardware acceleration methods:\nvaapi\n\n'
+	;;
+*)
+	exit 1
+	;;
+esac
+`
+
+// writeFakeFFmpeg writes fakeFFmpeg to a temporary file and returns its path
+func writeFakeFFmpeg(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(path, []byte(fakeFFmpeg), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestProbeCapabilities(t *testing.T) {
+	caps, err := ProbeCapabilities(context.Background(), writeFakeFFmpeg(t))
+	if err != nil {
+		t.Fatalf("ProbeCapabilities: %v", err)
+	}
+
+	if !caps.Probed || caps.Version != "6.1.1-test" {
+		t.Errorf("Probed = %v, Version = %q, want a probed 6.1.1-test", caps.Probed, caps.Version)
+	}
+	for _, name := range []string{"libx264", "h264_vaapi", "aac"} {
+		if !caps.HasEncoder(name) {
+			t.Errorf("HasEncoder(%s) = false", name)
+		}
+	}
+	for _, name := range []string{"libx265", "Encoders:", "V....."} {
+		if caps.HasEncoder(name) {
+			t.Errorf("HasEncoder(%s) = true", name)
+		}
+	}
+	if !caps.HasDecoder("hevc") {
+		t.Error("HasDecoder(hevc) = false")
+	}
+	if !caps.HasFilter("overlay") || !caps.HasFilter("scale") || caps.HasFilter("subtitles") {
+		t.Errorf("filters = %v, want overlay and scale without subtitles", caps.Filters)
+	}
+	if !caps.HasHWAccel("vaapi") || caps.HasHWAccel("cuda") || len(caps.HWAccels) != 1 {
+		t.Errorf("hwaccels = %v, want only vaapi", caps.HWAccels)
+	}
+
+	if !caps.SupportsBackend(vaapiBackend{device: "/dev/dri/renderD128"}) {
+		t.Error("SupportsBackend(vaapi) = false")
+	}
+	if caps.SupportsBackend(nvencBackend{}) {
+		t.Error("SupportsBackend(nvenc) = true without h264_nvenc or cuda")
+	}
+}
+
+func TestProbeCapabilitiesFailure(t *testing.T) {
+	caps, err := ProbeCapabilities(context.Background(), filepath.Join(t.TempDir(), "missing"))
+	if err == nil {
+		t.Fatal("ProbeCapabilities succeeded without ffmpeg")
+	}
+	if caps.Probed || caps.Error == "" {
+		t.Errorf("caps = %+v, want unprobed with an error", caps)
+	}
+
+	// An unprobed build is assumed to support everything
+	if f := caps.Features(softwareBackend{}); !f.H264 || !f.HEVC || !f.SubtitleBurnIn {
+		t.Errorf("Features = %+v, want everything enabled", f)
+	}
+}
+
+func TestCapabilitiesDegradeFeatures(t *testing.T) {
+	caps, err := ProbeCapabilities(context.Background(), writeFakeFFmpeg(t))
+	if err != nil {
+		t.Fatalf("ProbeCapabilities: %v", err)
+	}
+
+	f := caps.Features(softwareBackend{})
+	if !f.H264 {
+		t.Error("H264 hidden although libx264 is present")
+	}
+	if f.HEVC {
+		t.Error("HEVC offered without an HEVC encoder")
+	}
+	if f.SubtitleBurnIn {
+		t.Error("subtitle burn-in offered without the subtitles filter")
+	}
+	if f.HDRToneMapping {
+		t.Error("HDR tone mapping offered without zscale and tonemap")
+	}
+
+	// VAAPI tone maps on the GPU, but only if ffmpeg has the filter
+	if caps.Features(vaapiBackend{}).HDRToneMapping {
+		t.Error("HDR tone mapping offered without tonemap_vaapi")
+	}
+}
+
+func TestApplyCapabilitiesDegradesOptions(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.FFmpegPath = writeFakeFFmpeg(t)
+	cfg.HWAccel = "none"
+	tr := NewTranscoder(cfg)
+	movie := &library.Movie{}
+
+	opts := DefaultOptions(cfg)
+	opts.VideoCodec = "hevc"
+	if err := tr.applyCapabilities(movie, &opts); err != nil {
+		t.Fatalf("applyCapabilities(hevc): %v", err)
+	}
+	if opts.VideoCodec != "h264" {
+		t.Errorf("VideoCodec = %s, want the H.264 fallback", opts.VideoCodec)
+	}
+
+	opts = DefaultOptions(cfg)
+	opts.SubtitlePath = "/media/movie.srt"
+	if err := tr.applyCapabilities(movie, &opts); !errors.Is(err, ErrSubtitleBurnUnsupported) {
+		t.Errorf("applyCapabilities(subtitle) = %v, want ErrSubtitleBurnUnsupported", err)
+	}
+}
+
+func TestNoEncoderErrorNamesCodec(t *testing.T) {
+	cfg := config.DefaultConfig()
+	tr := &Transcoder{config: cfg, backend: softwareBackend{}, caps: &Capabilities{Probed: true}}
+	movie := &library.Movie{}
+
+	for codec, want := range map[string]string{"h264": "for H.264", "hevc": "for HEVC or H.264"} {
+		opts := DefaultOptions(cfg)
+		opts.VideoCodec = codec
+		err := tr.applyCapabilities(movie, &opts)
+		if !errors.Is(err, ErrNoEncoder) || !strings.HasSuffix(err.Error(), want) {
+			t.Errorf("applyCapabilities(%s) = %v, want ErrNoEncoder %s", codec, err, want)
+		}
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,7 +20,35 @@ type HLSSession struct {
 	MovieID      string
 	Dir          string
 	LastAccessed time.Time
-	Process      *os.Process
+	Process      *os.Process      // Set by AttachProcess, guarded by HLSManager.mu
+	Progress     *ProgressTracker // Set by AttachProcess, guarded by HLSManager.mu
+	Cached       bool             // Dir belongs to the transcode cache; never delete it
+
+	// Throttling state, guarded by HLSManager.mu
+	maxRequested int  // Highest segment index a client has fetched (-1 = none)
+	paused       bool // ffmpeg is stopped with SIGSTOP
+	noThrottle   bool // Suspending failed; leave the process alone
+	firstSegment int  // Lowest segment index not yet evicted
+
+	keep    func(path string) // Called with each segment before it is evicted, guarded by HLSManager.mu
+	release func()            // Unpins the cache entry of a cached session when it ends
+}
+
+// HLSOptions tunes how far sessions may run ahead of playback
+type HLSOptions struct {
+	// BufferSegments is how many segments ffmpeg may encode past the
+	// furthest one requested before it is paused (0 = never pause)
+	BufferSegments int
+
+	// RetentionSegments is how many already-played segments are kept
+	// behind the furthest one requested (0 = keep all)
+	RetentionSegments int
+
+	// MaxBytes caps segment storage across all sessions (0 = unlimited)
+	MaxBytes int64
+
+	// SessionMaxBytes caps segment storage for a single session (0 = unlimited)
+	SessionMaxBytes int64
 }
 
 // HLSManager manages HLS sessions
@@ -25,11 +56,12 @@ type HLSManager struct {
 	sessions map[string]*HLSSession
 	mu       sync.RWMutex
 	baseDir  string
+	opts     HLSOptions
 	stopChan chan struct{}
 }
 
 // NewHLSManager creates a new HLS manager
-func NewHLSManager(baseDir string) (*HLSManager, error) {
+func NewHLSManager(baseDir string, opts HLSOptions) (*HLSManager, error) {
 	// Clean up any leftover sessions from previous runs
 	_ = os.RemoveAll(baseDir)
 
@@ -41,11 +73,13 @@ func NewHLSManager(baseDir string) (*HLSManager, error) {
 	m := &HLSManager{
 		sessions: make(map[string]*HLSSession),
 		baseDir:  baseDir,
+		opts:     opts,
 		stopChan: make(chan struct{}),
 	}
 
-	// Start cleanup routine
+	// Start cleanup and maintenance routines
 	go m.cleanupRoutine()
+	go m.maintenanceRoutine()
 
 	return m, nil
 }
@@ -65,11 +99,12 @@ func (m *HLSManager) GetSession(movieID string) *HLSSession {
 	return nil
 }
 
-// CreateSession creates a new HLS session
-func (m *HLSManager) CreateSession(movieID string) (*HLSSession, error) {
+// CreateSession creates a new HLS session. If the movie already has one it
+// is returned instead, with created false, so only one caller starts ffmpeg.
+func (m *HLSManager) CreateSession(movieID string) (session *HLSSession, created bool, err error) {
 	// Check if session already exists
 	if s := m.GetSession(movieID); s != nil {
-		return s, nil
+		return s, false, nil
 	}
 
 	m.mu.Lock()
@@ -78,7 +113,7 @@ func (m *HLSManager) CreateSession(movieID string) (*HLSSession, error) {
 	// Double check
 	for _, s := range m.sessions {
 		if s.MovieID == movieID {
-			return s, nil
+			return s, false, nil
 		}
 	}
 
@@ -86,18 +121,75 @@ func (m *HLSManager) CreateSession(movieID string) (*HLSSession, error) {
 	dir := filepath.Join(m.baseDir, id)
 
 	if err := os.MkdirAll(dir, 0755); err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	session := &HLSSession{
+	session = &HLSSession{
 		ID:           id,
 		MovieID:      movieID,
 		Dir:          dir,
 		LastAccessed: time.Now(),
+		maxRequested: -1,
 	}
 
 	m.sessions[id] = session
-	return session, nil
+	return session, true, nil
+}
+
+// CreateCachedSession creates a session that serves a completed transcode
+// from the cache instead of running ffmpeg. release is called when the
+// session ends. If the movie already has a session it is returned instead,
+// with created false, and release is not kept.
+func (m *HLSManager) CreateCachedSession(movieID, dir string, release func()) (session *HLSSession, created bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sessions {
+		if s.MovieID == movieID {
+			s.LastAccessed = time.Now()
+			return s, false
+		}
+	}
+
+	session = &HLSSession{
+		ID:           uuid.New().String(),
+		MovieID:      movieID,
+		Dir:          dir,
+		LastAccessed: time.Now(),
+		Cached:       true,
+		maxRequested: -1,
+		release:      release,
+	}
+	m.sessions[session.ID] = session
+	return session, true
+}
+
+// AttachProcess records the ffmpeg process encoding a session, so it can be
+// throttled and killed with the session. If the session ended in the
+// meantime the process is killed and false is returned.
+func (m *HLSManager) AttachProcess(id string, process *os.Process, progress *ProgressTracker) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok {
+		process.Kill()
+		return false
+	}
+	s.Process = process
+	s.Progress = progress
+	return true
+}
+
+// KeepSegments registers a function that receives each segment of a session
+// before eviction deletes it, e.g. to collect the session for the cache
+func (m *HLSManager) KeepSegments(id string, keep func(path string)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.sessions[id]; ok {
+		s.keep = keep
+	}
 }
 
 // GetSessionByID returns a session by its ID
@@ -112,6 +204,31 @@ func (m *HLSManager) GetSessionByID(id string) *HLSSession {
 	return nil
 }
 
+// Sessions returns a snapshot of all active sessions. The copies can be
+// read without holding m.mu.
+func (m *HLSManager) Sessions() []*HLSSession {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	sessions := make([]*HLSSession, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		snapshot := *s
+		sessions = append(sessions, &snapshot)
+	}
+	return sessions
+}
+
+// RemoveSession terminates a session and deletes its segments
+func (m *HLSManager) RemoveSession(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.sessions[id]; ok {
+		m.terminateSession(s)
+		delete(m.sessions, id)
+	}
+}
+
 // Stop stops the manager and cleans up
 func (m *HLSManager) Stop() {
 	close(m.stopChan)
@@ -157,12 +274,390 @@ func (m *HLSManager) terminateSession(s *HLSSession) {
 	if s.Process != nil {
 		s.Process.Kill()
 	}
-	os.RemoveAll(s.Dir)
+	if !s.Cached {
+		os.RemoveAll(s.Dir)
+	}
+	if s.release != nil {
+		s.release()
+		s.release = nil
+	}
+}
+
+// maintenanceRoutine periodically throttles sessions that are too far ahead
+// of their viewers, evicts played segments and enforces storage quotas
+func (m *HLSManager) maintenanceRoutine() {
+	o := m.opts
+	if o.BufferSegments <= 0 && o.RetentionSegments <= 0 && o.MaxBytes <= 0 && o.SessionMaxBytes <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stopChan:
+			return
+		case <-ticker.C:
+			m.maintainSessions()
+		}
+	}
+}
+
+func (m *HLSManager) maintainSessions() {
+	var evicted []evictedSegment
+	defer func() {
+		// Keeping a segment may copy it, so it runs without the lock
+		for _, seg := range evicted {
+			seg.dispose()
+		}
+	}()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sessions {
+		if s.Cached {
+			continue // Cache entries are not scratch storage
+		}
+		if m.opts.RetentionSegments > 0 {
+			m.evictPlayedSegments(s, s.maxRequested-m.opts.RetentionSegments, -1, &evicted)
+		}
+
+		overQuota := false
+		if m.opts.SessionMaxBytes > 0 {
+			overQuota = m.evictPlayedSegments(s, s.maxRequested, m.opts.SessionMaxBytes, &evicted) > m.opts.SessionMaxBytes
+		}
+
+		m.throttleSession(s, overQuota)
+	}
+
+	if m.opts.MaxBytes > 0 {
+		m.enforceGlobalQuota(&evicted)
+	}
+}
+
+// throttleSession pauses ffmpeg once it is more than BufferSegments ahead of
+// the client or over its byte quota, and resumes it when the client is
+// within half the buffer and the session fits its quota again
+func (m *HLSManager) throttleSession(s *HLSSession, overQuota bool) {
+	if s.Process == nil || s.noThrottle {
+		return
+	}
+	if s.Progress != nil {
+		if exited, _ := s.Progress.Exited(); exited {
+			return
+		}
+	}
+
+	buffer := m.opts.BufferSegments
+	produced := highestSegment(s.Dir)
+	ahead := produced - s.maxRequested
+
+	tooFarAhead := buffer > 0 && ahead > buffer
+	caughtUp := buffer <= 0 || ahead <= buffer/2
+
+	switch {
+	case !s.paused && (tooFarAhead || overQuota):
+		if err := suspendProcess(s.Process); err != nil {
+			log.Printf("HLS: Cannot pause session %s, throttling disabled: %v", s.ID, err)
+			s.noThrottle = true
+			return
+		}
+		s.paused = true
+		log.Printf("HLS: Paused session %s at segment %d (client at %d)", s.ID, produced, s.maxRequested)
+	case s.paused && caughtUp && !overQuota:
+		if err := resumeProcess(s.Process); err != nil {
+			log.Printf("HLS: Cannot resume session %s: %v", s.ID, err)
+			return
+		}
+		s.paused = false
+		log.Printf("HLS: Resumed session %s at segment %d (client at %d)", s.ID, produced, s.maxRequested)
+	}
+}
+
+// evictedDir holds a session's evicted segments until they are disposed of
+const evictedDir = "evicted"
+
+// evictedSegment is a segment taken out of its session, waiting to be kept
+// and deleted once HLSManager.mu is released
+type evictedSegment struct {
+	path string
+	keep func(path string)
+}
+
+// dispose hands the segment to the session's keep function, if any, and
+// deletes it
+func (seg evictedSegment) dispose() {
+	if seg.keep != nil {
+		seg.keep(seg.path)
+	}
+	os.Remove(seg.path)
+}
+
+// evictPlayedSegments evicts segments below cutoff, oldest first, stopping
+// once the session is within limit bytes (limit < 0 evicts all of them).
+// Evicted segments are moved aside and appended to evicted, for the caller
+// to dispose of after releasing m.mu. It returns the session's remaining
+// size; callers hold m.mu.
+func (m *HLSManager) evictPlayedSegments(s *HLSSession, cutoff int, limit int64, evicted *[]evictedSegment) int64 {
+	segments := segmentFiles(s.Dir)
+	total := segmentsSize(segments)
+
+	for _, seg := range segments {
+		if seg.index >= cutoff || (limit >= 0 && total <= limit) {
+			break
+		}
+		// The segment keeps its name, which the cache writer relies on
+		aside := filepath.Join(s.Dir, evictedDir, seg.name)
+		if err := os.MkdirAll(filepath.Dir(aside), 0755); err != nil {
+			break
+		}
+		if err := os.Rename(filepath.Join(s.Dir, seg.name), aside); err != nil {
+			continue
+		}
+		*evicted = append(*evicted, evictedSegment{path: aside, keep: s.keep})
+		total -= seg.size
+		if seg.index >= s.firstSegment {
+			s.firstSegment = seg.index + 1
+		}
+	}
+	return total
+}
+
+// enforceGlobalQuota brings total usage under MaxBytes, first by evicting
+// played segments from the least recently used sessions, then by ending
+// those sessions. The most recently used session is never ended.
+func (m *HLSManager) enforceGlobalQuota(evicted *[]evictedSegment) {
+	sessions := make([]*HLSSession, 0, len(m.sessions))
+	var total int64
+	for _, s := range m.sessions {
+		if s.Cached {
+			continue
+		}
+		sessions = append(sessions, s)
+		total += segmentsSize(segmentFiles(s.Dir))
+	}
+	if total <= m.opts.MaxBytes {
+		return
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastAccessed.Before(sessions[j].LastAccessed)
+	})
+
+	for _, s := range sessions {
+		before := segmentsSize(segmentFiles(s.Dir))
+		after := m.evictPlayedSegments(s, s.maxRequested, before-(total-m.opts.MaxBytes), evicted)
+		total -= before - after
+		if total <= m.opts.MaxBytes {
+			return
+		}
+	}
+
+	for _, s := range sessions[:len(sessions)-1] {
+		total -= segmentsSize(segmentFiles(s.Dir))
+		m.terminateSession(s)
+		delete(m.sessions, s.ID)
+		log.Printf("HLS: Evicted session %s for movie %s to stay within storage quota", s.ID, s.MovieID)
+		if total <= m.opts.MaxBytes {
+			return
+		}
+	}
+}
+
+// MarkSegmentRequested records that a client fetched a segment
+func (m *HLSManager) MarkSegmentRequested(sessionID, segmentName string) {
+	idx, ok := segmentIndex(segmentName)
+	if !ok {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.sessions[sessionID]; ok && idx > s.maxRequested {
+		s.maxRequested = idx
+	}
+}
+
+// segmentIndex parses the index from a "segment_%03d.ts" file name
+func segmentIndex(name string) (int, bool) {
+	if !strings.HasPrefix(name, "segment_") || !strings.HasSuffix(name, ".ts") {
+		return 0, false
+	}
+	idx, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "segment_"), ".ts"))
+	if err != nil {
+		return 0, false
+	}
+	return idx, true
+}
+
+// segmentFile is one segment on disk
+type segmentFile struct {
+	name  string
+	index int
+	size  int64
+}
+
+// segmentFiles lists the segments in a session directory, oldest first
+func segmentFiles(dir string) []segmentFile {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var segments []segmentFile
+	for _, e := range entries {
+		idx, ok := segmentIndex(e.Name())
+		if !ok {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segmentFile{name: e.Name(), index: idx, size: info.Size()})
+	}
+
+	sort.Slice(segments, func(i, j int) bool { return segments[i].index < segments[j].index })
+	return segments
+}
+
+// segmentsSize returns the total size of a list of segments
+func segmentsSize(segments []segmentFile) int64 {
+	var total int64
+	for _, seg := range segments {
+		total += seg.size
+	}
+	return total
+}
+
+// highestSegment returns the highest segment index in a session directory (-1 = none)
+func highestSegment(dir string) int {
+	segments := segmentFiles(dir)
+	if len(segments) == 0 {
+		return -1
+	}
+	return segments[len(segments)-1].index
+}
+
+// SessionUsage reports the scratch storage used by one session
+type SessionUsage struct {
+	ID       string `json:"id"`
+	MovieID  string `json:"movie_id"`
+	Bytes    int64  `json:"bytes"`
+	Segments int    `json:"segments"`
+	Paused   bool   `json:"paused"`
+}
+
+// HLSUsage reports scratch storage across all sessions
+type HLSUsage struct {
+	Dir             string         `json:"dir"`
+	TotalBytes      int64          `json:"total_bytes"`
+	MaxBytes        int64          `json:"max_bytes"`         // 0 = unlimited
+	SessionMaxBytes int64          `json:"session_max_bytes"` // 0 = unlimited
+	Sessions        []SessionUsage `json:"sessions"`
+}
+
+// Usage returns current scratch storage usage
+func (m *HLSManager) Usage() HLSUsage {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	usage := HLSUsage{
+		Dir:             m.baseDir,
+		MaxBytes:        m.opts.MaxBytes,
+		SessionMaxBytes: m.opts.SessionMaxBytes,
+		Sessions:        make([]SessionUsage, 0, len(m.sessions)),
+	}
+	for _, s := range m.sessions {
+		if s.Cached {
+			continue
+		}
+		segments := segmentFiles(s.Dir)
+		su := SessionUsage{
+			ID:       s.ID,
+			MovieID:  s.MovieID,
+			Bytes:    segmentsSize(segments),
+			Segments: len(segments),
+			Paused:   s.paused,
+		}
+		usage.TotalBytes += su.Bytes
+		usage.Sessions = append(usage.Sessions, su)
+	}
+	return usage
+}
+
+// sessionDir returns a session's directory, or "" if the session is gone
+func (m *HLSManager) sessionDir(sessionID string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if s, ok := m.sessions[sessionID]; ok {
+		return s.Dir
+	}
+	return ""
 }
 
 // GetPlaylistPath returns the path to the playlist file
 func (m *HLSManager) GetPlaylistPath(sessionID string) string {
-	return filepath.Join(m.baseDir, sessionID, "playlist.m3u8")
+	dir := m.sessionDir(sessionID)
+	if dir == "" {
+		dir = filepath.Join(m.baseDir, sessionID)
+	}
+	return filepath.Join(dir, "playlist.m3u8")
+}
+
+// ReadPlaylist returns a session's media playlist. Once segments have been
+// evicted they are dropped from it, so players aren't sent to files that
+// no longer exist.
+func (m *HLSManager) ReadPlaylist(sessionID string) ([]byte, error) {
+	m.mu.RLock()
+	s, ok := m.sessions[sessionID]
+	var dir string
+	var first int
+	if ok {
+		dir, first = s.Dir, s.firstSegment
+	}
+	m.mu.RUnlock()
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "playlist.m3u8"))
+	if err != nil || first == 0 {
+		return data, err
+	}
+	return trimPlaylist(data, first), nil
+}
+
+// trimPlaylist drops the segments below first from a playlist and starts
+// its media sequence there. The EVENT type goes too, since an EVENT
+// playlist may only grow.
+func trimPlaylist(playlist []byte, first int) []byte {
+	lines := strings.Split(string(playlist), "\n")
+	out := make([]string, 0, len(lines))
+	var segmentTags []string // Tags of the segment whose URI comes next
+
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			out = append(out, "#EXT-X-MEDIA-SEQUENCE:"+strconv.Itoa(first))
+		case line == "#EXT-X-PLAYLIST-TYPE:EVENT":
+		case strings.HasPrefix(line, "#EXTINF:") || line == "#EXT-X-DISCONTINUITY":
+			segmentTags = append(segmentTags, line)
+		case line != "" && !strings.HasPrefix(line, "#"):
+			if idx, ok := segmentIndex(line); !ok || idx >= first {
+				out = append(out, segmentTags...)
+				out = append(out, line)
+			}
+			segmentTags = nil
+		default:
+			out = append(out, line)
+		}
+	}
+	return []byte(strings.Join(out, "\n"))
 }
 
 // CopySegment copies a segment file to the writer
@@ -173,7 +668,11 @@ func (m *HLSManager) CopySegment(sessionID, segmentName string, w io.Writer) err
 		return os.ErrPermission
 	}
 
-	path := filepath.Join(m.baseDir, sessionID, cleanName)
+	dir := m.sessionDir(sessionID)
+	if dir == "" {
+		return os.ErrNotExist
+	}
+	path := filepath.Join(dir, cleanName)
 
 	f, err := os.Open(path)
 	if err != nil {
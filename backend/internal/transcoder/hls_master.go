@@ -0,0 +1,119 @@
+package transcoder
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/wysentanu/dlna-movie-cast/internal/config"
+	"github.com/wysentanu/dlna-movie-cast/internal/library"
+	"github.com/wysentanu/dlna-movie-cast/internal/subtitles"
+)
+
+// serveHLSMaster serves a master playlist pointing at the video playlist,
+// with each text subtitle track as a WebVTT rendition players can switch
+// on instead of having it burned in
+func (h *StreamHandler) serveHLSMaster(w http.ResponseWriter, r *http.Request, movieID string) {
+	movie, err := h.library.GetMovie(movieID)
+	if err != nil {
+		http.Error(w, "Movie not found", http.StatusNotFound)
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+
+	hasSubtitles := false
+	for i, sub := range movie.Subtitles {
+		if sub.IsBitmap() {
+			continue
+		}
+		hasSubtitles = true
+
+		fmt.Fprintf(&b, `#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="%s",DEFAULT=NO,AUTOSELECT=YES`,
+			playlistQuote(subtitleName(sub, i)))
+		if sub.Language != "" {
+			fmt.Fprintf(&b, `,LANGUAGE="%s"`, playlistQuote(sub.Language))
+		}
+		fmt.Fprintf(&b, ",URI=\"subs_%d.m3u8\"\n", i)
+	}
+
+	fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d", streamBandwidth(h.config))
+	if hasSubtitles {
+		b.WriteString(`,SUBTITLES="subs"`)
+	}
+	b.WriteString("\nplaylist.m3u8")
+	if r.URL.RawQuery != "" {
+		b.WriteString("?" + r.URL.RawQuery)
+	}
+	b.WriteString("\n")
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(b.String()))
+}
+
+// serveSubtitlePlaylist serves a single-segment playlist for one subtitle
+// rendition covering the whole movie
+func (h *StreamHandler) serveSubtitlePlaylist(w http.ResponseWriter, r *http.Request, movieID, filename string) {
+	movie, err := h.library.GetMovie(movieID)
+	if err != nil {
+		http.Error(w, "Movie not found", http.StatusNotFound)
+		return
+	}
+
+	position, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(filename, "subs_"), ".m3u8"))
+	if err != nil || position < 0 || position >= len(movie.Subtitles) || movie.Subtitles[position].IsBitmap() {
+		http.Error(w, "Subtitle not found", http.StatusNotFound)
+		return
+	}
+
+	duration := movie.Duration
+	if duration <= 0 {
+		duration = 1
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", duration)
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n")
+	fmt.Fprintf(&b, "#EXTINF:%d.000,\n", duration)
+	b.WriteString(subtitles.URL(movie.ID, position, "vtt") + "?hls=1\n")
+	b.WriteString("#EXT-X-ENDLIST\n")
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Write([]byte(b.String()))
+}
+
+// subtitleName returns the display name of a subtitle rendition
+func subtitleName(sub library.Subtitle, position int) string {
+	switch {
+	case sub.Title != "":
+		return sub.Title
+	case sub.Language != "":
+		return sub.Language
+	default:
+		return fmt.Sprintf("Subtitle %d", position+1)
+	}
+}
+
+// playlistQuote strips characters that can't appear in a quoted playlist
+// attribute
+func playlistQuote(s string) string {
+	return strings.NewReplacer(`"`, "", "\n", " ", "\r", "").Replace(s)
+}
+
+// streamBandwidth estimates the peak bits per second of a transcode for the
+// master playlist
+func streamBandwidth(cfg *config.Config) int64 {
+	video, err := config.ParseBitrate(cfg.VideoBitrate)
+	if err != nil || video <= 0 {
+		video = 2000000
+	}
+	audio, err := config.ParseBitrate(cfg.AudioBitrate)
+	if err != nil || audio <= 0 {
+		audio = 192000
+	}
+	return video + audio
+}
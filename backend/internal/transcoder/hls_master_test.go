@@ -0,0 +1,39 @@
+package transcoder
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/wysentanu/dlna-movie-cast/internal/config"
+	"github.com/wysentanu/dlna-movie-cast/internal/library"
+)
+
+func TestStreamBandwidthIsDecimal(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.VideoBitrate = "4M"
+	cfg.AudioBitrate = "192k"
+
+	if got, want := streamBandwidth(cfg), int64(4192000); got != want {
+		t.Errorf("streamBandwidth = %d, want %d", got, want)
+	}
+
+	cfg.VideoBitrate = "bogus"
+	cfg.AudioBitrate = ""
+	if got, want := streamBandwidth(cfg), int64(2192000); got != want {
+		t.Errorf("streamBandwidth with invalid bitrates = %d, want the %d default", got, want)
+	}
+}
+
+func TestHLSTimestampsMatchSubtitleMap(t *testing.T) {
+	cfg := config.DefaultConfig()
+	tr := &Transcoder{config: cfg, backend: softwareBackend{}, caps: &Capabilities{}}
+	opts := DefaultOptions(cfg)
+	opts.Format = "hls"
+	opts.OutputPath = t.TempDir()
+
+	movie := &library.Movie{FilePath: "/media/movie.mkv", PixelFormat: "yuv420p"}
+	args := strings.Join(tr.buildFFmpegArgs(movie, opts), " ")
+	if !strings.Contains(args, "-muxdelay 0 -muxpreload 0 -output_ts_offset 1.4 ") {
+		t.Errorf("HLS args don't pin the 1.4 s timestamp offset:\n%s", args)
+	}
+}
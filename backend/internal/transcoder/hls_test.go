@@ -0,0 +1,120 @@
+package transcoder
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestHLSManager starts a manager over a temporary directory
+func newTestHLSManager(t *testing.T) *HLSManager {
+	t.Helper()
+	m, err := NewHLSManager(filepath.Join(t.TempDir(), "hls"), HLSOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Stop)
+	return m
+}
+
+func TestCreateSessionReportsCreation(t *testing.T) {
+	m := newTestHLSManager(t)
+
+	first, created, err := m.CreateSession("movie")
+	if err != nil || !created {
+		t.Fatalf("CreateSession = %v, %v, want a new session", created, err)
+	}
+	second, created, err := m.CreateSession("movie")
+	if err != nil || created || second != first {
+		t.Errorf("second CreateSession = %p, %v, %v, want the first session %p", second, created, err, first)
+	}
+
+	released := 0
+	cached, created := m.CreateCachedSession("movie", t.TempDir(), func() { released++ })
+	if created || cached != first {
+		t.Errorf("CreateCachedSession replaced the running session")
+	}
+	m.RemoveSession(first.ID)
+	if released != 0 {
+		t.Errorf("release of an unused cached session was called %d times", released)
+	}
+}
+
+func TestAttachProcessKillsOrphans(t *testing.T) {
+	m := newTestHLSManager(t)
+	session, _, err := m.CreateSession("movie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.RemoveSession(session.ID)
+
+	cmd := exec.Command("sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot start sleep: %v", err)
+	}
+	if m.AttachProcess(session.ID, cmd.Process, nil) {
+		t.Error("AttachProcess succeeded for a removed session")
+	}
+	if err := cmd.Wait(); err == nil {
+		t.Error("orphaned process was not killed")
+	}
+}
+
+func TestEvictedSegmentsLeaveThePlaylist(t *testing.T) {
+	m := newTestHLSManager(t)
+	session, _, err := m.CreateSession("movie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var playlist strings.Builder
+	playlist.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:EVENT\n")
+	for i := 0; i < 4; i++ {
+		name := segmentName(i)
+		if err := os.WriteFile(filepath.Join(session.Dir, name), []byte("segment"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		playlist.WriteString("#EXTINF:6.000000,\n" + name + "\n")
+	}
+	if err := os.WriteFile(filepath.Join(session.Dir, "playlist.m3u8"), []byte(playlist.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var kept []string
+	m.KeepSegments(session.ID, func(path string) { kept = append(kept, filepath.Base(path)) })
+
+	var evicted []evictedSegment
+	m.mu.Lock()
+	m.evictPlayedSegments(session, 2, -1, &evicted)
+	m.mu.Unlock()
+
+	// Evicted segments are out of the session before they are kept
+	if n := len(segmentFiles(session.Dir)); n != 2 {
+		t.Errorf("session lists %d segments after eviction, want 2", n)
+	}
+	if len(kept) != 0 {
+		t.Errorf("segments kept under the manager lock: %v", kept)
+	}
+	for _, seg := range evicted {
+		seg.dispose()
+	}
+	if len(kept) != 2 || kept[0] != segmentName(0) || kept[1] != segmentName(1) {
+		t.Errorf("kept = %v, want the first two segments by name", kept)
+	}
+
+	data, err := m.ReadPlaylist(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(data)
+	if strings.Contains(got, segmentName(1)+"\n") || !strings.Contains(got, segmentName(2)) {
+		t.Errorf("playlist still lists evicted segments:\n%s", got)
+	}
+	if !strings.Contains(got, "#EXT-X-MEDIA-SEQUENCE:2\n") || strings.Contains(got, "EVENT") {
+		t.Errorf("playlist not restarted at segment 2:\n%s", got)
+	}
+	if strings.Count(got, "#EXTINF") != 2 {
+		t.Errorf("playlist has %d durations, want 2:\n%s", strings.Count(got, "#EXTINF"), got)
+	}
+}
@@ -0,0 +1,393 @@
+package transcoder
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wysentanu/dlna-movie-cast/internal/config"
+)
+
+// EncoderBackend describes how to drive one family of video encoders.
+// Backends only build arguments; Available is the single place that
+// touches the host, so argument building can be exercised without hardware.
+type EncoderBackend interface {
+	// Name returns the backend identifier used in configuration (e.g. "vaapi")
+	Name() string
+
+	// Available reports whether the backend's device is present on this host
+	Available() bool
+
+	// DecodeArgs returns input options for hardware decoding (placed before -i)
+	DecodeArgs() []string
+
+	// DownloadFilters moves decoded frames into system memory for CPU filters
+	DownloadFilters() []string
+
+	// UploadFilters moves filtered frames back to the encoder's memory
+	UploadFilters() []string
+
+	// ToneMapFilters converts HDR frames to SDR on the device, ahead of
+	// DownloadFilters. nil means tone mapping has to happen in software.
+	ToneMapFilters(algorithm string) []string
+
+	// DeinterlaceFilters deinterlaces on the device, ahead of DownloadFilters.
+	// nil means deinterlacing has to happen in software.
+	DeinterlaceFilters() []string
+
+	// Encoder returns the ffmpeg encoder name for a target codec (h264, hevc)
+	Encoder(codec string) string
+
+	// EncoderArgs returns encoder-specific options placed after -c:v
+	EncoderArgs(codec, preset string) []string
+
+	// RateControlArgs returns the bitrate options for this encoder
+	RateControlArgs(bitrate string) []string
+}
+
+// isHEVC reports whether a target codec name means H.265
+func isHEVC(codec string) bool {
+	return codec == "hevc" || codec == "h265"
+}
+
+// softwareBackend encodes with libx264/libx265 on the CPU
+type softwareBackend struct{}
+
+func (softwareBackend) Name() string              { return "software" }
+func (softwareBackend) Available() bool           { return true }
+func (softwareBackend) DecodeArgs() []string      { return nil }
+func (softwareBackend) DownloadFilters() []string { return nil }
+func (softwareBackend) UploadFilters() []string   { return nil }
+
+func (softwareBackend) ToneMapFilters(algorithm string) []string { return nil }
+func (softwareBackend) DeinterlaceFilters() []string             { return nil }
+
+func (softwareBackend) Encoder(codec string) string {
+	if isHEVC(codec) {
+		return "libx265"
+	}
+	return "libx264"
+}
+
+func (softwareBackend) EncoderArgs(codec, preset string) []string {
+	profile := []string{"-profile:v", "high", "-level:v", "4.0"}
+	if isHEVC(codec) {
+		profile = []string{"-profile:v", "main"}
+	}
+	args := append([]string{"-preset", preset, "-pix_fmt", "yuv420p"}, profile...)
+	return append(args, bt709Args...)
+}
+
+// bt709Args tags the output as SDR BT.709 in limited range
+var bt709Args = []string{
+	"-colorspace", "bt709",
+	"-color_primaries", "bt709",
+	"-color_trc", "bt709",
+	"-color_range", "tv",
+}
+
+func (softwareBackend) RateControlArgs(bitrate string) []string {
+	return []string{"-b:v", bitrate}
+}
+
+// rkmppBackend uses the Rockchip Media Process Platform
+type rkmppBackend struct{}
+
+func (rkmppBackend) Name() string { return "rkmpp" }
+
+func (rkmppBackend) Available() bool {
+	return deviceExists("/dev/mpp_service")
+}
+
+func (rkmppBackend) DecodeArgs() []string {
+	return []string{"-hwaccel", "rkmpp", "-hwaccel_output_format", "drm_prime"}
+}
+
+func (rkmppBackend) DownloadFilters() []string { return []string{"hwdownload", "format=nv12"} }
+func (rkmppBackend) UploadFilters() []string   { return []string{"format=nv12", "hwupload"} }
+
+func (rkmppBackend) ToneMapFilters(algorithm string) []string { return nil }
+func (rkmppBackend) DeinterlaceFilters() []string             { return nil }
+
+func (rkmppBackend) Encoder(codec string) string {
+	if isHEVC(codec) {
+		return "hevc_rkmpp"
+	}
+	return "h264_rkmpp"
+}
+
+func (rkmppBackend) EncoderArgs(codec, preset string) []string { return nil }
+
+func (rkmppBackend) RateControlArgs(bitrate string) []string {
+	return []string{"-b:v", bitrate}
+}
+
+// vaapiBackend uses VA-API (Intel and AMD GPUs on Linux)
+type vaapiBackend struct {
+	device string
+}
+
+func (b vaapiBackend) Name() string { return "vaapi" }
+
+func (b vaapiBackend) Available() bool {
+	return deviceExists(b.device)
+}
+
+// DecodeArgs opens the device by name, so hwupload uses the same one as the
+// decoder rather than whatever ffmpeg would pick
+func (b vaapiBackend) DecodeArgs() []string {
+	return []string{
+		"-init_hw_device", "vaapi=va:" + b.device,
+		"-filter_hw_device", "va",
+		"-hwaccel", "vaapi",
+		"-hwaccel_device", "va",
+		"-hwaccel_output_format", "vaapi",
+	}
+}
+
+func (b vaapiBackend) DownloadFilters() []string { return []string{"hwdownload", "format=nv12"} }
+func (b vaapiBackend) UploadFilters() []string   { return []string{"format=nv12", "hwupload"} }
+
+// ToneMapFilters uses the driver's tone mapper, which has a fixed curve
+func (b vaapiBackend) ToneMapFilters(algorithm string) []string {
+	return []string{"tonemap_vaapi=format=nv12:t=bt709:m=bt709:p=bt709"}
+}
+
+func (b vaapiBackend) DeinterlaceFilters() []string {
+	return []string{"deinterlace_vaapi=rate=frame"}
+}
+
+func (b vaapiBackend) Encoder(codec string) string {
+	if isHEVC(codec) {
+		return "hevc_vaapi"
+	}
+	return "h264_vaapi"
+}
+
+func (b vaapiBackend) EncoderArgs(codec, preset string) []string { return nil }
+
+func (b vaapiBackend) RateControlArgs(bitrate string) []string {
+	return []string{"-rc_mode", "VBR", "-b:v", bitrate, "-maxrate", bitrate}
+}
+
+// qsvBackend uses Intel Quick Sync Video
+type qsvBackend struct {
+	device string
+}
+
+func (b qsvBackend) Name() string { return "qsv" }
+
+func (b qsvBackend) Available() bool {
+	return deviceExists(b.device) && drmVendor(b.device) == "0x8086"
+}
+
+// DecodeArgs derives the QSV device from a VAAPI one on the configured
+// render node and names it for hwupload
+func (b qsvBackend) DecodeArgs() []string {
+	return []string{
+		"-init_hw_device", "vaapi=va:" + b.device,
+		"-init_hw_device", "qsv=qs@va",
+		"-filter_hw_device", "qs",
+		"-hwaccel", "qsv",
+		"-hwaccel_device", "qs",
+		"-hwaccel_output_format", "qsv",
+	}
+}
+
+func (b qsvBackend) DownloadFilters() []string { return []string{"hwdownload", "format=nv12"} }
+
+func (b qsvBackend) UploadFilters() []string {
+	return []string{"format=nv12", "hwupload=extra_hw_frames=64"}
+}
+
+func (b qsvBackend) ToneMapFilters(algorithm string) []string { return nil }
+
+func (b qsvBackend) DeinterlaceFilters() []string {
+	return []string{"vpp_qsv=deinterlace=advanced"}
+}
+
+func (b qsvBackend) Encoder(codec string) string {
+	if isHEVC(codec) {
+		return "hevc_qsv"
+	}
+	return "h264_qsv"
+}
+
+func (b qsvBackend) EncoderArgs(codec, preset string) []string {
+	return []string{"-preset", qsvPreset(preset)}
+}
+
+func (b qsvBackend) RateControlArgs(bitrate string) []string {
+	return []string{"-b:v", bitrate, "-maxrate", bitrate}
+}
+
+// qsvPreset maps x264 preset names onto the subset QSV understands
+func qsvPreset(preset string) string {
+	switch preset {
+	case "ultrafast", "superfast", "veryfast":
+		return "veryfast"
+	case "faster", "fast":
+		return "fast"
+	case "slow", "slower", "veryslow":
+		return "slow"
+	default:
+		return "medium"
+	}
+}
+
+// nvencBackend uses NVIDIA NVENC with CUDA decoding
+type nvencBackend struct{}
+
+func (nvencBackend) Name() string { return "nvenc" }
+
+func (nvencBackend) Available() bool {
+	return deviceExists("/dev/nvidiactl") || deviceExists("/dev/nvidia0")
+}
+
+func (nvencBackend) DecodeArgs() []string {
+	return []string{"-hwaccel", "cuda", "-hwaccel_output_format", "cuda"}
+}
+
+func (nvencBackend) DownloadFilters() []string { return []string{"hwdownload", "format=nv12"} }
+func (nvencBackend) UploadFilters() []string   { return []string{"format=nv12", "hwupload_cuda"} }
+
+func (nvencBackend) ToneMapFilters(algorithm string) []string { return nil }
+
+func (nvencBackend) DeinterlaceFilters() []string {
+	return []string{"yadif_cuda=mode=send_frame:deint=interlaced"}
+}
+
+func (nvencBackend) Encoder(codec string) string {
+	if isHEVC(codec) {
+		return "hevc_nvenc"
+	}
+	return "h264_nvenc"
+}
+
+func (nvencBackend) EncoderArgs(codec, preset string) []string {
+	return []string{"-preset", nvencPreset(preset), "-tune", "ll"}
+}
+
+func (nvencBackend) RateControlArgs(bitrate string) []string {
+	return []string{"-rc", "vbr", "-b:v", bitrate, "-maxrate", bitrate}
+}
+
+// nvencPreset maps x264 preset names onto NVENC's p1 (fastest) to p7 (slowest)
+func nvencPreset(preset string) string {
+	switch preset {
+	case "ultrafast", "superfast":
+		return "p1"
+	case "veryfast":
+		return "p2"
+	case "faster", "fast":
+		return "p3"
+	case "slow":
+		return "p5"
+	case "slower":
+		return "p6"
+	case "veryslow":
+		return "p7"
+	default:
+		return "p4"
+	}
+}
+
+// v4l2m2mBackend uses V4L2 memory-to-memory encoders (Raspberry Pi and similar SoCs).
+// Decoding stays on the CPU since the m2m decoders are codec-specific.
+type v4l2m2mBackend struct {
+	device string
+}
+
+func (b v4l2m2mBackend) Name() string { return "v4l2m2m" }
+
+func (b v4l2m2mBackend) Available() bool {
+	return deviceExists(b.device)
+}
+
+func (b v4l2m2mBackend) DecodeArgs() []string      { return nil }
+func (b v4l2m2mBackend) DownloadFilters() []string { return nil }
+func (b v4l2m2mBackend) UploadFilters() []string   { return []string{"format=yuv420p"} }
+
+func (b v4l2m2mBackend) ToneMapFilters(algorithm string) []string { return nil }
+func (b v4l2m2mBackend) DeinterlaceFilters() []string             { return nil }
+
+func (b v4l2m2mBackend) Encoder(codec string) string {
+	if isHEVC(codec) {
+		return "hevc_v4l2m2m"
+	}
+	return "h264_v4l2m2m"
+}
+
+func (b v4l2m2mBackend) EncoderArgs(codec, preset string) []string { return nil }
+
+func (b v4l2m2mBackend) RateControlArgs(bitrate string) []string {
+	return []string{"-b:v", bitrate}
+}
+
+// encoderBackends returns all known backends for a configuration, in
+// auto-detection order. V4L2 M2M is last because /dev/video* nodes also
+// exist for webcams and capture cards.
+func encoderBackends(cfg *config.Config) []EncoderBackend {
+	return []EncoderBackend{
+		rkmppBackend{},
+		nvencBackend{},
+		qsvBackend{device: cfg.VAAPIDevice},
+		vaapiBackend{device: cfg.VAAPIDevice},
+		v4l2m2mBackend{device: cfg.V4L2Device},
+	}
+}
+
+// SelectEncoderBackend picks the hardware encoder backend named in the
+// configuration. "auto" probes each backend in turn, and "none" or an
+// unavailable backend falls back to software encoding.
+func SelectEncoderBackend(cfg *config.Config) EncoderBackend {
+	name := strings.ToLower(cfg.HWAccel)
+
+	switch name {
+	case "", "none", "software":
+		return softwareBackend{}
+	case "auto":
+		for _, b := range encoderBackends(cfg) {
+			if b.Name() == "v4l2m2m" {
+				continue // Only used when configured explicitly
+			}
+			if b.Available() {
+				return b
+			}
+		}
+		return softwareBackend{}
+	}
+
+	for _, b := range encoderBackends(cfg) {
+		if b.Name() != name {
+			continue
+		}
+		if b.Available() {
+			return b
+		}
+		log.Printf("Hardware backend %s is not available, falling back to software encoding", name)
+		return softwareBackend{}
+	}
+
+	log.Printf("Unknown hardware backend %q, falling back to software encoding", cfg.HWAccel)
+	return softwareBackend{}
+}
+
+// deviceExists checks whether a device node is present
+func deviceExists(path string) bool {
+	if path == "" {
+		return false
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// drmVendor returns the PCI vendor ID (e.g. "0x8086") for a DRM render node
+func drmVendor(device string) string {
+	data, err := os.ReadFile(filepath.Join("/sys/class/drm", filepath.Base(device), "device", "vendor"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,195 @@
+package transcoder
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wysentanu/dlna-movie-cast/internal/config"
+	"github.com/wysentanu/dlna-movie-cast/internal/library"
+)
+
+func TestEncoderBackendArgs(t *testing.T) {
+	tests := []struct {
+		backend     EncoderBackend
+		decode      []string
+		download    []string
+		upload      []string
+		deinterlace []string
+		toneMap     []string
+		h264        string
+		hevc        string
+		encoderArgs []string // For h264 at the "veryfast" preset
+		rateControl []string // For "4M"
+	}{
+		{
+			backend:     softwareBackend{},
+			h264:        "libx264",
+			hevc:        "libx265",
+			encoderArgs: []string{"-preset", "veryfast", "-pix_fmt", "yuv420p", "-profile:v", "high", "-level:v", "4.0", "-colorspace", "bt709", "-color_primaries", "bt709", "-color_trc", "bt709", "-color_range", "tv"},
+			rateControl: []string{"-b:v", "4M"},
+		},
+		{
+			backend:     rkmppBackend{},
+			decode:      []string{"-hwaccel", "rkmpp", "-hwaccel_output_format", "drm_prime"},
+			download:    []string{"hwdownload", "format=nv12"},
+			upload:      []string{"format=nv12", "hwupload"},
+			h264:        "h264_rkmpp",
+			hevc:        "hevc_rkmpp",
+			rateControl: []string{"-b:v", "4M"},
+		},
+		{
+			backend:     vaapiBackend{device: "/dev/dri/renderD128"},
+			decode:      []string{"-init_hw_device", "vaapi=va:/dev/dri/renderD128", "-filter_hw_device", "va", "-hwaccel", "vaapi", "-hwaccel_device", "va", "-hwaccel_output_format", "vaapi"},
+			download:    []string{"hwdownload", "format=nv12"},
+			upload:      []string{"format=nv12", "hwupload"},
+			deinterlace: []string{"deinterlace_vaapi=rate=frame"},
+			toneMap:     []string{"tonemap_vaapi=format=nv12:t=bt709:m=bt709:p=bt709"},
+			h264:        "h264_vaapi",
+			hevc:        "hevc_vaapi",
+			rateControl: []string{"-rc_mode", "VBR", "-b:v", "4M", "-maxrate", "4M"},
+		},
+		{
+			backend:     qsvBackend{device: "/dev/dri/renderD128"},
+			decode:      []string{"-init_hw_device", "vaapi=va:/dev/dri/renderD128", "-init_hw_device", "qsv=qs@va", "-filter_hw_device", "qs", "-hwaccel", "qsv", "-hwaccel_device", "qs", "-hwaccel_output_format", "qsv"},
+			download:    []string{"hwdownload", "format=nv12"},
+			upload:      []string{"format=nv12", "hwupload=extra_hw_frames=64"},
+			deinterlace: []string{"vpp_qsv=deinterlace=advanced"},
+			h264:        "h264_qsv",
+			hevc:        "hevc_qsv",
+			encoderArgs: []string{"-preset", "veryfast"},
+			rateControl: []string{"-b:v", "4M", "-maxrate", "4M"},
+		},
+		{
+			backend:     nvencBackend{},
+			decode:      []string{"-hwaccel", "cuda", "-hwaccel_output_format", "cuda"},
+			download:    []string{"hwdownload", "format=nv12"},
+			upload:      []string{"format=nv12", "hwupload_cuda"},
+			deinterlace: []string{"yadif_cuda=mode=send_frame:deint=interlaced"},
+			h264:        "h264_nvenc",
+			hevc:        "hevc_nvenc",
+			encoderArgs: []string{"-preset", "p2", "-tune", "ll"},
+			rateControl: []string{"-rc", "vbr", "-b:v", "4M", "-maxrate", "4M"},
+		},
+		{
+			backend:     v4l2m2mBackend{device: "/dev/video11"},
+			upload:      []string{"format=yuv420p"},
+			h264:        "h264_v4l2m2m",
+			hevc:        "hevc_v4l2m2m",
+			rateControl: []string{"-b:v", "4M"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.backend.Name(), func(t *testing.T) {
+			b := tt.backend
+			check := func(what string, got, want []string) {
+				t.Helper()
+				if len(got) == 0 && len(want) == 0 {
+					return
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("%s = %q, want %q", what, got, want)
+				}
+			}
+			check("DecodeArgs", b.DecodeArgs(), tt.decode)
+			check("DownloadFilters", b.DownloadFilters(), tt.download)
+			check("UploadFilters", b.UploadFilters(), tt.upload)
+			check("DeinterlaceFilters", b.DeinterlaceFilters(), tt.deinterlace)
+			check("ToneMapFilters", b.ToneMapFilters("hable"), tt.toneMap)
+			check("EncoderArgs", b.EncoderArgs("h264", "veryfast"), tt.encoderArgs)
+			check("RateControlArgs", b.RateControlArgs("4M"), tt.rateControl)
+
+			if got := b.Encoder("h264"); got != tt.h264 {
+				t.Errorf("Encoder(h264) = %q, want %q", got, tt.h264)
+			}
+			for _, codec := range []string{"hevc", "h265"} {
+				if got := b.Encoder(codec); got != tt.hevc {
+					t.Errorf("Encoder(%s) = %q, want %q", codec, got, tt.hevc)
+				}
+			}
+		})
+	}
+}
+
+func TestSoftwareHEVCArgs(t *testing.T) {
+	want := []string{"-preset", "slow", "-pix_fmt", "yuv420p", "-profile:v", "main", "-colorspace", "bt709", "-color_primaries", "bt709", "-color_trc", "bt709", "-color_range", "tv"}
+	if got := (softwareBackend{}).EncoderArgs("hevc", "slow"); !reflect.DeepEqual(got, want) {
+		t.Errorf("EncoderArgs(hevc) = %q, want %q", got, want)
+	}
+}
+
+func TestHighBitDepthSourcesDownloadAsP010(t *testing.T) {
+	cfg := config.DefaultConfig()
+	tr := &Transcoder{config: cfg, backend: vaapiBackend{device: "/dev/dri/renderD128"}, caps: &Capabilities{}}
+	opts := DefaultOptions(cfg)
+	opts.UseHardwareAccel = true
+
+	tests := []struct {
+		pixFmt string
+		want   string
+	}{
+		{"yuv420p", "hwdownload,format=nv12"},
+		{"yuv420p10le", "hwdownload,format=p010le"},
+		{"p010le", "hwdownload,format=p010le"},
+	}
+	for _, tt := range tests {
+		movie := &library.Movie{FilePath: "/media/movie.mkv", PixelFormat: tt.pixFmt, ColorTransfer: "bt709"}
+		args := strings.Join(tr.buildFFmpegArgs(movie, opts), " ")
+		if !strings.Contains(args, tt.want) {
+			t.Errorf("%s source: args lack %q:\n%s", tt.pixFmt, tt.want, args)
+		}
+	}
+}
+
+func TestNVENCPreset(t *testing.T) {
+	tests := map[string]string{
+		"ultrafast": "p1",
+		"superfast": "p1",
+		"veryfast":  "p2",
+		"faster":    "p3",
+		"fast":      "p3",
+		"medium":    "p4",
+		"":          "p4",
+		"slow":      "p5",
+		"slower":    "p6",
+		"veryslow":  "p7",
+	}
+	for preset, want := range tests {
+		if got := nvencPreset(preset); got != want {
+			t.Errorf("nvencPreset(%q) = %q, want %q", preset, got, want)
+		}
+	}
+}
+
+func TestQSVPreset(t *testing.T) {
+	tests := map[string]string{
+		"ultrafast": "veryfast",
+		"veryfast":  "veryfast",
+		"fast":      "fast",
+		"medium":    "medium",
+		"veryslow":  "slow",
+	}
+	for preset, want := range tests {
+		if got := qsvPreset(preset); got != want {
+			t.Errorf("qsvPreset(%q) = %q, want %q", preset, got, want)
+		}
+	}
+}
+
+func TestSelectEncoderBackendFallsBackToSoftware(t *testing.T) {
+	for _, name := range []string{"", "none", "software", "bogus"} {
+		cfg := config.DefaultConfig()
+		cfg.HWAccel = name
+		if got := SelectEncoderBackend(cfg).Name(); got != "software" {
+			t.Errorf("SelectEncoderBackend(%q) = %s, want software", name, got)
+		}
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.HWAccel = "v4l2m2m"
+	cfg.V4L2Device = "/nonexistent/video11"
+	if got := SelectEncoderBackend(cfg).Name(); got != "software" {
+		t.Errorf("SelectEncoderBackend(v4l2m2m) without a device = %s, want software", got)
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package transcoder
+
+import (
+	"errors"
+	"os"
+)
+
+var errSuspendUnsupported = errors.New("suspending processes is not supported on this platform")
+
+// suspendProcess is unavailable without POSIX job-control signals
+func suspendProcess(p *os.Process) error {
+	return errSuspendUnsupported
+}
+
+// resumeProcess is unavailable without POSIX job-control signals
+func resumeProcess(p *os.Process) error {
+	return errSuspendUnsupported
+}
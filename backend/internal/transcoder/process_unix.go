@@ -0,0 +1,18 @@
+//go:build unix
+
+package transcoder
+
+import (
+	"os"
+	"syscall"
+)
+
+// suspendProcess pauses a process with SIGSTOP
+func suspendProcess(p *os.Process) error {
+	return p.Signal(syscall.SIGSTOP)
+}
+
+// resumeProcess continues a process paused by suspendProcess
+func resumeProcess(p *os.Process) error {
+	return p.Signal(syscall.SIGCONT)
+}
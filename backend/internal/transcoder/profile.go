@@ -0,0 +1,187 @@
+package transcoder
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/wysentanu/dlna-movie-cast/internal/library"
+)
+
+// Output containers for progressive (non-HLS) transcodes
+const (
+	ContainerMP4    = "mp4"
+	ContainerMPEGTS = "mpegts"
+)
+
+// ClientProfile describes what a family of renderers can play
+type ClientProfile struct {
+	Name        string   `json:"name"`
+	UserAgents  []string `json:"-"`            // Case-insensitive User-Agent substrings
+	Container   string   `json:"container"`    // Preferred progressive container
+	VideoCodecs []string `json:"video_codecs"` // Codecs the renderer decodes natively
+	AudioCodecs []string `json:"audio_codecs"`
+}
+
+// defaultProfile is used when no known renderer matches
+var defaultProfile = ClientProfile{
+	Name:        "generic",
+	Container:   ContainerMP4,
+	VideoCodecs: []string{"h264"},
+	AudioCodecs: []string{"aac", "mp3"},
+}
+
+// clientProfiles lists renderers that need something other than the default.
+// Many older Sony, Panasonic and Philips TVs reject fragmented MP4 on a pipe
+// and only accept MPEG-TS for live transcodes. Samsung and LG TVs decode
+// AC3 and E-AC3, so surround tracks can reach a soundbar.
+var clientProfiles = []ClientProfile{
+	{
+		Name:        "sony",
+		UserAgents:  []string{"bravia", "sony"},
+		Container:   ContainerMPEGTS,
+		VideoCodecs: []string{"h264", "mpeg2video"},
+		AudioCodecs: []string{"aac", "ac3", "mp3"},
+	},
+	{
+		Name:        "panasonic",
+		UserAgents:  []string{"panasonic", "viera"},
+		Container:   ContainerMPEGTS,
+		VideoCodecs: []string{"h264", "mpeg2video"},
+		AudioCodecs: []string{"aac", "ac3", "mp3"},
+	},
+	{
+		Name:        "samsung",
+		UserAgents:  []string{"samsung", "sec_hhp"},
+		Container:   ContainerMP4,
+		VideoCodecs: []string{"h264"},
+		AudioCodecs: []string{"aac", "ac3", "eac3", "mp3"},
+	},
+	{
+		Name:        "lg",
+		UserAgents:  []string{"webos", "lge", "lg-"},
+		Container:   ContainerMP4,
+		VideoCodecs: []string{"h264"},
+		AudioCodecs: []string{"aac", "ac3", "eac3", "mp3"},
+	},
+	{
+		Name:        "philips",
+		UserAgents:  []string{"philips"},
+		Container:   ContainerMPEGTS,
+		VideoCodecs: []string{"h264", "mpeg2video"},
+		AudioCodecs: []string{"aac", "ac3", "mp3"},
+	},
+}
+
+// GetClientProfile returns a profile by name, or the default profile
+func GetClientProfile(name string) ClientProfile {
+	for _, p := range clientProfiles {
+		if strings.EqualFold(p.Name, name) {
+			return p
+		}
+	}
+	return defaultProfile
+}
+
+// ClientProfiles returns all known profiles, the default first
+func ClientProfiles() []ClientProfile {
+	return append([]ClientProfile{defaultProfile}, clientProfiles...)
+}
+
+// CanPlay reports whether the renderer decodes both codecs natively
+func (p ClientProfile) CanPlay(videoCodec, audioCodec string) bool {
+	return p.supportsVideo(videoCodec) && p.supportsAudio(audioCodec)
+}
+
+// supportsVideo reports whether a video codec plays without transcoding
+func (p ClientProfile) supportsVideo(codec string) bool {
+	return containsFold(p.VideoCodecs, codec)
+}
+
+// supportsAudio reports whether an audio codec plays without transcoding
+func (p ClientProfile) supportsAudio(codec string) bool {
+	return codec == "" || containsFold(p.AudioCodecs, codec)
+}
+
+// containsFold reports whether list contains s, ignoring case
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectVersion picks the optimized version of a movie to serve to a
+// renderer: one made for its profile, else any it can play natively
+func selectVersion(movie *library.Movie, profile ClientProfile) *library.Version {
+	versions := movie.CurrentVersions()
+	var playable *library.Version
+	for i := range versions {
+		v := &versions[i]
+		if _, err := os.Stat(v.FilePath); err != nil {
+			continue
+		}
+		if v.Profile == profile.Name {
+			return v
+		}
+		if playable == nil && profile.CanPlay(v.VideoCodec, v.AudioCodec) {
+			playable = v
+		}
+	}
+	return playable
+}
+
+// DetectClientProfile picks a profile from the renderer's User-Agent
+func DetectClientProfile(userAgent string) ClientProfile {
+	ua := strings.ToLower(userAgent)
+	for _, p := range clientProfiles {
+		for _, match := range p.UserAgents {
+			if strings.Contains(ua, match) {
+				return p
+			}
+		}
+	}
+	return defaultProfile
+}
+
+// resolveClientProfile uses the "profile" query param if set, otherwise the User-Agent
+func resolveClientProfile(r *http.Request) ClientProfile {
+	if name := r.URL.Query().Get("profile"); name != "" {
+		return GetClientProfile(name)
+	}
+	return DetectClientProfile(r.Header.Get("User-Agent"))
+}
+
+// resolveContainer picks the progressive container from the "container" query param or the profile
+func resolveContainer(r *http.Request, profile ClientProfile) string {
+	switch strings.ToLower(r.URL.Query().Get("container")) {
+	case "ts", "mpegts":
+		return ContainerMPEGTS
+	case "mp4":
+		return ContainerMP4
+	}
+	return profile.Container
+}
+
+// containerMIMEType returns the MIME type for a progressive container
+func containerMIMEType(container string) string {
+	if container == ContainerMPEGTS {
+		return "video/mpeg"
+	}
+	return "video/mp4"
+}
+
+// transcodeContentFeatures returns the contentFeatures.dlna.org value for a
+// transcoded stream. No DLNA profile name is given: it is optional, the
+// output's H.264 profile and resolution depend on the source and encoder,
+// and a wrong name makes renderers refuse the stream. A live transcode
+// can't seek by byte range (OP=00); a cached one is a regular file (OP=01).
+func transcodeContentFeatures(seekable bool) string {
+	op := "00"
+	if seekable {
+		op = "01"
+	}
+	return "DLNA.ORG_OP=" + op + ";DLNA.ORG_CI=1;DLNA.ORG_FLAGS=01700000000000000000000000000000"
+}
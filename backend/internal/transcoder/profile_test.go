@@ -0,0 +1,19 @@
+package transcoder
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTranscodeContentFeatures(t *testing.T) {
+	live := transcodeContentFeatures(false)
+	if !strings.HasPrefix(live, "DLNA.ORG_OP=00;") {
+		t.Errorf("live features = %q, want byte seeking off", live)
+	}
+	if cached := transcodeContentFeatures(true); !strings.HasPrefix(cached, "DLNA.ORG_OP=01;") {
+		t.Errorf("cached features = %q, want byte seeking on", cached)
+	}
+	if strings.Contains(live, "DLNA.ORG_PN=") {
+		t.Errorf("live features = %q, want no profile name", live)
+	}
+}
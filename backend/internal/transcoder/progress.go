@@ -0,0 +1,207 @@
+package transcoder
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stderrHistory is how many non-progress stderr lines are kept per transcode
+const stderrHistory = 50
+
+// TranscodeStats is a snapshot of ffmpeg's -progress output
+type TranscodeStats struct {
+	Frame     int64     `json:"frame"`
+	FPS       float64   `json:"fps"`
+	Speed     float64   `json:"speed"`    // 1.0 = real time
+	OutTime   float64   `json:"out_time"` // Seconds of output encoded so far
+	Bitrate   string    `json:"bitrate"`
+	TotalSize int64     `json:"total_size"`
+	Realtime  bool      `json:"realtime"` // Encoding at least as fast as playback
+	Finished  bool      `json:"finished"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ProgressTracker parses ffmpeg's stderr, which carries both the
+// key=value blocks from "-progress pipe:2" and regular log lines
+type ProgressTracker struct {
+	mu        sync.RWMutex
+	startedAt time.Time
+	stats     TranscodeStats
+	pending   TranscodeStats
+	lines     []string
+	next      int
+	exitErr   error
+	exited    bool
+	done      chan struct{}
+	degraded  bool
+}
+
+// NewProgressTracker creates an empty tracker
+func NewProgressTracker() *ProgressTracker {
+	return &ProgressTracker{
+		startedAt: time.Now(),
+		lines:     make([]string, 0, stderrHistory),
+		done:      make(chan struct{}),
+	}
+}
+
+// Consume reads ffmpeg's stderr until EOF
+func (p *ProgressTracker) Consume(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		p.handleLine(strings.TrimSpace(scanner.Text()))
+	}
+}
+
+// handleLine routes a line to the stats block or the stderr ring buffer
+func (p *ProgressTracker) handleLine(line string) {
+	if line == "" {
+		return
+	}
+
+	key, value, ok := strings.Cut(line, "=")
+	if !ok || strings.ContainsAny(key, " \t[") || !p.setField(key, strings.TrimSpace(value)) {
+		p.mu.Lock()
+		p.appendLine(line)
+		p.mu.Unlock()
+	}
+}
+
+// setField applies one progress key. It returns false for unknown keys.
+func (p *ProgressTracker) setField(key, value string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch key {
+	case "frame":
+		p.pending.Frame, _ = strconv.ParseInt(value, 10, 64)
+	case "fps":
+		p.pending.FPS, _ = strconv.ParseFloat(value, 64)
+	case "bitrate":
+		p.pending.Bitrate = value
+	case "total_size":
+		p.pending.TotalSize, _ = strconv.ParseInt(value, 10, 64)
+	case "out_time_us":
+		if us, err := strconv.ParseInt(value, 10, 64); err == nil {
+			p.pending.OutTime = float64(us) / 1e6
+		}
+	case "speed":
+		p.pending.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+	case "progress":
+		// End of a block: publish it
+		p.pending.Finished = value == "end"
+		p.pending.Realtime = p.pending.Speed >= 1
+		p.pending.UpdatedAt = time.Now()
+		p.stats = p.pending
+	case "out_time", "out_time_ms", "dup_frames", "drop_frames":
+		// Known but redundant
+	default:
+		if !strings.HasPrefix(key, "stream_") {
+			return false
+		}
+	}
+	return true
+}
+
+// appendLine adds a line to the ring buffer; callers hold p.mu
+func (p *ProgressTracker) appendLine(line string) {
+	if len(p.lines) < stderrHistory {
+		p.lines = append(p.lines, line)
+		return
+	}
+	p.lines[p.next] = line
+	p.next = (p.next + 1) % stderrHistory
+}
+
+// finish records how the ffmpeg process exited
+func (p *ProgressTracker) finish(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.exited {
+		return
+	}
+	p.exited = true
+	p.exitErr = err
+	close(p.done)
+}
+
+// Done is closed once ffmpeg has exited
+func (p *ProgressTracker) Done() <-chan struct{} {
+	return p.done
+}
+
+// setDegraded records that the scheduler lowered the output resolution
+func (p *ProgressTracker) setDegraded(degraded bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.degraded = degraded
+}
+
+// Degraded reports whether the scheduler lowered the output resolution
+func (p *ProgressTracker) Degraded() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.degraded
+}
+
+// Stats returns the most recent progress snapshot
+func (p *ProgressTracker) Stats() TranscodeStats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.stats
+}
+
+// RecentLog returns the last stderr lines, oldest first
+func (p *ProgressTracker) RecentLog() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	out := make([]string, 0, len(p.lines))
+	out = append(out, p.lines[p.next:]...)
+	out = append(out, p.lines[:p.next]...)
+	return out
+}
+
+// StartedAt returns when the transcode started
+func (p *ProgressTracker) StartedAt() time.Time {
+	return p.startedAt
+}
+
+// Exited reports whether ffmpeg has exited, and the error it exited with
+func (p *ProgressTracker) Exited() (bool, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.exited, p.exitErr
+}
+
+// TranscodeInfo describes an active transcode for the API
+type TranscodeInfo struct {
+	ID        string         `json:"id"`
+	MovieID   string         `json:"movie_id"`
+	Kind      string         `json:"kind"` // "hls" or "progressive"
+	StartedAt time.Time      `json:"started_at"`
+	Stats     TranscodeStats `json:"stats"`
+	ExitError string         `json:"exit_error,omitempty"`
+	RecentLog []string       `json:"recent_log"`
+}
+
+// newTranscodeInfo builds a TranscodeInfo from a tracker
+func newTranscodeInfo(id, movieID, kind string, p *ProgressTracker) TranscodeInfo {
+	info := TranscodeInfo{
+		ID:        id,
+		MovieID:   movieID,
+		Kind:      kind,
+		StartedAt: p.StartedAt(),
+		Stats:     p.Stats(),
+		RecentLog: p.RecentLog(),
+	}
+	if _, err := p.Exited(); err != nil {
+		info.ExitError = err.Error()
+	}
+	return info
+}
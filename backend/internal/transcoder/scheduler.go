@@ -0,0 +1,277 @@
+package transcoder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// preemptTimeout bounds the wait for a preempted encode to exit when no
+// queue timeout is configured
+const preemptTimeout = 30 * time.Second
+
+// Priority orders competing transcode requests
+type Priority int
+
+const (
+	// PriorityInteractive is for someone waiting to watch (casts, browser playback)
+	PriorityInteractive Priority = iota
+	// PriorityBackground is for work nobody is watching; it can be preempted
+	PriorityBackground
+)
+
+// Errors returned to transcodes that can't get or keep a slot
+var (
+	ErrSchedulerSaturated = errors.New("all transcode slots are busy")
+	ErrPreempted          = errors.New("transcode was preempted by an interactive request")
+)
+
+// SlotRequest describes a transcode asking to run
+type SlotRequest struct {
+	Hardware bool
+	Priority Priority
+	Cancel   func() // Stops the transcode if it gets preempted
+}
+
+// Slot is a granted right to run one ffmpeg encode. Release it when the
+// process exits.
+type Slot struct {
+	id        uint64
+	req       SlotRequest
+	sched     *Scheduler
+	Degraded  bool // Admitted over the limit; encode at reduced resolution
+	preempted bool
+	released  bool
+}
+
+// waiter is a queued SlotRequest
+type waiter struct {
+	req   SlotRequest
+	ready chan *Slot
+}
+
+// slotPool tracks one class of encoder (hardware or software)
+type slotPool struct {
+	max      int
+	active   map[uint64]*Slot
+	degraded int
+	queue    []*waiter
+}
+
+// PoolStats reports the state of one slot pool
+type PoolStats struct {
+	Max      int `json:"max"` // 0 = unlimited
+	Active   int `json:"active"`
+	Degraded int `json:"degraded"`
+	Queued   int `json:"queued"`
+}
+
+// SchedulerStats reports the state of both pools
+type SchedulerStats struct {
+	Hardware PoolStats `json:"hardware"`
+	Software PoolStats `json:"software"`
+}
+
+// Scheduler limits how many encodes run at once. Hardware and software
+// encodes are counted separately since they compete for different resources.
+type Scheduler struct {
+	mu           sync.Mutex
+	hardware     *slotPool
+	software     *slotPool
+	queueTimeout time.Duration
+	degrade      bool
+	nextID       uint64
+}
+
+// NewScheduler creates a scheduler. A max of 0 means unlimited. When degrade
+// is set, requests that find their pool full are admitted immediately at a
+// reduced resolution (up to max extra encodes) instead of queueing.
+func NewScheduler(maxSoftware, maxHardware int, queueTimeout time.Duration, degrade bool) *Scheduler {
+	return &Scheduler{
+		hardware:     &slotPool{max: maxHardware, active: make(map[uint64]*Slot)},
+		software:     &slotPool{max: maxSoftware, active: make(map[uint64]*Slot)},
+		queueTimeout: queueTimeout,
+		degrade:      degrade,
+	}
+}
+
+// pool returns the pool for a request; callers hold s.mu
+func (s *Scheduler) pool(hardware bool) *slotPool {
+	if hardware {
+		return s.hardware
+	}
+	return s.software
+}
+
+// grant creates an active slot; callers hold s.mu
+func (s *Scheduler) grant(p *slotPool, req SlotRequest, degraded bool) *Slot {
+	s.nextID++
+	slot := &Slot{id: s.nextID, req: req, sched: s, Degraded: degraded}
+	if degraded {
+		p.degraded++
+	} else {
+		p.active[slot.id] = slot
+	}
+	return slot
+}
+
+// Acquire waits for a free slot. When the pool is full, interactive requests
+// preempt background work and wait for its slot, even without a queue
+// timeout; otherwise the request is degraded (if enabled) or queues for up
+// to the configured timeout.
+func (s *Scheduler) Acquire(ctx context.Context, req SlotRequest) (*Slot, error) {
+	s.mu.Lock()
+	p := s.pool(req.Hardware)
+
+	if p.max <= 0 || (len(p.active) < p.max && !s.hasQueuedAhead(p, req.Priority)) {
+		slot := s.grant(p, req, false)
+		s.mu.Unlock()
+		return slot, nil
+	}
+
+	preempted := req.Priority == PriorityInteractive && s.preemptBackground(p)
+	if !preempted && s.degrade && p.degraded < p.max {
+		slot := s.grant(p, req, true)
+		s.mu.Unlock()
+		return slot, nil
+	}
+
+	timeout := s.queueTimeout
+	if preempted && timeout <= 0 {
+		timeout = preemptTimeout
+	}
+	if timeout <= 0 {
+		err := s.saturatedError(p, req.Hardware)
+		s.mu.Unlock()
+		return nil, err
+	}
+
+	w := &waiter{req: req, ready: make(chan *Slot, 1)}
+	s.enqueue(p, w)
+	s.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case slot := <-w.ready:
+		return slot, nil
+	case <-ctx.Done():
+		return nil, s.abandon(p, w, ctx.Err())
+	case <-timer.C:
+		s.mu.Lock()
+		err := s.saturatedError(p, req.Hardware)
+		s.mu.Unlock()
+		return nil, s.abandon(p, w, err)
+	}
+}
+
+// hasQueuedAhead reports whether a waiter of equal or higher priority is
+// queued, so a newcomer doesn't jump the line; callers hold s.mu
+func (s *Scheduler) hasQueuedAhead(p *slotPool, priority Priority) bool {
+	for _, w := range p.queue {
+		if w.req.Priority <= priority {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue inserts a waiter after all waiters of equal or higher priority; callers hold s.mu
+func (s *Scheduler) enqueue(p *slotPool, w *waiter) {
+	i := len(p.queue)
+	for i > 0 && p.queue[i-1].req.Priority > w.req.Priority {
+		i--
+	}
+	p.queue = append(p.queue, nil)
+	copy(p.queue[i+1:], p.queue[i:])
+	p.queue[i] = w
+}
+
+// preemptBackground cancels one running background encode so its slot
+// frees up soon; callers hold s.mu
+func (s *Scheduler) preemptBackground(p *slotPool) bool {
+	for _, slot := range p.active {
+		if slot.req.Priority == PriorityBackground && !slot.preempted && slot.req.Cancel != nil {
+			slot.preempted = true
+			go slot.req.Cancel()
+			return true
+		}
+	}
+	return false
+}
+
+// abandon removes a waiter that gave up. If a slot was handed over in the
+// meantime it is released again.
+func (s *Scheduler) abandon(p *slotPool, w *waiter, err error) error {
+	s.mu.Lock()
+	for i, q := range p.queue {
+		if q == w {
+			p.queue = append(p.queue[:i], p.queue[i+1:]...)
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	select {
+	case slot := <-w.ready:
+		slot.Release()
+	default:
+	}
+	return err
+}
+
+// saturatedError describes a full pool; callers hold s.mu
+func (s *Scheduler) saturatedError(p *slotPool, hardware bool) error {
+	kind := "software"
+	if hardware {
+		kind = "hardware"
+	}
+	return fmt.Errorf("%w: %d/%d %s encodes running, %d queued",
+		ErrSchedulerSaturated, len(p.active)+p.degraded, p.max, kind, len(p.queue))
+}
+
+// Release frees the slot and hands it to the next waiter. It is safe to call more than once.
+func (slot *Slot) Release() {
+	s := slot.sched
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if slot.released {
+		return
+	}
+	slot.released = true
+
+	p := s.pool(slot.req.Hardware)
+	if slot.Degraded {
+		p.degraded--
+		return
+	}
+	delete(p.active, slot.id)
+
+	if len(p.queue) > 0 && len(p.active) < p.max {
+		w := p.queue[0]
+		p.queue = p.queue[1:]
+		w.ready <- s.grant(p, w.req, false)
+	}
+}
+
+// Preempted reports whether the scheduler cancelled this slot's transcode
+func (slot *Slot) Preempted() bool {
+	slot.sched.mu.Lock()
+	defer slot.sched.mu.Unlock()
+	return slot.preempted
+}
+
+// Stats returns the current pool usage
+func (s *Scheduler) Stats() SchedulerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := func(p *slotPool) PoolStats {
+		return PoolStats{Max: p.max, Active: len(p.active), Degraded: p.degraded, Queued: len(p.queue)}
+	}
+	return SchedulerStats{Hardware: stats(s.hardware), Software: stats(s.software)}
+}
@@ -0,0 +1,117 @@
+package transcoder
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSchedulerPreemptsBackgroundWithoutQueueTimeout(t *testing.T) {
+	s := NewScheduler(1, 0, 0, false)
+
+	var background *Slot
+	cancelled := make(chan struct{})
+	background, err := s.Acquire(context.Background(), SlotRequest{
+		Priority: PriorityBackground,
+		Cancel: func() {
+			close(cancelled)
+			background.Release()
+		},
+	})
+	if err != nil {
+		t.Fatalf("background Acquire: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	slot, err := s.Acquire(ctx, SlotRequest{Priority: PriorityInteractive})
+	if err != nil {
+		t.Fatalf("interactive Acquire: %v", err)
+	}
+	defer slot.Release()
+
+	select {
+	case <-cancelled:
+	default:
+		t.Fatal("background encode was not cancelled")
+	}
+	if !background.Preempted() {
+		t.Error("background slot not marked preempted")
+	}
+	if slot.Degraded {
+		t.Error("interactive slot is degraded, want a full slot")
+	}
+}
+
+func TestSchedulerDegrade(t *testing.T) {
+	s := NewScheduler(1, 0, 0, true)
+
+	first, err := s.Acquire(context.Background(), SlotRequest{})
+	if err != nil {
+		t.Fatalf("first Acquire: %v", err)
+	}
+	defer first.Release()
+
+	second, err := s.Acquire(context.Background(), SlotRequest{})
+	if err != nil {
+		t.Fatalf("second Acquire: %v", err)
+	}
+	if !second.Degraded {
+		t.Error("second slot not degraded")
+	}
+
+	if _, err := s.Acquire(context.Background(), SlotRequest{}); !errors.Is(err, ErrSchedulerSaturated) {
+		t.Errorf("third Acquire error = %v, want ErrSchedulerSaturated", err)
+	}
+
+	second.Release()
+	if stats := s.Stats().Software; stats.Active != 1 || stats.Degraded != 0 {
+		t.Errorf("stats after release = %+v, want 1 active and 0 degraded", stats)
+	}
+}
+
+func TestSchedulerSaturated(t *testing.T) {
+	s := NewScheduler(1, 0, 20*time.Millisecond, false)
+
+	slot, err := s.Acquire(context.Background(), SlotRequest{})
+	if err != nil {
+		t.Fatalf("first Acquire: %v", err)
+	}
+	defer slot.Release()
+
+	_, err = s.Acquire(context.Background(), SlotRequest{Priority: PriorityInteractive})
+	if !errors.Is(err, ErrSchedulerSaturated) {
+		t.Fatalf("Acquire error = %v, want ErrSchedulerSaturated", err)
+	}
+	if stats := s.Stats().Software; stats.Queued != 0 {
+		t.Errorf("queued = %d after timeout, want 0", stats.Queued)
+	}
+}
+
+func TestSchedulerQueueHandsOverSlot(t *testing.T) {
+	s := NewScheduler(1, 0, time.Second, false)
+
+	slot, err := s.Acquire(context.Background(), SlotRequest{})
+	if err != nil {
+		t.Fatalf("first Acquire: %v", err)
+	}
+
+	got := make(chan error, 1)
+	go func() {
+		next, err := s.Acquire(context.Background(), SlotRequest{})
+		if err == nil {
+			next.Release()
+		}
+		got <- err
+	}()
+
+	for s.Stats().Software.Queued == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	slot.Release()
+
+	if err := <-got; err != nil {
+		t.Errorf("queued Acquire: %v", err)
+	}
+}
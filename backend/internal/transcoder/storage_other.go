@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package transcoder
+
+// freeSpace is unknown on this platform; callers skip the check
+func freeSpace(path string) (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,14 @@
+//go:build linux || darwin
+
+package transcoder
+
+import "syscall"
+
+// freeSpace returns the bytes available to unprivileged users at path
+func freeSpace(path string) (int64, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, false
+	}
+	return int64(uint64(st.Bavail) * uint64(st.Bsize)), true
+}
@@ -1,16 +1,22 @@
 package transcoder
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/wysentanu/dlna-movie-cast/internal/config"
 	"github.com/wysentanu/dlna-movie-cast/internal/library"
 )
@@ -21,40 +27,87 @@ type StreamHandler struct {
 	library    *library.Library
 	transcoder *Transcoder
 	hlsManager *HLSManager
+	cache      *TranscodeCache // nil when caching is disabled
+
+	mu          sync.Mutex
+	progressive map[string]progressiveStream
+}
+
+// progressiveStream is a running non-HLS transcode
+type progressiveStream struct {
+	movieID  string
+	progress *ProgressTracker
 }
 
 // NewStreamHandler creates a new stream handler
 func NewStreamHandler(cfg *config.Config, lib *library.Library) (*StreamHandler, error) {
-	// Use RAM-based storage for HLS segments to avoid disk footprint
-	// Linux: /dev/shm is a tmpfs (RAM disk)
-	// macOS/other: /tmp is often RAM-based or cleared on reboot
-	var hlsDir string
-	if _, err := os.Stat("/dev/shm"); err == nil {
-		// Linux with tmpfs available
-		hlsDir = "/dev/shm/dlna-movie-cast-hls"
-	} else {
-		// macOS or systems without /dev/shm - use /tmp
-		hlsDir = "/tmp/dlna-movie-cast-hls"
+	hlsOpts := HLSOptions{
+		BufferSegments:    cfg.HLSBufferSegments,
+		RetentionSegments: cfg.HLSRetentionSegments,
+		MaxBytes:          cfg.HLSMaxBytes,
+		SessionMaxBytes:   cfg.HLSSessionMaxBytes,
 	}
 
-	log.Printf("HLS segments directory: %s (RAM-based)", hlsDir)
-
-	hlsManager, err := NewHLSManager(hlsDir)
+	hlsDir := selectHLSDir(cfg)
+	hlsManager, err := NewHLSManager(hlsDir, hlsOpts)
+	if err != nil && cfg.HLSDir == "" && cfg.HLSDiskDir != "" && hlsDir != cfg.HLSDiskDir {
+		log.Printf("HLS: Cannot use %s (%v); using disk", hlsDir, err)
+		hlsDir = cfg.HLSDiskDir
+		hlsManager, err = NewHLSManager(hlsDir, hlsOpts)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create HLS manager: %w", err)
 	}
+	log.Printf("HLS segments directory: %s", hlsDir)
+
+	var cache *TranscodeCache
+	if cfg.TranscodeCacheMaxBytes > 0 {
+		cache, err = NewTranscodeCache(cfg.TranscodeCacheDir, cfg.TranscodeCacheMaxBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open transcode cache: %w", err)
+		}
+		log.Printf("Transcode cache: %s (%d MiB max)", cfg.TranscodeCacheDir, cfg.TranscodeCacheMaxBytes>>20)
+	}
 
 	return &StreamHandler{
-		config:     cfg,
-		library:    lib,
-		transcoder: NewTranscoder(cfg),
-		hlsManager: hlsManager,
+		config:      cfg,
+		library:     lib,
+		transcoder:  NewTranscoder(cfg),
+		hlsManager:  hlsManager,
+		cache:       cache,
+		progressive: make(map[string]progressiveStream),
 	}, nil
 }
 
+// selectHLSDir picks where HLS segments are written. An explicit HLSDir is
+// always used. Otherwise RAM-based storage is preferred to avoid disk
+// footprint, falling back to HLSDiskDir when it has too little free space.
+func selectHLSDir(cfg *config.Config) string {
+	if cfg.HLSDir != "" {
+		return cfg.HLSDir
+	}
+
+	// Linux: /dev/shm is a tmpfs (RAM disk)
+	// macOS/other: /tmp is often RAM-based or cleared on reboot
+	ramDir := "/tmp/dlna-movie-cast-hls"
+	if _, err := os.Stat("/dev/shm"); err == nil {
+		ramDir = "/dev/shm/dlna-movie-cast-hls"
+	}
+
+	if cfg.HLSDiskDir == "" || cfg.HLSMaxBytes <= 0 {
+		return ramDir
+	}
+	if free, ok := freeSpace(filepath.Dir(ramDir)); ok && free < cfg.HLSMaxBytes {
+		log.Printf("HLS: %s has %d MiB free, below the %d MiB budget; using disk",
+			filepath.Dir(ramDir), free>>20, cfg.HLSMaxBytes>>20)
+		return cfg.HLSDiskDir
+	}
+	return ramDir
+}
+
 // ServeHTTP handles HTTP requests for streaming
 func (h *StreamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Path: /stream/{id} or /stream/{id}/hls/playlist.m3u8 or /stream/{id}/hls/segment_xxx.ts
+	// Path: /stream/{id} or /stream/{id}/hls/{master,playlist,subs_N}.m3u8 or /stream/{id}/hls/segment_xxx.ts
 	// parts[0] = stream, parts[1] = id, parts[2] = hls (optional), etc.
 	path := strings.Trim(r.URL.Path, "/")
 	parts := strings.Split(path, "/")
@@ -72,7 +125,11 @@ func (h *StreamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		filename := parts[3]
-		if strings.HasSuffix(filename, ".m3u8") {
+		if filename == "master.m3u8" {
+			h.serveHLSMaster(w, r, movieID)
+		} else if strings.HasPrefix(filename, "subs_") && strings.HasSuffix(filename, ".m3u8") {
+			h.serveSubtitlePlaylist(w, r, movieID, filename)
+		} else if strings.HasSuffix(filename, ".m3u8") {
 			h.serveHLSPlaylist(w, r, movieID)
 		} else if strings.HasSuffix(filename, ".ts") {
 			h.serveHLSSegment(w, r, movieID, filename)
@@ -90,26 +147,29 @@ func (h *StreamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// Check query parameters
 	transcode := r.URL.Query().Get("transcode") == "1"
-	subtitlePath := r.URL.Query().Get("subtitle")
-	subtitleIndexStr := r.URL.Query().Get("subtitle_index")
 	format := r.URL.Query().Get("format")
 
-	var subtitleIndex int = -1
-	if subtitleIndexStr != "" {
-		subtitleIndex, _ = strconv.Atoi(subtitleIndexStr)
+	subtitle, err := resolveSubtitle(r, movie)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
 	}
+	burnSubtitle := subtitle != nil
 
 	// Determine if transcoding is needed
-	needsTranscode := transcode || subtitlePath != "" || subtitleIndex >= 0
-	needsTranscode = needsTranscode || h.transcoder.NeedsTranscode(movie, subtitlePath != "" || subtitleIndex >= 0)
+	needsTranscode := transcode || burnSubtitle
+	needsTranscode = needsTranscode || h.transcoder.NeedsTranscode(movie, burnSubtitle)
+
+	// Downmixing and loudness processing need a transcode too
+	needsTranscode = needsTranscode || parseAudioParams(r, &TranscodeOptions{})
 
 	if format == "hls" {
-		// Redirect to HLS playlist
+		// Redirect to the HLS master playlist
 		// We preserve query params but remove format=hls to avoid loops if logic changes
 		q := r.URL.Query()
 		q.Del("format")
 
-		hlsURL := fmt.Sprintf("/stream/%s/hls/playlist.m3u8", movieID)
+		hlsURL := fmt.Sprintf("/stream/%s/hls/master.m3u8", movieID)
 		if len(q) > 0 {
 			hlsURL += "?" + q.Encode()
 		}
@@ -118,8 +178,16 @@ func (h *StreamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Prefer an optimized version the renderer plays natively over a live transcode
+	if needsTranscode && !burnSubtitle {
+		if version := selectVersion(movie, resolveClientProfile(r)); version != nil {
+			h.serveVersion(w, r, movie, version)
+			return
+		}
+	}
+
 	if needsTranscode {
-		h.serveTranscodedStream(w, r, movie, subtitlePath, subtitleIndex)
+		h.serveTranscodedStream(w, r, movie, subtitle)
 	} else {
 		h.serveDirectStream(w, r, movie)
 	}
@@ -139,38 +207,46 @@ func (h *StreamHandler) serveHLSPlaylist(w http.ResponseWriter, r *http.Request,
 			return
 		}
 
-		session, err = h.hlsManager.CreateSession(movieID)
+		// Prepare transcode options
+		subtitle, err := resolveSubtitle(r, movie)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to create HLS session: %v", err), http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusNotFound)
 			return
 		}
 
-		// Prepare transcode options
-		subtitlePath := r.URL.Query().Get("subtitle")
-		subtitleIndexStr := r.URL.Query().Get("subtitle_index")
-		var subtitleIndex int = -1
-		if subtitleIndexStr != "" {
-			subtitleIndex, _ = strconv.Atoi(subtitleIndexStr)
-		}
-
 		opts := DefaultOptions(h.config)
-		opts.SubtitlePath = subtitlePath
-		opts.SubtitleIndex = subtitleIndex
+		opts.applySubtitle(subtitle)
+		opts.ForcedSubtitlesOnly = r.URL.Query().Get("forced_subs") == "1"
+		parseSubtitleStyleParams(r, &opts.SubtitleStyle)
 		opts.Format = "hls"
-		opts.OutputPath = session.Dir
-
-		// Use background context so transcode doesn't stop when request ends
-		ctx := context.Background()
+		parseAudioParams(r, &opts)
+		h.transcoder.SelectAudio(movie, resolveClientProfile(r), &opts)
+
+		// Serve a completed transcode from the cache if we have one
+		cacheKey := CacheKey(movie, opts)
+		if entry, ok := h.cacheAcquire(cacheKey); ok {
+			var created bool
+			session, created = h.hlsManager.CreateCachedSession(movieID, entry.Dir(), func() { h.cache.Release(entry) })
+			if created {
+				log.Printf("[HLS] Serving movie %s from cache", movieID)
+			} else {
+				h.cache.Release(entry) // A concurrent request got there first
+			}
+			h.servePlaylistFile(w, r, session)
+			return
+		}
 
-		// Start transcoding process
-		process, err := h.transcoder.StartHLSTranscode(ctx, movie, opts)
+		var created bool
+		session, created, err = h.hlsManager.CreateSession(movieID)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to start HLS transcoding: %v", err), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Failed to create HLS session: %v", err), http.StatusInternalServerError)
 			return
 		}
-
-		session.Process = process
-		log.Printf("[HLS] Started transcoding session %s", session.ID)
+		if created {
+			if !h.startHLSSession(w, movie, session, opts, cacheKey) {
+				return
+			}
+		}
 
 		// Wait for the playlist to be created
 		for i := 0; i < 30; i++ {
@@ -181,17 +257,90 @@ func (h *StreamHandler) serveHLSPlaylist(w http.ResponseWriter, r *http.Request,
 		}
 	}
 
-	playlistPath := h.hlsManager.GetPlaylistPath(session.ID)
+	h.servePlaylistFile(w, r, session)
+}
+
+// startHLSSession starts ffmpeg for a session this request created. On
+// failure it writes the error response, removes the session and returns false.
+func (h *StreamHandler) startHLSSession(w http.ResponseWriter, movie *library.Movie, session *HLSSession, opts TranscodeOptions, cacheKey string) bool {
+	movieID := movie.ID
+	opts.OutputPath = session.Dir
+
+	// Collect segments for the cache before retention limits delete them
+	var cw *HLSCacheWriter
+	if h.cache != nil {
+		var err error
+		if cw, err = h.cache.NewHLSWriter(cacheKey, movieID); err != nil {
+			log.Printf("Cache: Failed to start HLS entry for movie %s: %v", movieID, err)
+			cw = nil
+		} else {
+			h.hlsManager.KeepSegments(session.ID, cw.Keep)
+		}
+	}
+
+	// Use background context so transcode doesn't stop when request ends
+	ctx := context.Background()
+
+	// Start transcoding process
+	process, progress, err := h.transcoder.StartHLSTranscode(ctx, movie, opts)
+	if err != nil {
+		if cw != nil {
+			cw.Abort()
+		}
+		h.hlsManager.RemoveSession(session.ID)
+		writeTranscodeError(w, "Failed to start HLS transcoding", err)
+		return false
+	}
 
-	// Check if playlist exists
-	if _, err := os.Stat(playlistPath); os.IsNotExist(err) {
+	if !h.hlsManager.AttachProcess(session.ID, process, progress) {
+		// The session expired or was evicted while ffmpeg started
+		if cw != nil {
+			cw.Abort()
+		}
+		http.Error(w, "HLS session ended", http.StatusServiceUnavailable)
+		return false
+	}
+	log.Printf("[HLS] Started transcoding session %s", session.ID)
+
+	if cw != nil {
+		go h.cacheHLSSession(session, cw, progress)
+	}
+	return true
+}
+
+// servePlaylistFile serves a session's playlist once ffmpeg has written it
+func (h *StreamHandler) servePlaylistFile(w http.ResponseWriter, r *http.Request, session *HLSSession) {
+	playlist, err := h.hlsManager.ReadPlaylist(session.ID)
+	if err != nil {
 		http.Error(w, "Playlist not ready yet", http.StatusServiceUnavailable)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
 	w.Header().Set("Cache-Control", "no-cache")
-	http.ServeFile(w, r, playlistPath)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(playlist))
+}
+
+// cacheAcquire checks the transcode cache, if enabled, and pins the entry
+// it finds; the caller releases it once done serving
+func (h *StreamHandler) cacheAcquire(key string) (*CacheEntry, bool) {
+	if h.cache == nil {
+		return nil, false
+	}
+	return h.cache.Acquire(key)
+}
+
+// cacheHLSSession stores a session in the cache once ffmpeg completes it
+func (h *StreamHandler) cacheHLSSession(session *HLSSession, cw *HLSCacheWriter, progress *ProgressTracker) {
+	<-progress.Done()
+
+	if _, err := progress.Exited(); err != nil || !progress.Stats().Finished || progress.Degraded() {
+		cw.Abort()
+		return
+	}
+	if err := cw.Commit(session.Dir); err != nil {
+		log.Printf("Cache: Failed to store HLS session %s: %v", session.ID, err)
+	}
 }
 
 func (h *StreamHandler) serveHLSSegment(w http.ResponseWriter, r *http.Request, movieID, filename string) {
@@ -204,6 +353,8 @@ func (h *StreamHandler) serveHLSSegment(w http.ResponseWriter, r *http.Request,
 	w.Header().Set("Content-Type", "video/mp2t")
 	w.Header().Set("Cache-Control", "max-age=3600")
 
+	h.hlsManager.MarkSegmentRequested(session.ID, filename)
+
 	if err := h.hlsManager.CopySegment(session.ID, filename, w); err != nil {
 		http.Error(w, "Segment not found", http.StatusNotFound)
 		return
@@ -212,7 +363,18 @@ func (h *StreamHandler) serveHLSSegment(w http.ResponseWriter, r *http.Request,
 
 // serveDirectStream serves the video file directly with range support
 func (h *StreamHandler) serveDirectStream(w http.ResponseWriter, r *http.Request, movie *library.Movie) {
-	file, err := os.Open(movie.FilePath)
+	h.serveFile(w, r, movie.FilePath, movie.Title)
+}
+
+// serveVersion serves an optimized version of a movie directly
+func (h *StreamHandler) serveVersion(w http.ResponseWriter, r *http.Request, movie *library.Movie, version *library.Version) {
+	log.Printf("Serving %s version of movie %s", version.Profile, movie.ID)
+	h.serveFile(w, r, version.FilePath, movie.Title)
+}
+
+// serveFile serves a file with range support
+func (h *StreamHandler) serveFile(w http.ResponseWriter, r *http.Request, path, name string) {
+	file, err := os.Open(path)
 	if err != nil {
 		http.Error(w, "Failed to open file", http.StatusInternalServerError)
 		return
@@ -226,19 +388,25 @@ func (h *StreamHandler) serveDirectStream(w http.ResponseWriter, r *http.Request
 	}
 
 	// Set content type based on file extension
-	contentType := h.getContentType(movie.FilePath)
+	contentType := h.getContentType(path)
 	w.Header().Set("Content-Type", contentType)
 	w.Header().Set("Accept-Ranges", "bytes")
 
 	// Handle range requests
-	http.ServeContent(w, r, movie.Title, stat.ModTime(), file)
+	http.ServeContent(w, r, name, stat.ModTime(), file)
 }
 
 // serveTranscodedStream serves a transcoded video stream
-func (h *StreamHandler) serveTranscodedStream(w http.ResponseWriter, r *http.Request, movie *library.Movie, subtitlePath string, subtitleIndex int) {
+func (h *StreamHandler) serveTranscodedStream(w http.ResponseWriter, r *http.Request, movie *library.Movie, subtitle *library.Subtitle) {
+	profile := resolveClientProfile(r)
+
 	opts := DefaultOptions(h.config)
-	opts.SubtitlePath = subtitlePath
-	opts.SubtitleIndex = subtitleIndex
+	opts.applySubtitle(subtitle)
+	opts.ForcedSubtitlesOnly = r.URL.Query().Get("forced_subs") == "1"
+	parseSubtitleStyleParams(r, &opts.SubtitleStyle)
+	opts.Format = resolveContainer(r, profile)
+	parseAudioParams(r, &opts)
+	h.transcoder.SelectAudio(movie, profile, &opts)
 
 	// Parse start time from query
 	if startStr := r.URL.Query().Get("start"); startStr != "" {
@@ -247,29 +415,170 @@ func (h *StreamHandler) serveTranscodedStream(w http.ResponseWriter, r *http.Req
 		}
 	}
 
+	// A cached transcode is a regular file, so it can be served with ranges
+	cacheKey := CacheKey(movie, opts)
+	if opts.StartTime == 0 {
+		if entry, ok := h.cacheAcquire(cacheKey); ok {
+			defer h.cache.Release(entry)
+			h.serveCachedFile(w, r, entry, opts)
+			return
+		}
+	}
+
 	// Start transcoding
-	reader, err := h.transcoder.Transcode(r.Context(), movie, opts)
+	reader, progress, err := h.transcoder.Transcode(r.Context(), movie, opts)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Transcoding failed: %v", err), http.StatusInternalServerError)
+		writeTranscodeError(w, "Transcoding failed", err)
 		return
 	}
 	defer reader.Close()
 
+	// Keep a copy of full-length transcodes for next time
+	var cacheWriter *CacheWriter
+	if h.cache != nil && opts.StartTime == 0 {
+		if cw, err := h.cache.NewWriter(cacheKey, movie.ID, opts.Format); err == nil {
+			cacheWriter = cw
+		} else {
+			log.Printf("Cache: Failed to start writing movie %s: %v", movie.ID, err)
+		}
+	}
+	var output io.Reader = reader
+	if cacheWriter != nil {
+		output = io.TeeReader(reader, cacheWriter)
+	}
+
+	streamID := uuid.New().String()
+	h.mu.Lock()
+	h.progressive[streamID] = progressiveStream{movieID: movie.ID, progress: progress}
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		delete(h.progressive, streamID)
+		h.mu.Unlock()
+	}()
+
 	// Set headers for streaming
-	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Content-Type", containerMIMEType(opts.Format))
 	w.Header().Set("Transfer-Encoding", "chunked")
 	w.Header().Set("Cache-Control", "no-cache")
 
 	// DLNA-specific headers
 	w.Header().Set("transferMode.dlna.org", "Streaming")
-	w.Header().Set("contentFeatures.dlna.org", "DLNA.ORG_OP=01;DLNA.ORG_CI=1;DLNA.ORG_FLAGS=01700000000000000000000000000000")
+	w.Header().Set("contentFeatures.dlna.org", transcodeContentFeatures(false))
 
 	// Stream the transcoded output
-	_, err = io.Copy(w, reader)
+	_, err = io.Copy(w, output)
 	if err != nil {
 		// Client likely disconnected, which is normal
+		if cacheWriter != nil {
+			cacheWriter.Abort()
+		}
 		return
 	}
+
+	// ffmpeg closed its output; wait for it to exit before judging the result
+	reader.Close()
+	_, exitErr := progress.Exited()
+	finished := exitErr == nil && progress.Stats().Finished
+	if !finished {
+		logTranscodeFailure(movie, exitErr, progress)
+	}
+
+	if cacheWriter != nil {
+		if finished && !progress.Degraded() {
+			if err := cacheWriter.Commit(); err != nil {
+				log.Printf("Cache: Failed to store movie %s: %v", movie.ID, err)
+			}
+		} else {
+			cacheWriter.Abort()
+		}
+	}
+}
+
+// serveCachedFile serves a completed progressive transcode from the cache
+func (h *StreamHandler) serveCachedFile(w http.ResponseWriter, r *http.Request, entry *CacheEntry, opts TranscodeOptions) {
+	file, err := os.Open(entry.File())
+	if err != nil {
+		http.Error(w, "Cached transcode unavailable", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		http.Error(w, "Cached transcode unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Serving movie %s from cache", entry.MovieID)
+	w.Header().Set("Content-Type", containerMIMEType(opts.Format))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("transferMode.dlna.org", "Streaming")
+	w.Header().Set("contentFeatures.dlna.org", transcodeContentFeatures(true))
+
+	http.ServeContent(w, r, "", stat.ModTime(), file)
+}
+
+// ActiveTranscodes returns progress for all running HLS and progressive transcodes
+func (h *StreamHandler) ActiveTranscodes() []TranscodeInfo {
+	var infos []TranscodeInfo
+
+	for _, s := range h.hlsManager.Sessions() {
+		if s.Progress != nil {
+			infos = append(infos, newTranscodeInfo(s.ID, s.MovieID, "hls", s.Progress))
+		}
+	}
+
+	h.mu.Lock()
+	for id, s := range h.progressive {
+		infos = append(infos, newTranscodeInfo(id, s.movieID, "progressive", s.progress))
+	}
+	h.mu.Unlock()
+
+	return infos
+}
+
+// writeTranscodeError reports a transcode start error with a matching HTTP status
+func writeTranscodeError(w http.ResponseWriter, prefix string, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrNoEncoder), errors.Is(err, ErrSubtitleBurnUnsupported):
+		status = http.StatusNotImplemented
+	case errors.Is(err, ErrSchedulerSaturated):
+		status = http.StatusServiceUnavailable
+		w.Header().Set("Retry-After", "10")
+	}
+	http.Error(w, fmt.Sprintf("%s: %v", prefix, err), status)
+}
+
+// HLSUsage returns HLS scratch storage usage
+func (h *StreamHandler) HLSUsage() HLSUsage {
+	return h.hlsManager.Usage()
+}
+
+// Transcoder returns the transcoder shared by all streams
+func (h *StreamHandler) Transcoder() *Transcoder {
+	return h.transcoder
+}
+
+// Cache returns the transcode cache, or nil when caching is disabled
+func (h *StreamHandler) Cache() *TranscodeCache {
+	return h.cache
+}
+
+// SchedulerStats returns current transcode slot usage
+func (h *StreamHandler) SchedulerStats() SchedulerStats {
+	return h.transcoder.SchedulerStats()
+}
+
+// Capabilities returns the probed ffmpeg capabilities
+func (h *StreamHandler) Capabilities() *Capabilities {
+	return h.transcoder.Capabilities()
+}
+
+// Features returns what this server can currently transcode
+func (h *StreamHandler) Features() Features {
+	return h.transcoder.Features()
 }
 
 // getContentType returns the MIME type for a video file
@@ -295,21 +604,47 @@ func (h *StreamHandler) getContentType(path string) string {
 	}
 }
 
+// resolveSubtitle looks up the subtitle selected by the subtitle_id or
+// subtitle_index (position in Movie.Subtitles) query param. Only the movie's
+// own subtitles can be selected, never arbitrary paths.
+func resolveSubtitle(r *http.Request, movie *library.Movie) (*library.Subtitle, error) {
+	q := r.URL.Query()
+
+	if id := q.Get("subtitle_id"); id != "" {
+		sub, ok := movie.FindSubtitle(id)
+		if !ok {
+			return nil, fmt.Errorf("subtitle not found: %s", id)
+		}
+		return &sub, nil
+	}
+
+	if s := q.Get("subtitle_index"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n >= len(movie.Subtitles) {
+			return nil, fmt.Errorf("subtitle not found: %s", s)
+		}
+		sub := movie.Subtitles[n]
+		return &sub, nil
+	}
+
+	return nil, nil
+}
+
 // GetStreamURL returns the URL for streaming a movie
-func (h *StreamHandler) GetStreamURL(baseURL, movieID string, transcode bool, subtitlePath string) string {
-	url := fmt.Sprintf("%s/stream/%s", baseURL, movieID)
+func (h *StreamHandler) GetStreamURL(baseURL, movieID string, transcode bool, subtitleID string) string {
+	streamURL := fmt.Sprintf("%s/stream/%s", baseURL, movieID)
 
 	params := []string{}
 	if transcode {
 		params = append(params, "transcode=1")
 	}
-	if subtitlePath != "" {
-		params = append(params, "subtitle="+subtitlePath)
+	if subtitleID != "" {
+		params = append(params, "subtitle_id="+url.QueryEscape(subtitleID))
 	}
 
 	if len(params) > 0 {
-		url += "?" + strings.Join(params, "&")
+		streamURL += "?" + strings.Join(params, "&")
 	}
 
-	return url
+	return streamURL
 }
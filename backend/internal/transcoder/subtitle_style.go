@@ -0,0 +1,130 @@
+package transcoder
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/wysentanu/dlna-movie-cast/internal/config"
+)
+
+// SubtitleStyle overrides the look and timing of burned-in subtitles. Zero
+// values keep the subtitle file's own style.
+type SubtitleStyle struct {
+	Font     string `json:"font,omitempty"`
+	Size     int    `json:"size,omitempty"`    // Font size on libass's 288-line canvas
+	Outline  int    `json:"outline,omitempty"` // Outline width
+	Color    string `json:"color,omitempty"`   // Text colour as #RRGGBB
+	MarginV  int    `json:"margin_v,omitempty"`
+	OffsetMs int    `json:"offset_ms,omitempty"` // Positive values show subtitles later
+}
+
+// defaultSubtitleStyle returns the server-wide style from the config
+func defaultSubtitleStyle(cfg *config.Config) SubtitleStyle {
+	return SubtitleStyle{
+		Font:    cfg.SubtitleFont,
+		Size:    cfg.SubtitleSize,
+		Outline: cfg.SubtitleOutline,
+		Color:   cfg.SubtitleColor,
+		MarginV: cfg.SubtitleMarginV,
+	}
+}
+
+// forceStyle returns the libass force_style override, or "" if there is none
+func (s SubtitleStyle) forceStyle() string {
+	var fields []string
+	if font := sanitizeFontName(s.Font); font != "" {
+		fields = append(fields, "FontName="+font)
+	}
+	if s.Size > 0 {
+		fields = append(fields, fmt.Sprintf("FontSize=%d", s.Size))
+	}
+	if s.Outline > 0 {
+		fields = append(fields, fmt.Sprintf("Outline=%d", s.Outline), "BorderStyle=1")
+	}
+	if color, ok := assColor(s.Color); ok {
+		fields = append(fields, "PrimaryColour="+color)
+	}
+	if s.MarginV > 0 {
+		fields = append(fields, fmt.Sprintf("MarginV=%d", s.MarginV))
+	}
+	return strings.Join(fields, ",")
+}
+
+// offsetSeconds returns the timing offset in seconds
+func (s SubtitleStyle) offsetSeconds() float64 {
+	return float64(s.OffsetMs) / 1000
+}
+
+// Encode adds the style to stream URL query params
+func (s SubtitleStyle) Encode(q url.Values) {
+	if s.Font != "" {
+		q.Set("sub_font", s.Font)
+	}
+	if s.Size > 0 {
+		q.Set("sub_size", strconv.Itoa(s.Size))
+	}
+	if s.Outline > 0 {
+		q.Set("sub_outline", strconv.Itoa(s.Outline))
+	}
+	if s.Color != "" {
+		q.Set("sub_color", s.Color)
+	}
+	if s.MarginV > 0 {
+		q.Set("sub_margin_v", strconv.Itoa(s.MarginV))
+	}
+	if s.OffsetMs != 0 {
+		q.Set("sub_offset", strconv.Itoa(s.OffsetMs))
+	}
+}
+
+// parseSubtitleStyleParams applies the sub_* query params over the defaults
+func parseSubtitleStyleParams(r *http.Request, style *SubtitleStyle) {
+	q := r.URL.Query()
+
+	if v := q.Get("sub_font"); v != "" {
+		style.Font = v
+	}
+	if n, err := strconv.Atoi(q.Get("sub_size")); err == nil && n > 0 {
+		style.Size = n
+	}
+	if n, err := strconv.Atoi(q.Get("sub_outline")); err == nil && n > 0 {
+		style.Outline = n
+	}
+	if v := q.Get("sub_color"); v != "" {
+		style.Color = v
+	}
+	if n, err := strconv.Atoi(q.Get("sub_margin_v")); err == nil && n > 0 {
+		style.MarginV = n
+	}
+	if n, err := strconv.Atoi(q.Get("sub_offset")); err == nil {
+		style.OffsetMs = n
+	}
+}
+
+// assColor converts #RRGGBB to the &HAABBGGRR form ASS styles use
+func assColor(s string) (string, bool) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 {
+		return "", false
+	}
+	if _, err := strconv.ParseUint(s, 16, 32); err != nil {
+		return "", false
+	}
+	s = strings.ToUpper(s)
+	return "&H00" + s[4:6] + s[2:4] + s[0:2], true
+}
+
+// sanitizeFontName drops characters that would break out of the filter
+// argument or the force_style list
+func sanitizeFontName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '\'', '"', '\\', ',', ':', ';', '=', '[', ']':
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(name))
+}
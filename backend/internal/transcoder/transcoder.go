@@ -4,31 +4,42 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"log"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/wysentanu/dlna-movie-cast/internal/config"
 	"github.com/wysentanu/dlna-movie-cast/internal/library"
+	"github.com/wysentanu/dlna-movie-cast/internal/subtitles"
 )
 
 // TranscodeOptions specifies transcoding parameters
 type TranscodeOptions struct {
 	// Video settings
 	VideoCodec   string // Target video codec (h264, hevc)
+	CopyVideo    bool   // Pass the video stream through untouched
 	VideoBitrate string // Video bitrate (e.g., "8M")
 	Width        int    // Target width (0 = auto)
 	Height       int    // Target height (0 = auto)
 
 	// Audio settings
-	AudioCodec   string // Target audio codec (aac, mp3)
-	AudioBitrate string // Audio bitrate (e.g., "192k")
+	AudioCodec    string // Target audio codec (aac, ac3, or copy to pass the source through)
+	AudioBitrate  string // Audio bitrate (e.g., "192k")
+	AudioChannels int    // Downmix to this many channels (0 = keep source)
+	Loudnorm      bool   // EBU R128 loudness normalization
+	NightMode     bool   // Compress dynamic range for quiet listening
 
 	// Subtitle
-	SubtitlePath  string // Path to external subtitle file to burn
-	SubtitleIndex int    // Index of embedded subtitle track (-1 = none)
+	SubtitlePath        string // Path to external subtitle file to burn
+	SubtitleIndex       int    // Index of embedded subtitle track (-1 = none)
+	ForcedSubtitlesOnly bool   // Only show forced captions of image-based subtitles
+	SubtitleStyle       SubtitleStyle
 
 	// Seeking
 	StartTime int // Start time in seconds
@@ -37,9 +48,12 @@ type TranscodeOptions struct {
 	// Hardware acceleration
 	UseHardwareAccel bool // Use hardware encoder if available
 
+	// Scheduling
+	Priority Priority // Interactive requests preempt background ones
+
 	// Output
-	Format     string // "mp4" or "hls"
-	OutputPath string // Output directory for HLS
+	Format     string // "mp4", "mpegts" or "hls"
+	OutputPath string // Output directory for HLS, or output file (instead of stdout) otherwise
 }
 
 // DefaultOptions returns default transcoding options
@@ -50,79 +64,232 @@ func DefaultOptions(cfg *config.Config) TranscodeOptions {
 		AudioCodec:       "aac",
 		AudioBitrate:     cfg.AudioBitrate,
 		SubtitleIndex:    -1,
-		UseHardwareAccel: isHardwareAccelAvailable(),
+		SubtitleStyle:    defaultSubtitleStyle(cfg),
+		UseHardwareAccel: true,
 	}
 }
 
-// isHardwareAccelAvailable checks if hardware acceleration is available
-func isHardwareAccelAvailable() bool {
-	// Currently checks for Rockchip MPP (/dev/mpp_service)
-	// Can be extended to support VAAPI, NVENC, etc.
-	cmd := exec.Command("test", "-e", "/dev/mpp_service")
-	if err := cmd.Run(); err != nil {
-		return false
+// applySubtitle selects a subtitle for burn-in; nil leaves subtitles off
+func (opts *TranscodeOptions) applySubtitle(sub *library.Subtitle) {
+	if sub == nil {
+		return
+	}
+	if sub.IsExternal {
+		opts.SubtitlePath = sub.FilePath
+	} else {
+		opts.SubtitleIndex = sub.Index
 	}
-	return true
 }
 
 // Transcoder handles video transcoding with FFmpeg
 type Transcoder struct {
-	config *config.Config
+	config    *config.Config
+	backend   EncoderBackend
+	caps      *Capabilities
+	scheduler *Scheduler
 }
 
-// NewTranscoder creates a new transcoder instance
+// NewTranscoder creates a new transcoder instance and probes the ffmpeg build
 func NewTranscoder(cfg *config.Config) *Transcoder {
-	return &Transcoder{
-		config: cfg,
+	caps, err := ProbeCapabilities(context.Background(), cfg.FFmpegPath)
+	if err != nil {
+		log.Printf("Warning: could not probe ffmpeg capabilities: %v", err)
+	} else {
+		log.Printf("ffmpeg %s: %d encoders, %d filters, hwaccels %v",
+			caps.Version, len(caps.Encoders), len(caps.Filters), mapKeys(caps.HWAccels))
+	}
+
+	backend := SelectEncoderBackend(cfg)
+	if !caps.SupportsBackend(backend) {
+		log.Printf("ffmpeg lacks %s support, falling back to software encoding", backend.Name())
+		backend = softwareBackend{}
+	}
+	log.Printf("Video encoder backend: %s", backend.Name())
+
+	t := &Transcoder{
+		config:  cfg,
+		backend: backend,
+		caps:    caps,
+		scheduler: NewScheduler(cfg.MaxTranscodes, cfg.MaxHWTranscodes,
+			cfg.TranscodeQueueTimeout, cfg.TranscodeSaturation == "degrade"),
+	}
+
+	features := t.Features()
+	if !features.H264 {
+		log.Printf("Warning: %v; transcoding is disabled", noEncoderError("h264"))
+	}
+	if !features.SubtitleBurnIn {
+		log.Printf("Warning: %v; subtitle burn-in is disabled", ErrSubtitleBurnUnsupported)
+	}
+
+	return t
+}
+
+// Capabilities returns the probed ffmpeg capabilities
+func (t *Transcoder) Capabilities() *Capabilities {
+	return t.caps
+}
+
+// Features returns what this server can currently transcode
+func (t *Transcoder) Features() Features {
+	return t.caps.Features(t.backend)
+}
+
+// SchedulerStats returns current transcode slot usage
+func (t *Transcoder) SchedulerStats() SchedulerStats {
+	return t.scheduler.Stats()
+}
+
+// acquireSlot waits for a transcode slot and applies degradation to opts.
+// cancel is called if a higher-priority request preempts this one.
+func (t *Transcoder) acquireSlot(ctx context.Context, opts *TranscodeOptions, cancel func()) (*Slot, error) {
+	slot, err := t.scheduler.Acquire(ctx, SlotRequest{
+		Hardware: t.encoderBackend(*opts).Name() != softwareBackend{}.Name(),
+		Priority: opts.Priority,
+		Cancel:   cancel,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if slot.Degraded && (opts.Height == 0 || opts.Height > t.config.DegradedHeight) {
+		opts.Width = 0
+		opts.Height = t.config.DegradedHeight
 	}
+	return slot, nil
+}
+
+// Backend returns the encoder backend used for hardware-accelerated transcodes
+func (t *Transcoder) Backend() EncoderBackend {
+	return t.backend
+}
+
+// encoderBackend returns the backend to use for a given set of options
+func (t *Transcoder) encoderBackend(opts TranscodeOptions) EncoderBackend {
+	if opts.UseHardwareAccel && !opts.CopyVideo && t.backend != nil {
+		return t.backend
+	}
+	return softwareBackend{}
 }
 
 // Transcode starts transcoding a movie and returns a reader for the output
-func (t *Transcoder) Transcode(ctx context.Context, movie *library.Movie, opts TranscodeOptions) (io.ReadCloser, error) {
+// along with a tracker for ffmpeg's progress and stderr
+func (t *Transcoder) Transcode(ctx context.Context, movie *library.Movie, opts TranscodeOptions) (io.ReadCloser, *ProgressTracker, error) {
+	if err := t.applyCapabilities(movie, &opts); err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	slot, err := t.acquireSlot(ctx, &opts, cancel)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
 	args := t.buildFFmpegArgs(movie, opts)
 
 	cmd := exec.CommandContext(ctx, t.config.FFmpegPath, args...)
 
-	// Capture stderr for error logging
+	// Capture stderr for progress and error logging
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create stderr pipe: %w", err)
+		slot.Release()
+		cancel()
+		return nil, nil, fmt.Errorf("failed to create stderr pipe: %w", err)
 	}
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+		slot.Release()
+		cancel()
+		return nil, nil, fmt.Errorf("failed to create stdout pipe: %w", err)
 	}
 
 	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
+		slot.Release()
+		cancel()
+		return nil, nil, fmt.Errorf("failed to start ffmpeg: %w", err)
 	}
 
-	// Drain stderr to prevent blocking
+	// Parse stderr (this also keeps ffmpeg from blocking on it)
+	progress := NewProgressTracker()
+	progress.setDegraded(slot.Degraded)
+	stderrDone := make(chan struct{})
 	go func() {
-		io.Copy(io.Discard, stderr)
+		progress.Consume(stderr)
+		close(stderrDone)
 	}()
 
 	// Wrap the stdout in a custom reader that waits for the process to exit
 	return &transcodeReader{
 		ReadCloser: stdout,
 		cmd:        cmd,
-	}, nil
+		progress:   progress,
+		stderrDone: stderrDone,
+		slot:       slot,
+		cancel:     cancel,
+	}, progress, nil
+}
+
+// applyCapabilities degrades options the installed ffmpeg can't honour, or
+// refuses them when there is no sensible fallback
+func (t *Transcoder) applyCapabilities(movie *library.Movie, opts *TranscodeOptions) error {
+	if opts.CopyVideo {
+		return nil // No video encoder or filters involved
+	}
+
+	backend := t.encoderBackend(*opts)
+	requested := opts.VideoCodec
+
+	if isHEVC(opts.VideoCodec) && !t.caps.HasEncoder(backend.Encoder(opts.VideoCodec)) {
+		log.Printf("ffmpeg has no %s encoder, falling back to H.264", backend.Encoder(opts.VideoCodec))
+		opts.VideoCodec = "h264"
+	}
+
+	if !t.caps.HasEncoder(backend.Encoder(opts.VideoCodec)) {
+		sw := softwareBackend{}
+		if backend.Name() == sw.Name() || !t.caps.HasEncoder(sw.Encoder(opts.VideoCodec)) {
+			return noEncoderError(requested)
+		}
+		opts.UseHardwareAccel = false
+	}
+
+	if _, ok := bitmapSubtitle(movie, *opts); ok {
+		if !t.caps.HasFilter("overlay") {
+			return ErrSubtitleBurnUnsupported
+		}
+	} else if (opts.SubtitlePath != "" || opts.SubtitleIndex >= 0) && !t.caps.HasFilter("subtitles") {
+		return ErrSubtitleBurnUnsupported
+	}
+
+	return nil
+}
+
+// noEncoderError wraps ErrNoEncoder with the codecs that were tried; HEVC
+// requests fall back to H.264 first
+func noEncoderError(codec string) error {
+	if isHEVC(codec) {
+		return fmt.Errorf("%w for HEVC or H.264", ErrNoEncoder)
+	}
+	return fmt.Errorf("%w for H.264", ErrNoEncoder)
 }
 
 // buildFFmpegArgs constructs the FFmpeg command arguments
 func (t *Transcoder) buildFFmpegArgs(movie *library.Movie, opts TranscodeOptions) []string {
 	var args []string
+	backend := t.encoderBackend(opts)
 
-	// Global options
-	args = append(args, "-hide_banner", "-loglevel", "warning")
+	// Global options; progress blocks go to stderr alongside warnings
+	args = append(args, "-hide_banner", "-loglevel", "warning", "-nostats", "-progress", "pipe:2")
 
 	// Hardware acceleration for decoding (if available)
-	if opts.UseHardwareAccel {
-		args = append(args,
-			"-hwaccel", "rkmpp",
-			"-hwaccel_output_format", "drm_prime",
-		)
+	if !opts.CopyVideo {
+		args = append(args, backend.DecodeArgs()...)
+	}
+
+	// Have the PGS or VobSub decoder drop everything but forced captions
+	if _, ok := bitmapSubtitle(movie, opts); ok && opts.ForcedSubtitlesOnly {
+		args = append(args, "-forced_subs_only", "1")
 	}
 
 	// Seeking (before input for faster seeking)
@@ -138,28 +305,138 @@ func (t *Transcoder) buildFFmpegArgs(movie *library.Movie, opts TranscodeOptions
 		args = append(args, "-t", strconv.Itoa(opts.Duration))
 	}
 
+	// First video and audio streams, matching what the library probed
+	if opts.CopyVideo {
+		args = append(args, "-map", "0:v:0", "-c:v", "copy")
+	} else {
+		args = append(args, t.videoEncodeArgs(movie, opts, backend)...)
+	}
+	args = append(args, "-map", "0:a:0?")
+
+	// Audio codec settings
+	args = append(args, audioArgs(movie, opts)...)
+
+	// Output format
+	if opts.Format == "hls" {
+		// HLS specific options
+		segmentFilename := filepath.Join(opts.OutputPath, "segment_%03d.ts")
+		playlistFilename := filepath.Join(opts.OutputPath, "playlist.m3u8")
+
+		// Force keyframe at segment boundaries for clean cuts
+		gop := strconv.Itoa(gopSize(movie))
+		args = append(args,
+			"-g", gop, // GOP size matching segment length
+			"-keyint_min", gop, // Minimum keyframe interval
+			"-sc_threshold", "0", // Disable scene change detection for consistent segments
+			"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", hlsSegmentSeconds),
+		)
+
+		// Start timestamps where the WebVTT renditions' timestamp map says
+		tsOffset := float64(subtitles.HLSTimestampOffset) / 90000
+		args = append(args,
+			"-muxdelay", "0",
+			"-muxpreload", "0",
+			"-output_ts_offset", strconv.FormatFloat(tsOffset, 'f', -1, 64),
+		)
+
+		args = append(args,
+			"-f", "hls",
+			"-hls_time", strconv.Itoa(hlsSegmentSeconds), // 10 second segments for better buffering
+			"-hls_list_size", "0", // Keep all segments in playlist
+			"-hls_segment_filename", segmentFilename,
+			"-hls_flags", "independent_segments", // Each segment is independently decodable
+			"-hls_playlist_type", "event", // Growing playlist (not VOD)
+			"-start_number", "0",
+			playlistFilename,
+		)
+	} else if opts.OutputPath != "" {
+		// Seekable file written by a background job
+		if opts.Format == ContainerMPEGTS {
+			args = append(args, "-f", "mpegts")
+		} else {
+			args = append(args, "-movflags", "+faststart", "-f", "mp4")
+		}
+		args = append(args, "-y", opts.OutputPath)
+	} else if opts.Format == ContainerMPEGTS {
+		// MPEG-TS for renderers that reject fragmented MP4
+		args = append(args,
+			"-mpegts_flags", "+resend_headers", // Repeat PAT/PMT so late joiners can sync
+			"-f", "mpegts",
+			"pipe:1", // Output to stdout
+		)
+	} else {
+		// Output format for direct streaming (MP4)
+		args = append(args,
+			"-movflags", "frag_keyframe+empty_moov+faststart",
+			"-f", "mp4",
+			"pipe:1", // Output to stdout
+		)
+	}
+
+	return args
+}
+
+// videoEncodeArgs builds the video mapping, filter chain and encoder options
+func (t *Transcoder) videoEncodeArgs(movie *library.Movie, opts TranscodeOptions, backend EncoderBackend) []string {
+	var args []string
+
 	// Build video filter chain
 	var videoFilters []string
 
-	if opts.UseHardwareAccel {
-		// Download from GPU for subtitle processing
-		videoFilters = append(videoFilters, "hwdownload", "format=nv12")
+	// Interlaced sources are deinterlaced, on the GPU if the backend can
+	hwDeinterlace, swDeinterlace := t.deinterlaceFilters(movie, backend)
+	videoFilters = append(videoFilters, hwDeinterlace...)
+
+	// HDR sources are tone mapped to SDR, on the GPU if the backend can
+	hwToneMap, swToneMap := t.toneMapFilters(movie, backend)
+	videoFilters = append(videoFilters, hwToneMap...)
+
+	// Download from GPU for subtitle processing. 10-bit frames leave the
+	// GPU as P010 unless it already tone mapped them to 8 bits.
+	if swToneMap != nil || (hwToneMap == nil && movie.IsHighBitDepth()) {
+		videoFilters = append(videoFilters, highBitDepthDownloadFilters(backend)...)
+	} else {
+		videoFilters = append(videoFilters, backend.DownloadFilters()...)
 	}
+	videoFilters = append(videoFilters, swDeinterlace...)
+	videoFilters = append(videoFilters, swToneMap...)
 
-	// Subtitle burning
+	// Subtitle burning; image-based subtitles are overlaid further down
+	bitmapSub, isBitmap := bitmapSubtitle(movie, opts)
+	var subtitleFilter string
 	if opts.SubtitlePath != "" {
-		// Escape the subtitle path for FFmpeg filter syntax
-		escapedPath := strings.ReplaceAll(opts.SubtitlePath, ":", "\\:")
-		escapedPath = strings.ReplaceAll(escapedPath, "'", "\\'")
-		escapedPath = strings.ReplaceAll(escapedPath, "[", "\\[")
-		escapedPath = strings.ReplaceAll(escapedPath, "]", "\\]")
-		videoFilters = append(videoFilters, fmt.Sprintf("subtitles='%s'", escapedPath))
-	} else if opts.SubtitleIndex >= 0 {
+		subtitleFilter = "subtitles=filename=" + escapeFilterPath(opts.SubtitlePath)
+
+		// Legacy code pages have to be converted, libass assumes UTF-8
+		if sub, ok := movie.ExternalSubtitle(opts.SubtitlePath); ok && !library.IsUTF8Charset(sub.Charset) {
+			subtitleFilter += ":charenc=" + sub.Charset
+		}
+	} else if opts.SubtitleIndex >= 0 && !isBitmap {
 		// Burn embedded subtitle
-		videoFilters = append(videoFilters, fmt.Sprintf("subtitles='%s':si=%d",
-			strings.ReplaceAll(movie.FilePath, "'", "\\'"), opts.SubtitleIndex))
+		subtitleFilter = fmt.Sprintf("subtitles=filename=%s:si=%d",
+			escapeFilterPath(movie.FilePath), movie.SubtitleOrdinal(opts.SubtitleIndex))
+	}
+	if subtitleFilter != "" {
+		if style := opts.SubtitleStyle.forceStyle(); style != "" {
+			subtitleFilter += fmt.Sprintf(":force_style='%s'", style)
+		}
+
+		// Shift the frames rather than the subtitles: libass picks the
+		// events for each frame's timestamp
+		if offset := opts.SubtitleStyle.offsetSeconds(); offset != 0 {
+			videoFilters = append(videoFilters,
+				fmt.Sprintf("setpts=PTS-%g/TB", offset),
+				subtitleFilter,
+				fmt.Sprintf("setpts=PTS+%g/TB", offset))
+		} else {
+			videoFilters = append(videoFilters, subtitleFilter)
+		}
 	}
 
+	// Filters after this point run on the composited frame
+	preFilters := videoFilters
+	videoFilters = nil
+
 	// Scaling
 	if opts.Width > 0 || opts.Height > 0 {
 		w := opts.Width
@@ -174,110 +451,285 @@ func (t *Transcoder) buildFFmpegArgs(movie *library.Movie, opts TranscodeOptions
 	}
 
 	// Upload back to GPU for hardware encoding
-	if opts.UseHardwareAccel {
-		videoFilters = append(videoFilters, "format=nv12", "hwupload")
-	}
+	videoFilters = append(videoFilters, backend.UploadFilters()...)
 
 	// Apply video filter chain
-	if len(videoFilters) > 0 {
-		args = append(args, "-vf", strings.Join(videoFilters, ","))
+	if isBitmap {
+		args = append(args,
+			"-filter_complex", overlayFilterGraph(bitmapSub.Index, opts.SubtitleStyle.offsetSeconds(), preFilters, videoFilters),
+			"-map", "[vout]",
+		)
+	} else {
+		args = append(args, "-map", "0:v:0")
+		if all := append(preFilters, videoFilters...); len(all) > 0 {
+			args = append(args, "-vf", strings.Join(all, ","))
+		}
 	}
 
 	// Video codec settings
-	if opts.UseHardwareAccel {
-		// Use hardware encoder (Rockchip MPP)
-		switch opts.VideoCodec {
-		case "hevc", "h265":
-			args = append(args, "-c:v", "hevc_rkmpp")
-		default:
-			args = append(args, "-c:v", "h264_rkmpp")
+	args = append(args, "-c:v", backend.Encoder(opts.VideoCodec))
+	args = append(args, backend.EncoderArgs(opts.VideoCodec, t.config.Preset)...)
+
+	// Video bitrate
+	args = append(args, backend.RateControlArgs(opts.VideoBitrate)...)
+
+	return args
+}
+
+// escapeFilterPath escapes a path for use as a filter option value. The
+// option parser unescapes it once, and the filter graph parser before it
+// once more, so both levels are escaped.
+func escapeFilterPath(path string) string {
+	option := backslashEscape(path, `\':`)
+	return backslashEscape(option, `\'[],;`)
+}
+
+// backslashEscape prefixes each of the special characters with a backslash
+func backslashEscape(s, special string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(special, r) {
+			b.WriteByte('\\')
 		}
-	} else {
-		// Software encoding (default)
-		switch opts.VideoCodec {
-		case "hevc", "h265":
-			args = append(args, "-c:v", "libx265", "-pix_fmt", "yuv420p")
-		default:
-			args = append(args,
-				"-c:v", "libx264",
-				"-preset", t.config.Preset,
-				"-pix_fmt", "yuv420p",
-				"-profile:v", "high",
-				"-level:v", "4.0",
-				"-colorspace", "bt709",
-				"-color_primaries", "bt709",
-				"-color_trc", "bt709",
-				"-color_range", "tv",
-			)
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// bitmapSubtitle returns the embedded subtitle selected for burn-in when it
+// is image based and has to be overlaid rather than rendered by libass
+func bitmapSubtitle(movie *library.Movie, opts TranscodeOptions) (library.Subtitle, bool) {
+	if opts.SubtitlePath != "" || opts.SubtitleIndex < 0 {
+		return library.Subtitle{}, false
+	}
+	sub, ok := movie.EmbeddedSubtitle(opts.SubtitleIndex)
+	if !ok || !sub.IsBitmap() {
+		return library.Subtitle{}, false
+	}
+	return sub, true
+}
+
+// overlayFilterGraph composites an image-based subtitle stream onto the
+// video. The subtitle canvas is scaled to the decoded frame before the
+// overlay (DVD subtitles are authored at 720x480/576 and Blu-ray ones at the
+// disc resolution regardless of cropping), so the later scale to the output
+// resolution resizes picture and captions together.
+func overlayFilterGraph(streamIndex int, offset float64, pre, post []string) string {
+	chain := func(filters []string) string {
+		if len(filters) == 0 {
+			return "null"
 		}
+		return strings.Join(filters, ",")
 	}
 
-	// Video bitrate
-	args = append(args, "-b:v", opts.VideoBitrate)
+	// A positive offset delays the captions
+	var shift []string
+	if offset != 0 {
+		shift = []string{fmt.Sprintf("setpts=PTS+%g/TB", offset)}
+	}
 
-	// Audio codec settings
-	args = append(args, "-c:a", opts.AudioCodec, "-b:a", opts.AudioBitrate)
+	return fmt.Sprintf(
+		"[0:v:0]%s[base];"+
+			"[0:%d]%s[shifted];"+
+			"[shifted][base]scale2ref=w=main_w:h=main_h[sub][ref];"+
+			"[ref][sub]overlay=eof_action=pass:repeatlast=0,%s[vout]",
+		chain(pre), streamIndex, chain(shift), chain(post))
+}
 
-	// Output format
-	if opts.Format == "hls" {
-		// HLS specific options
-		segmentFilename := filepath.Join(opts.OutputPath, "segment_%03d.ts")
-		playlistFilename := filepath.Join(opts.OutputPath, "playlist.m3u8")
+// hlsSegmentSeconds is the HLS segment duration
+const hlsSegmentSeconds = 10
 
-		// Force keyframe at segment boundaries for clean cuts
-		// GOP size = framerate * segment_time (assume 30fps * 10s = 300)
-		args = append(args,
-			"-g", "300", // GOP size matching segment length
-			"-keyint_min", "300", // Minimum keyframe interval
-			"-sc_threshold", "0", // Disable scene change detection for consistent segments
-		)
+// gopSize returns the keyframe interval matching one HLS segment at the
+// source frame rate, assuming 30 fps when it wasn't probed
+func gopSize(movie *library.Movie) int {
+	fps := movie.FrameRate
+	if fps <= 0 || fps > 240 {
+		fps = 30
+	}
+	return int(math.Round(fps * hlsSegmentSeconds))
+}
 
-		args = append(args,
-			"-f", "hls",
-			"-hls_time", "10", // 10 second segments for better buffering
-			"-hls_list_size", "0", // Keep all segments in playlist
-			"-hls_segment_filename", segmentFilename,
-			"-hls_flags", "independent_segments", // Each segment is independently decodable
-			"-hls_playlist_type", "event", // Growing playlist (not VOD)
-			"-start_number", "0",
-			playlistFilename,
-		)
-	} else {
-		// Output format for direct streaming (MP4)
-		args = append(args,
-			"-movflags", "frag_keyframe+empty_moov+faststart",
-			"-f", "mp4",
-			"pipe:1", // Output to stdout
-		)
+// deinterlaceFilters returns the filters that deinterlace an interlaced
+// source, one output frame per input frame
+func (t *Transcoder) deinterlaceFilters(movie *library.Movie, backend EncoderBackend) (hardware, software []string) {
+	if !movie.IsInterlaced() {
+		return nil, nil
 	}
 
-	return args
+	if hw := backend.DeinterlaceFilters(); hw != nil && t.caps.HasFilter(filterName(hw[0])) {
+		return hw, nil
+	}
+
+	// bwdif looks better than yadif at a similar cost, but is newer
+	if t.caps.HasFilter("bwdif") {
+		return nil, []string{"bwdif=mode=send_frame:deint=interlaced"}
+	}
+	return nil, []string{"yadif=mode=send_frame:deint=interlaced"}
+}
+
+// toneMapFilters returns the filters that convert an HDR source to SDR. Every
+// encoder profile outputs 8-bit BT.709, so HDR is never passed through.
+func (t *Transcoder) toneMapFilters(movie *library.Movie, backend EncoderBackend) (hardware, software []string) {
+	if !movie.IsHDR() {
+		return nil, nil
+	}
+
+	algorithm := t.config.ToneMapAlgorithm
+	if hw := backend.ToneMapFilters(algorithm); hw != nil && t.caps.HasFilter(filterName(hw[0])) {
+		return hw, nil
+	}
+
+	if !t.caps.HasFilter("zscale") || !t.caps.HasFilter("tonemap") {
+		log.Printf("Warning: ffmpeg lacks zscale or tonemap; %q will look washed out", movie.Title)
+		return nil, nil
+	}
+	return nil, softwareToneMapFilters(algorithm)
+}
+
+// softwareToneMapFilters converts to linear light, tone maps in floating
+// point, then converts to BT.709
+func softwareToneMapFilters(algorithm string) []string {
+	return []string{
+		"zscale=t=linear:npl=100",
+		"format=gbrpf32le",
+		"zscale=p=bt709",
+		fmt.Sprintf("tonemap=tonemap=%s:desat=0", algorithm),
+		"zscale=t=bt709:m=bt709:r=tv",
+		"format=yuv420p",
+	}
+}
+
+// highBitDepthDownloadFilters downloads decoded frames at 10 bits, the
+// format 10-bit surfaces have, which also gives software tone mapping the
+// full range
+func highBitDepthDownloadFilters(backend EncoderBackend) []string {
+	filters := backend.DownloadFilters()
+	out := make([]string, len(filters))
+	for i, f := range filters {
+		if f == "format=nv12" {
+			f = "format=p010le"
+		}
+		out[i] = f
+	}
+	return out
+}
+
+// filterName returns the filter name from a "name=options" filter spec
+func filterName(spec string) string {
+	name, _, _ := strings.Cut(spec, "=")
+	return name
 }
 
 // StartHLSTranscode starts an HLS transcoding session
-func (t *Transcoder) StartHLSTranscode(ctx context.Context, movie *library.Movie, opts TranscodeOptions) (*os.Process, error) {
+func (t *Transcoder) StartHLSTranscode(ctx context.Context, movie *library.Movie, opts TranscodeOptions) (*os.Process, *ProgressTracker, error) {
 	opts.Format = "hls"
+	if err := t.applyCapabilities(movie, &opts); err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	slot, err := t.acquireSlot(ctx, &opts, cancel)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
 	args := t.buildFFmpegArgs(movie, opts)
 
 	cmd := exec.CommandContext(ctx, t.config.FFmpegPath, args...)
 
-	// Capture stderr for error logging
+	// Capture stderr for progress and error logging
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create stderr pipe: %w", err)
+		slot.Release()
+		cancel()
+		return nil, nil, fmt.Errorf("failed to create stderr pipe: %w", err)
 	}
 
 	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
+		slot.Release()
+		cancel()
+		return nil, nil, fmt.Errorf("failed to start ffmpeg: %w", err)
 	}
 
-	// Drain stderr and wait for process to finish
+	// Parse stderr, wait for process to finish, then free the slot
+	progress := NewProgressTracker()
+	progress.setDegraded(slot.Degraded)
 	go func() {
-		io.Copy(io.Discard, stderr)
-		cmd.Wait()
+		defer cancel()
+		defer slot.Release()
+
+		progress.Consume(stderr)
+		err := cmd.Wait()
+		progress.finish(err)
+		if err != nil && !progress.Stats().Finished {
+			logTranscodeFailure(movie, err, progress)
+		}
 	}()
 
-	return cmd.Process, nil
+	return cmd.Process, progress, nil
+}
+
+// TranscodeToFile runs a transcode to opts.OutputPath and blocks until ffmpeg
+// exits. progress is updated while it runs. If an interactive request
+// preempts it, ErrPreempted is returned.
+func (t *Transcoder) TranscodeToFile(ctx context.Context, movie *library.Movie, opts TranscodeOptions, progress *ProgressTracker) error {
+	if opts.OutputPath == "" || opts.Format == "hls" {
+		return fmt.Errorf("transcode to file needs an output path and a progressive format")
+	}
+	if err := t.applyCapabilities(movie, &opts); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	slot, err := t.acquireSlot(ctx, &opts, cancel)
+	if err != nil {
+		return err
+	}
+	defer slot.Release()
+	progress.setDegraded(slot.Degraded)
+
+	args := t.buildFFmpegArgs(movie, opts)
+	cmd := exec.CommandContext(ctx, t.config.FFmpegPath, args...)
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stderr pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+
+	progress.Consume(stderr)
+	err = cmd.Wait()
+	progress.finish(err)
+
+	switch {
+	case slot.Preempted():
+		return ErrPreempted
+	case err != nil:
+		if ctx.Err() == nil {
+			logTranscodeFailure(movie, err, progress)
+		}
+		return fmt.Errorf("ffmpeg failed: %w", err)
+	case !progress.Stats().Finished:
+		return fmt.Errorf("ffmpeg exited before the end of the input")
+	}
+	return nil
+}
+
+// logTranscodeFailure logs why ffmpeg exited along with its last stderr lines
+func logTranscodeFailure(movie *library.Movie, err error, progress *ProgressTracker) {
+	lines := progress.RecentLog()
+	if len(lines) == 0 {
+		return // Killed without complaint, e.g. by session cleanup
+	}
+	if len(lines) > 5 {
+		lines = lines[len(lines)-5:]
+	}
+	log.Printf("ffmpeg exited for %q: %v\n\t%s", movie.Title, err, strings.Join(lines, "\n\t"))
 }
 
 // GetDirectStreamURL returns a URL for direct streaming (no transcode)
@@ -301,22 +753,42 @@ func (t *Transcoder) NeedsTranscode(movie *library.Movie, burnSubtitle bool) boo
 	return !codecCompatible
 }
 
+// mapKeys returns the keys of a set in sorted order
+func mapKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // transcodeReader wraps the FFmpeg stdout and ensures cleanup
 type transcodeReader struct {
 	io.ReadCloser
-	cmd *exec.Cmd
+	cmd        *exec.Cmd
+	progress   *ProgressTracker
+	stderrDone chan struct{}
+	slot       *Slot
+	cancel     context.CancelFunc
+	closeOnce  sync.Once
+	closeErr   error
 }
 
 func (r *transcodeReader) Close() error {
-	err := r.ReadCloser.Close()
+	r.closeOnce.Do(func() {
+		r.closeErr = r.ReadCloser.Close()
 
-	// Kill the process if still running
-	if r.cmd.Process != nil {
-		r.cmd.Process.Kill()
-	}
-
-	// Wait for the process to exit
-	r.cmd.Wait()
+		// Kill the process if still running
+		if r.cmd.Process != nil {
+			r.cmd.Process.Kill()
+		}
 
-	return err
+		// Wait for stderr to drain, then for the process to exit
+		<-r.stderrDone
+		r.progress.finish(r.cmd.Wait())
+		r.slot.Release()
+		r.cancel()
+	})
+	return r.closeErr
 }
@@ -0,0 +1,199 @@
+//go:build linux
+
+package watcher
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyMask selects the events that can change the library. IN_MODIFY
+// keeps restarting the debounce while a download is still being written.
+const inotifyMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE
+
+// Filesystem magic numbers from statfs(2) for mounts whose remote changes
+// raise no inotify events
+const (
+	nfsSuperMagic  = 0x6969
+	smbSuperMagic  = 0x517B
+	cifsSuperMagic = 0xFF534D42
+	smb2SuperMagic = 0xFE534D42
+	fuseSuperMagic = 0x65735546
+	v9fsSuperMagic = 0x01021997
+	afsSuperMagic  = 0x5346414F
+	cephSuperMagic = 0x00C36400
+)
+
+// isNetworkFS reports whether path is on a network or FUSE filesystem
+func isNetworkFS(path string) bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return false
+	}
+	switch uint32(st.Type) {
+	case nfsSuperMagic, smbSuperMagic, cifsSuperMagic, smb2SuperMagic,
+		fuseSuperMagic, v9fsSuperMagic, afsSuperMagic, cephSuperMagic:
+		return true
+	}
+	return false
+}
+
+// inotifySource watches every folder below a media root with inotify
+type inotifySource struct {
+	file   *os.File
+	fd     int
+	match  func(string) bool
+	events chan<- string
+
+	mu      sync.Mutex
+	watches map[int32]string // Watch descriptor to folder
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newInotifySource adds watches for root and its folders. It fails when
+// inotify is unavailable or the watch limit is reached.
+func newInotifySource(root string, match func(string) bool, events chan<- string) (source, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	// A non-blocking descriptor goes through the runtime poller, so Close
+	// interrupts a pending Read
+	s := &inotifySource{
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		fd:      fd,
+		match:   match,
+		events:  events,
+		watches: make(map[int32]string),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	if err := s.addTree(root); err != nil {
+		s.file.Close()
+		return nil, err
+	}
+
+	go s.read()
+	return s, nil
+}
+
+// Close removes the watches and stops reading events
+func (s *inotifySource) Close() error {
+	close(s.stop)
+	err := s.file.Close()
+	<-s.done
+	return err
+}
+
+// addTree watches dir and every folder below it. Only a failure on dir
+// itself, or running out of watches, is an error.
+func (s *inotifySource) addTree(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			return nil
+		}
+		if !info.IsDir() {
+			return nil
+		}
+
+		wd, err := syscall.InotifyAddWatch(s.fd, path, inotifyMask)
+		if err != nil {
+			if path == dir || err == syscall.ENOSPC {
+				return err
+			}
+			return nil
+		}
+
+		s.mu.Lock()
+		s.watches[int32(wd)] = path
+		s.mu.Unlock()
+		return nil
+	})
+}
+
+// read decodes events until the source is closed
+func (s *inotifySource) read() {
+	defer close(s.done)
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := s.file.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			name := buf[nameStart : nameStart+int(event.Len)]
+			offset = nameStart + int(event.Len)
+
+			if !s.handle(event, string(bytes.TrimRight(name, "\x00"))) {
+				return
+			}
+		}
+	}
+}
+
+// handle turns one event into a path for the watcher, watching folders that
+// appear. It returns false once the source is closed.
+func (s *inotifySource) handle(event *syscall.InotifyEvent, name string) bool {
+	s.mu.Lock()
+	dir, ok := s.watches[event.Wd]
+	if event.Mask&syscall.IN_IGNORED != 0 {
+		delete(s.watches, event.Wd)
+	}
+	s.mu.Unlock()
+
+	if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+		// Events were lost; have the watcher re-check every watched folder
+		s.mu.Lock()
+		var dirs []string
+		for _, d := range s.watches {
+			dirs = append(dirs, d)
+		}
+		s.mu.Unlock()
+		for _, d := range dirs {
+			if !s.send(d) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if !ok || name == "" {
+		return true
+	}
+	path := filepath.Join(dir, name)
+
+	isDir := event.Mask&syscall.IN_ISDIR != 0
+	if isDir && event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+		s.addTree(path)
+	}
+	if !isDir && !s.match(path) {
+		return true
+	}
+	return s.send(path)
+}
+
+// send reports a path, giving up when the source is closed
+func (s *inotifySource) send(path string) bool {
+	select {
+	case s.events <- path:
+		return true
+	case <-s.stop:
+		return false
+	}
+}
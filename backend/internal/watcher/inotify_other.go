@@ -0,0 +1,16 @@
+//go:build !linux
+
+package watcher
+
+import "errors"
+
+// isNetworkFS reports whether path is on a network filesystem; without
+// inotify every root is polled, so it doesn't matter here
+func isNetworkFS(path string) bool {
+	return false
+}
+
+// newInotifySource is only available on Linux
+func newInotifySource(root string, match func(string) bool, events chan<- string) (source, error) {
+	return nil, errors.New("inotify is only supported on Linux")
+}
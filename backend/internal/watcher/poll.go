@@ -0,0 +1,112 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// fileState is what polling compares between walks
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// pollSource walks a media root at a fixed interval and reports files that
+// appeared, changed or disappeared since the previous walk. It works on
+// NFS and SMB mounts, where inotify only sees local changes.
+type pollSource struct {
+	root     string
+	interval time.Duration
+	match    func(string) bool
+	events   chan<- string
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newPollSource starts polling root
+func newPollSource(root string, interval time.Duration, match func(string) bool, events chan<- string) *pollSource {
+	s := &pollSource{
+		root:     root,
+		interval: interval,
+		match:    match,
+		events:   events,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Close stops polling
+func (s *pollSource) Close() error {
+	close(s.stop)
+	<-s.done
+	return nil
+}
+
+// run compares each walk with the previous one
+func (s *pollSource) run() {
+	defer close(s.done)
+
+	previous := s.snapshot()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		// An unreachable root would look like every file was deleted
+		if _, err := os.Stat(s.root); err != nil {
+			continue
+		}
+
+		current := s.snapshot()
+		for path, state := range current {
+			if old, ok := previous[path]; !ok || old.size != state.size || !old.modTime.Equal(state.modTime) {
+				if !s.send(path) {
+					return
+				}
+			}
+		}
+		for path := range previous {
+			if _, ok := current[path]; !ok {
+				if !s.send(path) {
+					return
+				}
+			}
+		}
+		previous = current
+	}
+}
+
+// send reports a path, giving up when the source is closed
+func (s *pollSource) send(path string) bool {
+	select {
+	case s.events <- path:
+		return true
+	case <-s.stop:
+		return false
+	}
+}
+
+// snapshot records the size and modification time of the matching files
+func (s *pollSource) snapshot() map[string]fileState {
+	files := make(map[string]fileState)
+	filepath.Walk(s.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil // Skip files we can't access
+		}
+		if !info.IsDir() && s.match(path) {
+			files[path] = fileState{size: info.Size(), modTime: info.ModTime()}
+		}
+		return nil
+	})
+	return files
+}
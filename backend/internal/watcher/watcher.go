@@ -0,0 +1,257 @@
+package watcher
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wysentanu/dlna-movie-cast/internal/config"
+	"github.com/wysentanu/dlna-movie-cast/internal/library"
+)
+
+// checkInterval is how often pending paths are examined
+const checkInterval = time.Second
+
+// purgeInterval is how often movies missing past the grace period are purged
+const purgeInterval = time.Hour
+
+// source reports changed paths below one media root
+type source interface {
+	Close() error
+}
+
+// pendingPath is a changed path waiting for events to stop and its size to
+// settle before the library is updated
+type pendingPath struct {
+	lastEvent time.Time
+	size      int64
+	modTime   time.Time
+	stableAt  time.Time // When the current size was first seen
+}
+
+// Watcher keeps the library in step with the media folders, adding,
+// updating and removing single movies as files change
+type Watcher struct {
+	config   *config.Config
+	library  *library.Library
+	onChange func() // Called after the library changed, e.g. to bump the DLNA update ID
+
+	events  chan string
+	sources []source
+	pending map[string]*pendingPath // Only touched by run
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New creates a watcher and starts watching every media path. With
+// WatchMode "off" it does nothing.
+func New(cfg *config.Config, lib *library.Library, onChange func()) *Watcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &Watcher{
+		config:   cfg,
+		library:  lib,
+		onChange: onChange,
+		events:   make(chan string, 1024),
+		pending:  make(map[string]*pendingPath),
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+
+	if cfg.WatchMode == "off" {
+		close(w.done)
+		return w
+	}
+
+	for _, root := range cfg.MediaPaths {
+		if src := w.watch(root); src != nil {
+			w.sources = append(w.sources, src)
+		}
+	}
+	go w.run()
+	return w
+}
+
+// watch starts the event source for one media root. inotify is used unless
+// polling was asked for or the root is on a network filesystem, where
+// changes made by other machines raise no events.
+func (w *Watcher) watch(root string) source {
+	mode := w.config.WatchMode
+	if mode == "auto" && isNetworkFS(root) {
+		mode = "poll"
+	}
+
+	if mode != "poll" {
+		src, err := newInotifySource(root, w.relevant, w.events)
+		if err == nil {
+			log.Printf("Watcher: watching %s with inotify", root)
+			return src
+		}
+		log.Printf("Watcher: inotify unavailable for %s (%v), polling every %v", root, err, w.config.WatchPollInterval)
+	} else {
+		log.Printf("Watcher: polling %s every %v", root, w.config.WatchPollInterval)
+	}
+
+	return newPollSource(root, w.config.WatchPollInterval, w.relevant, w.events)
+}
+
+// Stop closes the event sources and waits for pending work to finish
+func (w *Watcher) Stop() {
+	for _, src := range w.sources {
+		src.Close()
+	}
+	w.cancel()
+	<-w.done
+}
+
+// relevant reports whether a file can change the library
+func (w *Watcher) relevant(path string) bool {
+	return w.library.IsMediaFile(path) || library.IsSubtitleExtension(strings.ToLower(filepath.Ext(path)))
+}
+
+// run collects events and applies settled changes to the library
+func (w *Watcher) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	purgeTicker := time.NewTicker(purgeInterval)
+	defer purgeTicker.Stop()
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case path := <-w.events:
+			w.touch(path)
+		case <-ticker.C:
+			w.checkPending()
+		case <-purgeTicker.C:
+			w.purge()
+		}
+	}
+}
+
+// touch records an event for a path, restarting its debounce
+func (w *Watcher) touch(path string) {
+	p, ok := w.pending[path]
+	if !ok {
+		p = &pendingPath{size: -1}
+		w.pending[path] = p
+	}
+	p.lastEvent = time.Now()
+}
+
+// checkPending updates the library for paths that have been quiet for the
+// debounce time and whose size has stopped growing
+func (w *Watcher) checkPending() {
+	now := time.Now()
+	changed := false
+
+	for path, p := range w.pending {
+		if now.Sub(p.lastEvent) < w.config.WatchDebounce {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			delete(w.pending, path)
+			changed = w.removed(path) || changed
+			continue
+		}
+
+		if info.IsDir() {
+			// A folder moved or copied in: queue what's inside
+			delete(w.pending, path)
+			w.queueTree(path)
+			continue
+		}
+
+		if info.Size() != p.size || !info.ModTime().Equal(p.modTime) {
+			p.size = info.Size()
+			p.modTime = info.ModTime()
+			p.stableAt = now
+			continue
+		}
+		if now.Sub(p.stableAt) < w.config.WatchSettleTime {
+			continue // Still being written
+		}
+
+		delete(w.pending, path)
+		changed = w.updated(path) || changed
+
+		if w.ctx.Err() != nil {
+			return
+		}
+	}
+
+	if changed && w.onChange != nil {
+		w.onChange()
+	}
+}
+
+// purge drops movies that have been missing past the grace period
+func (w *Watcher) purge() {
+	n, err := w.library.PurgeMissing()
+	if err != nil {
+		log.Printf("Watcher: failed to purge missing movies: %v", err)
+	}
+	if n > 0 && w.onChange != nil {
+		w.onChange()
+	}
+}
+
+// queueTree adds the relevant files below dir to the pending set
+func (w *Watcher) queueTree(dir string) {
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && w.relevant(path) {
+			w.touch(path)
+		}
+		return nil
+	})
+}
+
+// updated applies a new or changed file and reports whether the library changed
+func (w *Watcher) updated(path string) bool {
+	var changed bool
+	var err error
+	if w.library.IsMediaFile(path) {
+		changed, err = w.library.ScanFile(w.ctx, path)
+	} else {
+		changed, err = w.library.RefreshSubtitles(path)
+	}
+	if err != nil {
+		log.Printf("Watcher: failed to update %s: %v", path, err)
+	}
+	if changed {
+		log.Printf("Watcher: updated %s", path)
+	}
+	return changed
+}
+
+// removed takes a deleted file or folder offline and reports whether the
+// library changed. Folder names carry no extension, so a Subs/ folder going
+// away also refreshes the subtitles next to it.
+func (w *Watcher) removed(path string) bool {
+	changed, err := w.library.RemovePath(path)
+	if err != nil {
+		log.Printf("Watcher: failed to remove %s: %v", path, err)
+	}
+	if changed {
+		log.Printf("Watcher: %s went offline", path)
+	}
+
+	if !w.library.IsMediaFile(path) {
+		refreshed, err := w.library.RefreshSubtitles(path)
+		if err != nil {
+			log.Printf("Watcher: failed to refresh subtitles for %s: %v", path, err)
+		}
+		changed = changed || refreshed
+	}
+	return changed
+}
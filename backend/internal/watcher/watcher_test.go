@@ -0,0 +1,140 @@
+package watcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wysentanu/dlna-movie-cast/internal/config"
+	"github.com/wysentanu/dlna-movie-cast/internal/library"
+)
+
+// fakeFFprobe is a shell script standing in for ffprobe
+const fakeFFprobe = `#!/bin/sh
+echo '{"format":{"duration":"60","size":"10"},"streams":[{"codec_type":"video","codec_name":"h264","width":640,"height":360,"index":0}]}'
+`
+
+// newTestWatcher returns a watcher over a temporary media folder with an
+// in-memory library. No event sources or loop run; tests drive touch and
+// checkPending themselves.
+func newTestWatcher(t *testing.T) (*Watcher, string, *int) {
+	t.Helper()
+	dir := t.TempDir()
+	media := filepath.Join(dir, "media")
+	if err := os.MkdirAll(media, 0755); err != nil {
+		t.Fatal(err)
+	}
+	ffprobe := filepath.Join(dir, "ffprobe")
+	if err := os.WriteFile(ffprobe, []byte(fakeFFprobe), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.MediaPaths = []string{media}
+	cfg.DBPath = ":memory:"
+	cfg.ThumbnailDir = ""
+	cfg.FFprobePath = ffprobe
+	cfg.WatchDebounce = time.Hour
+	cfg.WatchSettleTime = time.Hour
+
+	lib, err := library.NewLibrary(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lib.Close() })
+
+	changes := 0
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	w := &Watcher{
+		config:   cfg,
+		library:  lib,
+		onChange: func() { changes++ },
+		pending:  make(map[string]*pendingPath),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	return w, media, &changes
+}
+
+func TestCheckPendingWaitsForDebounceAndSettle(t *testing.T) {
+	w, media, changes := newTestWatcher(t)
+	path := filepath.Join(media, "Movie.mkv")
+	if err := os.WriteFile(path, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w.touch(path)
+	past := time.Now().Add(-2 * time.Hour)
+
+	// Events are still coming in
+	w.checkPending()
+	if p := w.pending[path]; p == nil || p.size != -1 {
+		t.Fatalf("pending = %+v, want the path untouched during the debounce", p)
+	}
+
+	// Quiet now: the size is recorded and has to hold
+	w.pending[path].lastEvent = past
+	w.checkPending()
+	if p := w.pending[path]; p == nil || p.size != int64(len("partial")) {
+		t.Fatalf("pending = %+v, want the size recorded", p)
+	}
+
+	// Still growing: the settle time starts over
+	w.pending[path].stableAt = past
+	if err := os.WriteFile(path, []byte("partial and then some"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w.checkPending()
+	if p := w.pending[path]; p == nil || !p.stableAt.After(past) {
+		t.Fatalf("pending = %+v, want the settle time restarted by growth", p)
+	}
+	w.checkPending()
+	if len(w.library.GetAllMovies()) != 0 || *changes != 0 {
+		t.Fatal("library updated before the file settled")
+	}
+
+	w.pending[path].stableAt = past
+	w.checkPending()
+	if _, ok := w.pending[path]; ok {
+		t.Error("settled path still pending")
+	}
+	if movies := w.library.GetAllMovies(); len(movies) != 1 || movies[0].FilePath != path {
+		t.Fatalf("library has %d movies after the file settled, want it", len(movies))
+	}
+	if *changes != 1 {
+		t.Errorf("onChange called %d times, want once", *changes)
+	}
+}
+
+func TestCheckPendingTakesDeletedFilesOffline(t *testing.T) {
+	w, media, changes := newTestWatcher(t)
+	keep := filepath.Join(media, "Keep.mkv")
+	path := filepath.Join(media, "Movie.mkv")
+	for _, p := range []string{keep, path} {
+		if err := os.WriteFile(p, []byte(p), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.library.Scan(context.Background(), nil); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	w.touch(path)
+	w.pending[path].lastEvent = time.Now().Add(-2 * time.Hour)
+	w.checkPending()
+
+	if len(w.pending) != 0 {
+		t.Errorf("pending = %v, want the deleted path handled", w.pending)
+	}
+	if offline := w.library.GetOfflineMovies(); len(offline) != 1 || offline[0].FilePath != path {
+		t.Errorf("offline movies = %d, want %s", len(offline), path)
+	}
+	if *changes != 1 {
+		t.Errorf("onChange called %d times, want once", *changes)
+	}
+}
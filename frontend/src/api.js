@@ -68,9 +68,11 @@ export async function castMovie(movieId, deviceUuid, options = {}) {
         body: JSON.stringify({
             movie_id: movieId,
             device_uuid: deviceUuid,
-            subtitle_path: options.subtitlePath || '',
-            subtitle_index: options.subtitleIndex || 0,
+            subtitle_id: options.subtitleId || '',
             transcode: options.transcode || false,
+            audio_channels: options.audioChannels || 0,
+            loudnorm: options.loudnorm || false,
+            night_mode: options.nightMode || false,
         }),
     });
 }
@@ -108,6 +110,13 @@ export async function scanLibrary() {
     return fetchJSON(`${API_BASE}/scan`, { method: 'POST' });
 }
 
+/**
+ * Get the running or last library scan job
+ */
+export async function getScanStatus() {
+    return fetchJSON(`${API_BASE}/scan`);
+}
+
 /**
  * Format duration from seconds to HH:MM:SS
  */
@@ -67,6 +67,33 @@ function setupNavigation() {
   });
 }
 
+// Reload movies while a scan runs, until it finishes
+async function followScan() {
+  try {
+    const job = await api.getScanStatus();
+    await loadMovies();
+    if (job.status === 'running') {
+      elements.scanBtn.title = `Scanning ${job.processed + job.failed} of ${job.found}`;
+      setTimeout(followScan, 3000);
+      return;
+    }
+    const summary = `${job.added} added, ${job.updated} updated, ${job.removed} removed`;
+    if (job.status === 'failed') {
+      showToast('Scan failed: ' + job.error, 'error');
+    } else if (job.status === 'cancelled') {
+      showToast('Scan cancelled: ' + summary, 'info');
+    } else if (job.failed > 0) {
+      showToast(`Scan finished: ${summary}, ${job.failed} could not be read`, 'error');
+    } else {
+      showToast('Scan finished: ' + summary, 'success');
+    }
+  } catch (error) {
+    showToast('Failed to get scan status: ' + error.message, 'error');
+  }
+  elements.scanBtn.classList.remove('spinning');
+  elements.scanBtn.title = '';
+}
+
 // Event Listeners
 function setupEventListeners() {
   // Search
@@ -81,11 +108,9 @@ function setupEventListeners() {
     try {
       await api.scanLibrary();
       showToast('Library scan started', 'success');
-      // Reload movies after a delay
-      setTimeout(loadMovies, 3000);
+      followScan();
     } catch (error) {
       showToast('Failed to start scan: ' + error.message, 'error');
-    } finally {
       elements.scanBtn.classList.remove('spinning');
     }
   });
@@ -247,7 +272,7 @@ function renderMovieDetail(movie) {
               <span>No subtitles</span>
             </label>
             ${movie.subtitles.map((sub, idx) => `
-              <label class="subtitle-item" data-path="${escapeHtml(sub.file_path || '')}" data-index="${sub.index}">
+              <label class="subtitle-item" data-id="${escapeHtml(sub.id || '')}">
                 <input type="radio" name="subtitle" value="${idx}">
                 <span>${sub.language ? sub.language.toUpperCase() : 'Unknown'} ${sub.is_external ? '(External SRT)' : '(Embedded)'}</span>
               </label>
@@ -267,6 +292,15 @@ function renderMovieDetail(movie) {
     }
         </select>
       </div>
+      <div class="device-select">
+        <label>Audio</label>
+        <select id="audioSelect">
+          <option value="">Original</option>
+          <option value="stereo">Stereo (TV speakers)</option>
+          <option value="loudnorm">Stereo, normalized loudness</option>
+          <option value="night">Night mode</option>
+        </select>
+      </div>
       <div class="cast-actions">
         <button class="btn btn-primary btn-lg" id="castBtn" ${state.devices.length === 0 ? 'disabled' : ''}>
           <svg viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2">
@@ -290,10 +324,7 @@ function renderMovieDetail(movie) {
     item.addEventListener('click', () => {
       document.querySelectorAll('.subtitle-item').forEach(i => i.classList.remove('selected'));
       item.classList.add('selected');
-      state.selectedSubtitle = {
-        path: item.dataset.path,
-        index: parseInt(item.dataset.index) || -1
-      };
+      state.selectedSubtitle = item.dataset.id ? { id: item.dataset.id } : null;
     });
   });
 
@@ -302,6 +333,20 @@ function renderMovieDetail(movie) {
   document.getElementById('castWithSubBtn').addEventListener('click', () => castMovie(true));
 }
 
+// Map the audio select to cast options
+function audioOptions(mode) {
+  switch (mode) {
+    case 'stereo':
+      return { audioChannels: 2 };
+    case 'loudnorm':
+      return { audioChannels: 2, loudnorm: true };
+    case 'night':
+      return { audioChannels: 2, loudnorm: true, nightMode: true };
+    default:
+      return {};
+  }
+}
+
 // Cast movie
 async function castMovie(withSubtitles) {
   const deviceSelect = document.getElementById('deviceSelect');
@@ -315,14 +360,11 @@ async function castMovie(withSubtitles) {
   try {
     const options = {
       transcode: withSubtitles,
+      ...audioOptions(document.getElementById('audioSelect').value),
     };
 
     if (withSubtitles && state.selectedSubtitle) {
-      if (state.selectedSubtitle.path) {
-        options.subtitlePath = state.selectedSubtitle.path;
-      } else if (state.selectedSubtitle.index >= 0) {
-        options.subtitleIndex = state.selectedSubtitle.index;
-      }
+      options.subtitleId = state.selectedSubtitle.id;
     }
 
     await api.castMovie(state.currentMovie.id, deviceUuid, options);